@@ -0,0 +1,394 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"go/token"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/pmezard/go-difflib/difflib"
+)
+
+// Exit codes of the commands. check, diff and report exit with exitDiffers
+// when the output or the proto isn't in line with the Go structs.
+const (
+	exitOK      = 0
+	exitDiffers = 1
+	exitError   = 2
+)
+
+// command is a subcommand of go2proto, run with the arguments after its name.
+type command struct {
+	name    string
+	summary string
+	run     func(args []string) int
+}
+
+func commands() []command {
+	return []command{
+		{"generate", "write the proto generated from Go packages", runGenerate},
+		{"check", "check that the generated proto is up to date", runCheck},
+		{"diff", "print the changes generate would make to the proto", runDiff},
+		{"report", "list the drift between Go packages and an existing proto", runReport},
+		{"structs", "write Go structs from a proto", runStructs},
+		{"init", "write a starter config file", runInit},
+	}
+}
+
+// run runs the command named by the first of args and returns the exit code.
+// Arguments starting with a flag are the flags of generate, from before the
+// subcommands.
+func run(args []string) int {
+	if len(args) == 0 {
+		usage(os.Stderr)
+		return exitError
+	}
+	name := args[0]
+	switch name {
+	case "help", "-h", "-help", "--help":
+		usage(os.Stdout)
+		return exitOK
+	}
+	if strings.HasPrefix(name, "-") {
+		log.Print("go2proto without a command is deprecated, use go2proto generate")
+		return runGenerate(args)
+	}
+	for _, cmd := range commands() {
+		if cmd.name == name {
+			return cmd.run(args[1:])
+		}
+	}
+	fmt.Fprintf(os.Stderr, "go2proto: unknown command %q\n\n", name)
+	usage(os.Stderr)
+	return exitError
+}
+
+func usage(w io.Writer) {
+	fmt.Fprint(w, "go2proto generates Protobuf messages from Go structs.\n\nusage: go2proto <command> [flags]\n\ncommands:\n")
+	for _, cmd := range commands() {
+		fmt.Fprintf(w, "  %-9s %s\n", cmd.name, cmd.summary)
+	}
+	fmt.Fprint(w, "\nRun go2proto <command> -h for the flags of a command.\n")
+}
+
+// newFlagSet returns the flag set of a command, whose help shows synopsis and
+// description before the flags.
+func newFlagSet(name, synopsis, description string) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: go2proto %s %s\n\n%s\n\nflags:\n", name, synopsis, description)
+		flags.PrintDefaults()
+	}
+	return flags
+}
+
+// parseFlags parses args into flags. If the command shouldn't run, for help,
+// a bad flag or a stray argument, it returns false and the exit code.
+func parseFlags(flags *flag.FlagSet, args []string) (int, bool) {
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitOK, false
+		}
+		return exitError, false
+	}
+	if flags.NArg() > 0 {
+		fmt.Fprintf(flags.Output(), "unexpected arguments: %s\n", strings.Join(flags.Args(), " "))
+		flags.Usage()
+		return exitError, false
+	}
+	return exitOK, true
+}
+
+// fail logs err and returns the exit code of errors.
+func fail(err error) int {
+	log.Print(err)
+	return exitError
+}
+
+// generateFlags are the flags of the commands generating the output proto.
+type generateFlags struct {
+	protoFolder        string
+	pkgs               arrFlags
+	currProtoFileName  string
+	configFileName     string
+	filter             string
+	useSnakeFieldNames bool
+	merge              bool
+	validateRules      bool
+	jsonNames          bool
+	syntax             string
+	order              string
+}
+
+func (f *generateFlags) register(flags *flag.FlagSet) {
+	flags.StringVar(&f.protoFolder, "f", "", "Folder of the output proto, output.proto. Required.")
+	flags.Var(&f.pkgs, "p", "Go package to generate messages from, repeatable. Required.")
+	flags.StringVar(&f.currProtoFileName, "c", "", "Full filepath for existing version of proto, if applicable.")
+	flags.StringVar(&f.configFileName, "config", "", "Full filepath for a YAML config file, if applicable.")
+	flags.StringVar(&f.filter, "filter", "", "Filter struct names.")
+	flags.BoolVar(&f.useSnakeFieldNames, "s", false, "Use to set proto structs names to snake_case instead of camelCase.")
+	flags.BoolVar(&f.merge, "merge", false, "Use to update the generated messages of an existing output file in place, keeping everything else.")
+	flags.BoolVar(&f.validateRules, "validate-rules", false, "Use to translate validate struct tags into buf.validate field options.")
+	flags.BoolVar(&f.jsonNames, "json-names", false, "Use to set json_name on fields whose json tag differs from the protojson name.")
+	flags.StringVar(&f.syntax, "syntax", "", "Syntax of the output proto: proto3, proto2 or the edition 2023. Default proto3.")
+	flags.StringVar(&f.order, "order", "", "Order of messages and fields: existing, alphabetical, source or number. Default sorts messages by name and keeps fields in Go order.")
+}
+
+// missing reports whether a required flag isn't set.
+func (f *generateFlags) missing() bool {
+	return len(f.pkgs) == 0 || f.protoFolder == ""
+}
+
+// config loads the config file, if any, and applies the flags over it.
+func (f *generateFlags) config() (Config, error) {
+	cfg, err := LoadConfig(f.configFileName)
+	if err != nil {
+		return Config{}, err
+	}
+	if f.filter != "" {
+		cfg.Filter = f.filter
+	}
+	if f.useSnakeFieldNames {
+		cfg.UseSnakeFieldNames = true
+	}
+	if f.merge {
+		cfg.Merge = true
+	}
+	if f.validateRules {
+		cfg.ValidateRules = true
+	}
+	if f.jsonNames {
+		cfg.JSONNames = true
+	}
+	if f.syntax != "" {
+		cfg.Syntax = Syntax(f.syntax)
+	}
+	if f.order != "" {
+		cfg.Order = Ordering(f.order)
+	}
+	if err := cfg.validate(); err != nil {
+		return Config{}, err
+	}
+	return cfg, nil
+}
+
+func (f *generateFlags) generator(cfg Config) (*generator, error) {
+	if err := checkOutFolder(f.protoFolder); err != nil {
+		return nil, err
+	}
+	return &generator{
+		cfg:               cfg,
+		protoFolder:       f.protoFolder,
+		currProtoFileName: f.currProtoFileName,
+	}, nil
+}
+
+func runGenerate(args []string) int {
+	flags := newFlagSet("generate", "-f <proto folder> -p <package> [flags]",
+		"Writes output.proto to the proto folder, with a message per Go struct of the packages.")
+	var f generateFlags
+	f.register(flags)
+	descriptor := flags.Bool("descriptor", false, "Use to also write output.binpb, a binary FileDescriptorSet of the output proto.")
+	jsonSchema := flags.Bool("json-schema", false, "Use to also write a JSON Schema document per Go struct to the schema folder.")
+	watch := flags.Bool("watch", false, "Use to keep running and regenerate the output when the Go files of the packages change.")
+	if code, ok := parseFlags(flags, args); !ok {
+		return code
+	}
+	if f.missing() {
+		flags.Usage()
+		return exitError
+	}
+
+	cfg, err := f.config()
+	if err != nil {
+		return fail(err)
+	}
+	if *descriptor {
+		cfg.Descriptor = true
+	}
+	if *jsonSchema {
+		cfg.JSONSchema = true
+	}
+	g, err := f.generator(cfg)
+	if err != nil {
+		return fail(err)
+	}
+
+	pwd, err := os.Getwd()
+	if err != nil {
+		return fail(err)
+	}
+	fset := token.NewFileSet()
+	pkgs, err := loadPackages(pwd, fset, f.pkgs)
+	if err != nil {
+		return fail(err)
+	}
+	msgs, err := g.generate(pkgs)
+	if err != nil {
+		return fail(err)
+	}
+	if *watch {
+		watchPackages(g, pwd, fset, pkgs, msgs)
+	}
+	return exitOK
+}
+
+// renderWithFlags renders the output proto of the generation flags of a
+// command, returning it along with the proto currently written, nil if there
+// is none.
+func renderWithFlags(name, description string, args []string) (g *generator, current, rendered []byte, code int) {
+	flags := newFlagSet(name, "-f <proto folder> -p <package> [flags]", description)
+	var f generateFlags
+	f.register(flags)
+	if parsed, ok := parseFlags(flags, args); !ok {
+		return nil, nil, nil, parsed
+	}
+	if f.missing() {
+		flags.Usage()
+		return nil, nil, nil, exitError
+	}
+
+	cfg, err := f.config()
+	if err != nil {
+		return nil, nil, nil, fail(err)
+	}
+	g, err = f.generator(cfg)
+	if err != nil {
+		return nil, nil, nil, fail(err)
+	}
+	pwd, err := os.Getwd()
+	if err != nil {
+		return nil, nil, nil, fail(err)
+	}
+	pkgs, err := loadPackages(pwd, token.NewFileSet(), f.pkgs)
+	if err != nil {
+		return nil, nil, nil, fail(err)
+	}
+	_, _, rendered, err = g.render(pkgs)
+	if err != nil {
+		return nil, nil, nil, fail(err)
+	}
+	current, err = ioutil.ReadFile(g.outFileName())
+	if err != nil && !os.IsNotExist(err) {
+		return nil, nil, nil, fail(err)
+	}
+	return g, current, rendered, exitOK
+}
+
+func runCheck(args []string) int {
+	g, current, rendered, code := renderWithFlags("check", "Checks that output.proto is what generate would write, "+
+		"exiting with 1 if it isn't. The descriptor, JSON schemas and converters aren't checked.", args)
+	if g == nil {
+		return code
+	}
+	switch {
+	case current == nil:
+		fmt.Printf("%s doesn't exist, run go2proto generate\n", g.outFileName())
+		return exitDiffers
+	case !bytes.Equal(current, rendered):
+		fmt.Printf("%s is out of date, run go2proto generate\n", g.outFileName())
+		return exitDiffers
+	}
+	fmt.Printf("%s is up to date\n", g.outFileName())
+	return exitOK
+}
+
+func runDiff(args []string) int {
+	g, current, rendered, code := renderWithFlags("diff", "Prints a unified diff of the changes generate would make to output.proto, "+
+		"exiting with 1 if there are any.", args)
+	if g == nil {
+		return code
+	}
+	diff, err := unifiedDiff(g.outFileName(), current, rendered)
+	if err != nil {
+		return fail(err)
+	}
+	if diff == "" {
+		return exitOK
+	}
+	fmt.Print(diff)
+	return exitDiffers
+}
+
+// unifiedDiff returns the unified diff from the current to the generated
+// content of the file filename, empty if they're the same.
+func unifiedDiff(filename string, current, generated []byte) (string, error) {
+	return difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        splitLines(current),
+		B:        splitLines(generated),
+		FromFile: filename,
+		ToFile:   filename + " (generated)",
+		Context:  3,
+	})
+}
+
+// splitLines splits src into lines keeping their newline, without the empty
+// line difflib.SplitLines adds after the last one.
+func splitLines(src []byte) []string {
+	lines := strings.SplitAfter(string(src), "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// starterConfig is the config file written by init: the config keys with
+// their default, the options to turn on commented out.
+const starterConfig = `# go2proto config, see https://github.com/emarcey/go2proto#configuration
+# Flags given to go2proto take precedence over this file.
+
+# only generate the structs whose name contains filter
+filter: ""
+# snake_case field names instead of camelCase
+snake_field_names: false
+# proto3, proto2 or 2023
+syntax: proto3
+# existing, alphabetical, source or number; empty sorts messages by name
+order: ""
+# update the generated messages of an existing output in place
+merge: false
+# set json_name on fields whose json tag differs from the protojson name
+json_names: false
+# translate validate struct tags into buf.validate options
+validate_rules: false
+# also write output.binpb and JSON Schema documents
+descriptor: false
+json_schema: false
+
+# encodings:
+#   types:        # keyed by Go type name
+#     int64: sint
+#   fields:       # keyed by <Message>.<GoField>
+#     User.ID: fixed
+# interfaces:     # oneof members of interface fields, keyed by interface name
+#   Shape: [Circle, Square]
+# tags:
+#   presets: [elasticsearch, tagger]
+# converters:
+#   proto_import: github.com/acme/api/pb
+`
+
+func runInit(args []string) int {
+	flags := newFlagSet("init", "[-o <config file>] [-force]",
+		"Writes a starter config file, to pass to the other commands with -config.")
+	configFileName := flags.String("o", "go2proto.yaml", "Full filepath of the config file to write.")
+	force := flags.Bool("force", false, "Use to overwrite an existing config file.")
+	if code, ok := parseFlags(flags, args); !ok {
+		return code
+	}
+
+	if _, err := os.Stat(*configFileName); err == nil && !*force {
+		return fail(fmt.Errorf("%s already exists, use -force to overwrite it", *configFileName))
+	}
+	if err := ioutil.WriteFile(*configFileName, []byte(starterConfig), 0644); err != nil {
+		return fail(err)
+	}
+	fmt.Printf("wrote %s\n", *configFileName)
+	return exitOK
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStarterConfig(t *testing.T) {
+	t.Parallel()
+
+	filename := filepath.Join(t.TempDir(), "go2proto.yaml")
+	if err := os.WriteFile(filename, []byte(starterConfig), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := LoadConfig(filename)
+	assert.NoError(t, err)
+	assert.Equal(t, Config{Syntax: SyntaxProto3}, cfg)
+}
+
+func TestUnifiedDiff(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		testName       string
+		givenCurrent   string
+		givenGenerated string
+		expected       string
+	}{
+		{
+			testName:       "same",
+			givenCurrent:   "a\nb\n",
+			givenGenerated: "a\nb\n",
+			expected:       "",
+		},
+		{
+			testName:       "changed line",
+			givenCurrent:   "a\nb\n",
+			givenGenerated: "a\nc\n",
+			expected: `--- out/output.proto
++++ out/output.proto (generated)
+@@ -1,2 +1,2 @@
+ a
+-b
++c
+`,
+		},
+		{
+			testName:       "missing file",
+			givenCurrent:   "",
+			givenGenerated: "a\n",
+			expected: `--- out/output.proto
++++ out/output.proto (generated)
+@@ -0,0 +1 @@
++a
+`,
+		},
+	}
+
+	for _, testCase := range testCases {
+		actual, err := unifiedDiff("out/output.proto", []byte(testCase.givenCurrent), []byte(testCase.givenGenerated))
+		assert.NoError(t, err, testCase.testName)
+		assert.Equal(t, testCase.expected, actual, testCase.testName)
+	}
+}
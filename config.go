@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Config holds the generation options that can be set in a YAML config file.
+// Command line flags take precedence over the file.
+type Config struct {
+	Filter             string `yaml:"filter"`
+	UseSnakeFieldNames bool   `yaml:"snake_field_names"`
+	// Merge updates the generated messages of an existing output file in
+	// place instead of overwriting it, see mergedOutput.
+	Merge bool `yaml:"merge"`
+	// Descriptor also writes a binary FileDescriptorSet of the output, see
+	// buildDescriptorSet.
+	Descriptor bool `yaml:"descriptor"`
+	// ValidateRules translates the go-playground validate tags into
+	// buf.validate field options, see translateValidateTag.
+	ValidateRules bool `yaml:"validate_rules"`
+	// JSONNames sets json_name on the fields whose json tag differs from the
+	// name protojson derives, see jsonNameOption.
+	JSONNames bool `yaml:"json_names"`
+	// JSONSchema also writes a JSON Schema document per Go struct, see
+	// buildJSONSchemas.
+	JSONSchema bool `yaml:"json_schema"`
+	// Syntax is the syntax of the output proto, proto3 by default.
+	Syntax    Syntax         `yaml:"syntax"`
+	Order     Ordering       `yaml:"order"`
+	Encodings EncodingConfig `yaml:"encodings"`
+	// Interfaces lists the Go types used as the oneof members of fields of
+	// the interface type, keyed by interface name. Interfaces not listed use
+	// every struct type of the loaded packages that implements them.
+	Interfaces map[string][]string `yaml:"interfaces"`
+	// GenericNameTemplate is the text/template naming the messages generated
+	// for instantiated generic types, see genericName.
+	GenericNameTemplate string `yaml:"generic_name_template"`
+	// Tags declares the processing of struct tags, see TagConfig.
+	Tags TagConfig `yaml:"tags"`
+	// Converters generates Go functions converting between the structs and
+	// the types protoc-gen-go generates from the output proto.
+	Converters ConverterConfig `yaml:"converters"`
+}
+
+// ConverterConfig enables the converters: ProtoImport is the import path of
+// the package generated from the output proto, Package the name of the
+// package of the converters file, converters by default. Tests also writes
+// round-trip tests of the converters.
+type ConverterConfig struct {
+	ProtoImport string `yaml:"proto_import"`
+	Package     string `yaml:"package"`
+	Tests       bool   `yaml:"tests"`
+}
+
+// EncodingConfig selects the wire encoding of integer fields. Types is keyed
+// by Go type name, either a basic type (int64) or a named type (Hash); Fields
+// is keyed by <Message>.<GoField>. Field entries win over type entries.
+type EncodingConfig struct {
+	Types  map[string]Encoding `yaml:"types"`
+	Fields map[string]Encoding `yaml:"fields"`
+}
+
+func LoadConfig(filename string) (Config, error) {
+	if filename == "" {
+		return Config{}, nil
+	}
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return Config{}, err
+	}
+
+	var cfg Config
+	if err := yaml.UnmarshalStrict(data, &cfg); err != nil {
+		return Config{}, fmt.Errorf("%s: %v", filename, err)
+	}
+	if err := cfg.validate(); err != nil {
+		return Config{}, fmt.Errorf("%s: %v", filename, err)
+	}
+	return cfg, nil
+}
+
+func (c Config) validate() error {
+	if err := c.Order.validate(); err != nil {
+		return fmt.Errorf("order: %v", err)
+	}
+	if err := c.Syntax.validate(); err != nil {
+		return fmt.Errorf("syntax: %v", err)
+	}
+	// the converters expect the value fields protoc-gen-go generates for
+	// proto3, not the pointers of fields with explicit presence
+	if c.Converters.ProtoImport != "" && !c.Syntax.isProto3() {
+		return fmt.Errorf("converters: only supported with the proto3 syntax")
+	}
+	if _, err := parseGenericNameTemplate(c.GenericNameTemplate); err != nil {
+		return fmt.Errorf("generic_name_template: %v", err)
+	}
+	if err := c.Tags.validate(); err != nil {
+		return fmt.Errorf("tags: %v", err)
+	}
+	for name, enc := range c.Encodings.Types {
+		if err := enc.validate(); err != nil {
+			return fmt.Errorf("encodings.types.%s: %v", name, err)
+		}
+	}
+	for name, enc := range c.Encodings.Fields {
+		if err := enc.validate(); err != nil {
+			return fmt.Errorf("encodings.fields.%s: %v", name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,689 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"go/types"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	timestampTypeName = "google.protobuf.Timestamp"
+	timestamppbImport = "google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// goSizes are the sizes of Go types; int and uint are 64 bits, like in
+// scalarType.
+var goSizes = types.SizesFor("gc", "amd64")
+
+// protoGoScalars maps proto scalars to the Go types protoc-gen-go uses.
+var protoGoScalars = map[string]string{
+	"double":   "float64",
+	"float":    "float32",
+	"int32":    "int32",
+	"sint32":   "int32",
+	"sfixed32": "int32",
+	"int64":    "int64",
+	"sint64":   "int64",
+	"sfixed64": "int64",
+	"uint32":   "uint32",
+	"fixed32":  "uint32",
+	"uint64":   "uint64",
+	"fixed64":  "uint64",
+	"bool":     "bool",
+	"string":   "string",
+	"bytes":    "[]byte",
+}
+
+// writeConverters writes a Go file with a <Message>ToProto and a
+// <Message>FromProto function for every message generated from a Go struct,
+// and their round-trip tests if enabled.
+func writeConverters(msgs []message, cfg ConverterConfig, folder string) error {
+	src, err := generateConverters(msgs, cfg)
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(filepath.Join(folder, "converters.go"), src, 0644); err != nil {
+		return err
+	}
+	if !cfg.Tests {
+		return nil
+	}
+	src, err = generateRoundTripTests(msgs, cfg)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(folder, "converters_test.go"), src, 0644)
+}
+
+func generateConverters(msgs []message, cfg ConverterConfig) ([]byte, error) {
+	g := newConverterGen(msgs, cfg)
+	body := g.converters(msgs)
+	g.used[cfg.ProtoImport] = struct{}{}
+	return g.file(cfg, body)
+}
+
+func newConverterGen(msgs []message, cfg ConverterConfig) *converterGen {
+	g := &converterGen{
+		messages: make(map[string]message),
+		structs:  make(map[string][]string),
+		imports:  map[string]string{cfg.ProtoImport: "pb"},
+		aliases:  map[string]struct{}{"pb": {}},
+		used:     make(map[string]struct{}),
+		losses:   make(map[string][]*fieldLoss),
+		oneofs:   make(map[string]*oneofTypes),
+		named:    make(map[string]struct{}),
+	}
+	// the standard library packages of the round-trip tests
+	for path, name := range roundTripImports {
+		g.imports[path] = name
+		g.aliases[name] = struct{}{}
+		g.named[path] = struct{}{}
+	}
+	g.index(msgs)
+	return g
+}
+
+// converters returns the converter functions of msgs and their nested
+// messages.
+func (g *converterGen) converters(msgs []message) []byte {
+	var body bytes.Buffer
+	var write func(msgs []message)
+	write = func(msgs []message) {
+		for _, msg := range msgs {
+			if msg.goType != nil {
+				g.writeToProto(&body, msg)
+				g.writeFromProto(&body, msg)
+			}
+			write(msg.Nested)
+		}
+	}
+	write(msgs)
+	return body.Bytes()
+}
+
+// file returns the formatted Go file of body, importing the packages used by
+// it.
+func (g *converterGen) file(cfg ConverterConfig, body []byte) ([]byte, error) {
+	pkg := cfg.Package
+	if pkg == "" {
+		pkg = "converters"
+	}
+	var out bytes.Buffer
+	fmt.Fprintf(&out, "// Code generated by go2proto. DO NOT EDIT.\n\npackage %s\n\nimport (\n", pkg)
+	// the standard library comes first, like goimports does
+	var std, other []string
+	for path := range g.used {
+		if strings.Contains(strings.Split(path, "/")[0], ".") {
+			other = append(other, path)
+		} else {
+			std = append(std, path)
+		}
+	}
+	sort.Strings(std)
+	sort.Strings(other)
+	for i, paths := range [][]string{std, other} {
+		if i > 0 && len(std) > 0 && len(other) > 0 {
+			out.WriteString("\n")
+		}
+		for _, path := range paths {
+			if _, ok := g.named[path]; ok {
+				fmt.Fprintf(&out, "%q\n", path)
+			} else {
+				fmt.Fprintf(&out, "%s %q\n", g.imports[path], path)
+			}
+		}
+	}
+	out.WriteString(")\n")
+	out.Write(body)
+
+	src, err := format.Source(out.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("formatting %s: %v", pkg, err)
+	}
+	return src, nil
+}
+
+// converterGen writes the functions converting between the Go structs and the
+// types protoc-gen-go generates from the proto.
+type converterGen struct {
+	// messages are keyed by full name, structs are the full names of the
+	// messages generated from Go structs keyed by the type string of the
+	// struct; anonymous structs of the same shape have several
+	messages map[string]message
+	structs  map[string][]string
+	// imports are the aliases of the imported packages, keyed by path, used
+	// the paths imported by the file being written and named those whose
+	// alias is their package name
+	imports map[string]string
+	aliases map[string]struct{}
+	used    map[string]struct{}
+	named   map[string]struct{}
+	// losses are the fields of each message that don't survive a round
+	// trip, keyed by full name; field is the one being converted to proto
+	losses map[string][]*fieldLoss
+	field  *fieldLoss
+	// oneofs are the interfaces converted to oneofs, keyed by type string
+	oneofs map[string]*oneofTypes
+	// vars numbers the variables of the function being written
+	vars int
+}
+
+func (g *converterGen) index(msgs []message) {
+	for _, msg := range msgs {
+		g.messages[msg.fullName] = msg
+		if msg.goType != nil {
+			key := types.TypeString(msg.goType, nil)
+			g.structs[key] = append(g.structs[key], msg.fullName)
+		}
+		g.index(msg.Nested)
+	}
+}
+
+func (g *converterGen) writeToProto(w *bytes.Buffer, msg message) {
+	g.vars = 0
+	name := goMessageName(msg.fullName)
+	fmt.Fprintf(w, "\n// %sToProto converts a %s into its proto message.\n", name, g.typeString(msg.goType))
+	fmt.Fprintf(w, "func %sToProto(in *%s) *pb.%s {\n", name, g.typeString(msg.goType), name)
+	fmt.Fprintf(w, "if in == nil {\nreturn nil\n}\nout := &pb.%s{}\n", name)
+	for _, f := range msg.Fields {
+		if f.goType == nil {
+			continue
+		}
+		var conds []string
+		for _, e := range f.goEmbeds {
+			conds = append(conds, "in."+e.Path+" != nil")
+		}
+		if len(conds) > 0 {
+			fmt.Fprintf(w, "if %s {\n", strings.Join(conds, " && "))
+		}
+		g.field = &fieldLoss{Path: f.goName}
+		g.toProto(w, "out."+goCamelCase(f.Name), "in."+f.goName, f.goType, f, msg.fullName)
+		g.losses[msg.fullName] = append(g.losses[msg.fullName], g.field)
+		g.field = nil
+		if len(conds) > 0 {
+			w.WriteString("}\n")
+		}
+	}
+	w.WriteString("return out\n}\n")
+}
+
+func (g *converterGen) writeFromProto(w *bytes.Buffer, msg message) {
+	g.vars = 0
+	name := goMessageName(msg.fullName)
+	goType := g.typeString(msg.goType)
+	fmt.Fprintf(w, "\n// %sFromProto converts a proto message into a %s.\n", name, goType)
+	fmt.Fprintf(w, "func %sFromProto(in *pb.%s) *%s {\n", name, name, goType)
+	fmt.Fprintf(w, "if in == nil {\nreturn nil\n}\nout := &%s{}\n", goType)
+	allocated := make(map[string]struct{})
+	for _, f := range msg.Fields {
+		for _, e := range f.goEmbeds {
+			if _, ok := allocated[e.Path]; !ok {
+				allocated[e.Path] = struct{}{}
+				fmt.Fprintf(w, "out.%s = new(%s)\n", e.Path, g.typeString(e.Type))
+			}
+		}
+	}
+	for _, f := range msg.Fields {
+		if f.goType == nil {
+			continue
+		}
+		g.fromProto(w, "out."+f.goName, "in."+goCamelCase(f.Name), f.goType, f, msg.fullName)
+	}
+	w.WriteString("return out\n}\n")
+}
+
+// toProto writes the statements setting dst, a value of the proto type of f in
+// message owner, a full name, from src, a Go value of type t.
+func (g *converterGen) toProto(w *bytes.Buffer, dst, src string, t types.Type, f field, owner string) {
+	if isTime(t) {
+		g.useTimestamppb()
+		fmt.Fprintf(w, "%s = timestamppb.New(%s)\n", dst, src)
+		return
+	}
+	if p, ok := t.Underlying().(*types.Pointer); ok {
+		if fn, ok := g.converter(p.Elem(), f.TypeName, owner); ok {
+			fmt.Fprintf(w, "%s = %sToProto(%s)\n", dst, fn, src)
+			return
+		}
+		if !isTime(p.Elem()) {
+			g.lose("nil is not kept")
+		}
+		fmt.Fprintf(w, "if %s != nil {\n", src)
+		g.toProto(w, dst, "(*"+src+")", p.Elem(), f, owner)
+		w.WriteString("}\n")
+		return
+	}
+
+	elem := field{TypeName: f.TypeName}
+	switch u := t.Underlying().(type) {
+	case *types.Interface:
+		if len(f.Oneof) > 0 {
+			g.oneofToProto(w, dst, src, t, u, f, owner)
+			return
+		}
+		if wrapper, ok := g.wrapper(f, owner); ok {
+			g.wrapperToProto(w, dst, src, t, wrapper)
+			return
+		}
+	case *types.Map:
+		if f.MapKey != "" {
+			if key, ok := u.Key().Underlying().(*types.Basic); ok && scalarLossy(key, f.MapKey) {
+				g.lose(fmt.Sprintf("%s keys don't fit %s", u.Key(), f.MapKey))
+			}
+			n := g.newVar()
+			fmt.Fprintf(w, "%s = make(map[%s]%s, len(%s))\n", dst, protoGoScalars[f.MapKey], g.protoGoType(elem, owner), src)
+			fmt.Fprintf(w, "for k%d, v%d := range %s {\nvar x%d %s\n", n, n, src, n, g.protoGoType(elem, owner))
+			g.toProto(w, fmt.Sprintf("x%d", n), fmt.Sprintf("v%d", n), u.Elem(), elem, owner)
+			fmt.Fprintf(w, "%s[%s(k%d)] = x%d\n}\n", dst, protoGoScalars[f.MapKey], n, n)
+			return
+		}
+		if wrapper, ok := g.wrapper(f, owner); ok {
+			g.wrapperToProto(w, dst, src, t, wrapper)
+			return
+		}
+	case *types.Slice, *types.Array:
+		if f.TypeName == "bytes" && !f.IsRepeated && isBytes(t) {
+			if _, ok := u.(*types.Array); ok {
+				fmt.Fprintf(w, "%s = append([]byte(nil), %s[:]...)\n", dst, src)
+			} else {
+				fmt.Fprintf(w, "%s = []byte(%s)\n", dst, src)
+			}
+			return
+		}
+		if f.IsRepeated {
+			n := g.newVar()
+			fmt.Fprintf(w, "%s = make([]%s, len(%s))\n", dst, g.protoGoType(elem, owner), src)
+			fmt.Fprintf(w, "for i%d := range %s {\n", n, src)
+			g.toProto(w, fmt.Sprintf("%s[i%d]", dst, n), fmt.Sprintf("%s[i%d]", src, n), sliceElem(u), elem, owner)
+			w.WriteString("}\n")
+			return
+		}
+		if wrapper, ok := g.wrapper(f, owner); ok {
+			g.wrapperToProto(w, dst, src, t, wrapper)
+			return
+		}
+	case *types.Struct:
+		if fn, ok := g.converter(t, f.TypeName, owner); ok {
+			fmt.Fprintf(w, "%s = %sToProto(&%s)\n", dst, fn, src)
+			return
+		}
+	case *types.Basic:
+		if goType, ok := protoGoScalars[f.TypeName]; ok && !f.IsRepeated && f.MapKey == "" && scalarCompatible(u, f.TypeName) {
+			if scalarLossy(u, f.TypeName) {
+				g.lose(fmt.Sprintf("%s doesn't fit %s", t, f.TypeName))
+			}
+			fmt.Fprintf(w, "%s = %s(%s)\n", dst, goType, src)
+			return
+		}
+	}
+	g.lose("not converted")
+	fmt.Fprintf(w, "// %s: %s is not converted to %s\n", src, g.typeString(t), protoTypeString(f))
+}
+
+// fromProto writes the statements setting dst, a Go value of type t, from src,
+// a value of the proto type of f in message owner, a full name.
+func (g *converterGen) fromProto(w *bytes.Buffer, dst, src string, t types.Type, f field, owner string) {
+	if isTime(t) {
+		fmt.Fprintf(w, "if %s != nil {\n%s = %s.AsTime()\n}\n", src, dst, src)
+		return
+	}
+	if p, ok := t.Underlying().(*types.Pointer); ok {
+		if fn, ok := g.converter(p.Elem(), f.TypeName, owner); ok {
+			fmt.Fprintf(w, "%s = %sFromProto(%s)\n", dst, fn, src)
+			return
+		}
+		n := g.newVar()
+		if isTime(p.Elem()) {
+			fmt.Fprintf(w, "if %s != nil {\nx%d := %s.AsTime()\n%s = &x%d\n}\n", src, n, src, dst, n)
+			return
+		}
+		fmt.Fprintf(w, "var x%d %s\n", n, g.typeString(p.Elem()))
+		g.fromProto(w, fmt.Sprintf("x%d", n), src, p.Elem(), f, owner)
+		fmt.Fprintf(w, "%s = &x%d\n", dst, n)
+		return
+	}
+
+	elem := field{TypeName: f.TypeName}
+	switch u := t.Underlying().(type) {
+	case *types.Interface:
+		if len(f.Oneof) > 0 {
+			g.oneofFromProto(w, dst, src, u, f, owner)
+			return
+		}
+		if wrapper, ok := g.wrapper(f, owner); ok {
+			g.wrapperFromProto(w, dst, src, t, wrapper)
+			return
+		}
+	case *types.Map:
+		if f.MapKey != "" {
+			n := g.newVar()
+			fmt.Fprintf(w, "%s = make(%s, len(%s))\n", dst, g.typeString(t), src)
+			fmt.Fprintf(w, "for k%d, v%d := range %s {\nvar x%d %s\n", n, n, src, n, g.typeString(u.Elem()))
+			g.fromProto(w, fmt.Sprintf("x%d", n), fmt.Sprintf("v%d", n), u.Elem(), elem, owner)
+			fmt.Fprintf(w, "%s[%s(k%d)] = x%d\n}\n", dst, g.typeString(u.Key()), n, n)
+			return
+		}
+		if wrapper, ok := g.wrapper(f, owner); ok {
+			g.wrapperFromProto(w, dst, src, t, wrapper)
+			return
+		}
+	case *types.Slice, *types.Array:
+		_, isArray := u.(*types.Array)
+		if f.TypeName == "bytes" && !f.IsRepeated && isBytes(t) {
+			if isArray {
+				fmt.Fprintf(w, "copy(%s[:], %s)\n", dst, src)
+			} else {
+				fmt.Fprintf(w, "%s = %s(%s)\n", dst, g.typeString(t), src)
+			}
+			return
+		}
+		if f.IsRepeated {
+			n := g.newVar()
+			if isArray {
+				fmt.Fprintf(w, "for i%d := 0; i%d < len(%s) && i%d < len(%s); i%d++ {\n", n, n, src, n, dst, n)
+			} else {
+				fmt.Fprintf(w, "%s = make(%s, len(%s))\n", dst, g.typeString(t), src)
+				fmt.Fprintf(w, "for i%d := range %s {\n", n, src)
+			}
+			g.fromProto(w, fmt.Sprintf("%s[i%d]", dst, n), fmt.Sprintf("%s[i%d]", src, n), sliceElem(u), elem, owner)
+			w.WriteString("}\n")
+			return
+		}
+		if wrapper, ok := g.wrapper(f, owner); ok {
+			g.wrapperFromProto(w, dst, src, t, wrapper)
+			return
+		}
+	case *types.Struct:
+		if fn, ok := g.converter(t, f.TypeName, owner); ok {
+			n := g.newVar()
+			fmt.Fprintf(w, "if x%d := %sFromProto(%s); x%d != nil {\n%s = *x%d\n}\n", n, fn, src, n, dst, n)
+			return
+		}
+	case *types.Basic:
+		if _, ok := protoGoScalars[f.TypeName]; ok && !f.IsRepeated && f.MapKey == "" && scalarCompatible(u, f.TypeName) {
+			fmt.Fprintf(w, "%s = %s(%s)\n", dst, g.typeString(t), src)
+			return
+		}
+	}
+	fmt.Fprintf(w, "// %s: %s is not converted to %s\n", dst, protoTypeString(f), g.typeString(t))
+}
+
+// wrapperToProto converts a nested slice, map or interface into the generated
+// message wrapping it.
+func (g *converterGen) wrapperToProto(w *bytes.Buffer, dst, src string, t types.Type, wrapper message) {
+	value := wrapper.Fields[0]
+	fmt.Fprintf(w, "%s = &pb.%s{}\n", dst, goMessageName(wrapper.fullName))
+	g.toProto(w, dst+"."+goCamelCase(value.Name), src, t, value, wrapper.fullName)
+}
+
+func (g *converterGen) wrapperFromProto(w *bytes.Buffer, dst, src string, t types.Type, wrapper message) {
+	value := wrapper.Fields[0]
+	fmt.Fprintf(w, "if %s != nil {\n", src)
+	g.fromProto(w, dst, src+"."+goCamelCase(value.Name), t, value, wrapper.fullName)
+	w.WriteString("}\n")
+}
+
+// oneofToProto switches over the implementations of interface iface, setting
+// the oneof member of the dynamic type.
+func (g *converterGen) oneofToProto(w *bytes.Buffer, dst, src string, t types.Type, iface *types.Interface, f field, owner string) {
+	n := g.newVar()
+	fmt.Fprintf(w, "switch v%d := %s.(type) {\n", n, src)
+	for _, member := range f.Oneof {
+		memberType, fn, ok := g.oneofMember(member, owner)
+		if !ok {
+			fmt.Fprintf(w, "// %s: %s is not converted\n", src, member.TypeName)
+			continue
+		}
+		wrapperType := fmt.Sprintf("pb.%s_%s", goMessageName(owner), goCamelCase(member.Name))
+		g.oneofType(t, memberType, iface)
+		if types.Implements(memberType, iface) {
+			fmt.Fprintf(w, "case %s:\n%s = &%s{%s: %sToProto(&v%d)}\n", g.typeString(memberType), dst, wrapperType, goCamelCase(member.Name), fn, n)
+		}
+		if types.Implements(types.NewPointer(memberType), iface) {
+			fmt.Fprintf(w, "case *%s:\n%s = &%s{%s: %sToProto(v%d)}\n", g.typeString(memberType), dst, wrapperType, goCamelCase(member.Name), fn, n)
+		}
+	}
+	w.WriteString("}\n")
+}
+
+// oneofFromProto switches over the members of the oneof, setting dst to the
+// implementation of interface iface of the member that is set.
+func (g *converterGen) oneofFromProto(w *bytes.Buffer, dst, src string, iface *types.Interface, f field, owner string) {
+	n := g.newVar()
+	fmt.Fprintf(w, "switch v%d := %s.(type) {\n", n, src)
+	for _, member := range f.Oneof {
+		memberType, fn, ok := g.oneofMember(member, owner)
+		if !ok {
+			fmt.Fprintf(w, "// %s: %s is not converted\n", dst, member.TypeName)
+			continue
+		}
+		fmt.Fprintf(w, "case *pb.%s_%s:\n", goMessageName(owner), goCamelCase(member.Name))
+		value := fmt.Sprintf("x%d", n)
+		if types.Implements(memberType, iface) {
+			value = "*" + value
+		}
+		fmt.Fprintf(w, "if x%d := %sFromProto(v%d.%s); x%d != nil {\n%s = %s\n}\n", n, fn, n, goCamelCase(member.Name), n, dst, value)
+	}
+	w.WriteString("}\n")
+}
+
+// oneofMember returns the Go struct of a oneof member of message owner and
+// its converter name.
+func (g *converterGen) oneofMember(member field, owner string) (types.Type, string, bool) {
+	msg, ok := g.messages[g.resolve(owner, member.TypeName)]
+	if !ok || msg.goType == nil {
+		return nil, "", false
+	}
+	fn, ok := g.converter(msg.goType, member.TypeName, owner)
+	return msg.goType, fn, ok
+}
+
+// wrapper returns the generated message wrapping the nested slice, map or
+// interface of f in message owner.
+func (g *converterGen) wrapper(f field, owner string) (message, bool) {
+	msg, ok := g.messages[g.resolve(owner, f.TypeName)]
+	if !ok || msg.goType != nil || len(msg.Fields) != 1 || f.IsRepeated || f.MapKey != "" {
+		return message{}, false
+	}
+	return msg, true
+}
+
+// converter returns the name of the converters between Go struct t and the
+// message typeName referred to from message owner, if there are any.
+func (g *converterGen) converter(t types.Type, typeName, owner string) (string, bool) {
+	fullName := g.resolve(owner, typeName)
+	for _, name := range g.structs[types.TypeString(t, nil)] {
+		if name == fullName {
+			if g.field != nil {
+				g.field.Messages = append(g.field.Messages, name)
+			}
+			return goMessageName(name), true
+		}
+	}
+	return "", false
+}
+
+// protoGoType returns the Go type protoc-gen-go uses for a single value of
+// the proto type of f in message owner.
+func (g *converterGen) protoGoType(f field, owner string) string {
+	if goType, ok := protoGoScalars[f.TypeName]; ok {
+		return goType
+	}
+	if f.TypeName == timestampTypeName {
+		g.useTimestamppb()
+		return "*timestamppb.Timestamp"
+	}
+	return "*pb." + goMessageName(g.resolve(owner, f.TypeName))
+}
+
+// resolve returns the full name of the message typeName referred to from
+// message owner.
+func (g *converterGen) resolve(owner, typeName string) string {
+	fullName, _ := resolveName(owner, typeName, func(name string) bool {
+		_, ok := g.messages[name]
+		return ok
+	})
+	return fullName
+}
+
+func (g *converterGen) useTimestamppb() {
+	g.imports[timestamppbImport] = "timestamppb"
+	g.used[timestamppbImport] = struct{}{}
+	g.named[timestamppbImport] = struct{}{}
+}
+
+func (g *converterGen) newVar() int {
+	g.vars++
+	return g.vars
+}
+
+// typeString returns the Go type t as written in the converters file,
+// importing the packages it refers to.
+func (g *converterGen) typeString(t types.Type) string {
+	return types.TypeString(t, g.qualifier)
+}
+
+func (g *converterGen) qualifier(pkg *types.Package) string {
+	g.used[pkg.Path()] = struct{}{}
+	if alias, ok := g.imports[pkg.Path()]; ok {
+		return alias
+	}
+	alias := pkg.Name()
+	for i := 2; ; i++ {
+		_, taken := g.aliases[alias]
+		if !taken && !isConverterLocal(alias) {
+			break
+		}
+		alias = pkg.Name() + strconv.Itoa(i)
+	}
+	g.aliases[alias] = struct{}{}
+	g.imports[pkg.Path()] = alias
+	if alias == pkg.Name() {
+		g.named[pkg.Path()] = struct{}{}
+	}
+	return alias
+}
+
+// isConverterLocal reports whether name is used for the variables of the
+// converters, which package names must not be shadowed by.
+func isConverterLocal(name string) bool {
+	switch name {
+	case "in", "out", "pb", "timestamppb", "r", "t", "given", "result", "expected", "actual", "testCase", "path":
+		return true
+	}
+	if len(name) < 2 || !strings.ContainsRune("ikvx", rune(name[0])) {
+		return false
+	}
+	_, err := strconv.Atoi(name[1:])
+	return err == nil
+}
+
+// scalarLossy reports whether some values of Go basic type b don't fit the Go
+// type of proto scalar typeName.
+func scalarLossy(b *types.Basic, typeName string) bool {
+	target, ok := types.Universe.Lookup(protoGoScalars[typeName]).(*types.TypeName)
+	if !ok {
+		return false
+	}
+	to := target.Type().(*types.Basic)
+	if b.Info()&types.IsNumeric == 0 || to.Info()&types.IsNumeric == 0 {
+		return false
+	}
+	from, into := goSizes.Sizeof(b), goSizes.Sizeof(to)
+	switch {
+	case to.Info()&types.IsFloat != 0:
+		return b.Info()&types.IsFloat == 0 || from > into
+	case b.Info()&types.IsFloat != 0:
+		return true
+	case b.Info()&types.IsUnsigned != 0 && to.Info()&types.IsUnsigned == 0:
+		return from >= into
+	case b.Info()&types.IsUnsigned == 0 && to.Info()&types.IsUnsigned != 0:
+		return true
+	}
+	return from > into
+}
+
+// scalarCompatible reports whether Go basic type b converts to and from the Go
+// type of proto scalar typeName.
+func scalarCompatible(b *types.Basic, typeName string) bool {
+	switch typeName {
+	case "bool":
+		return b.Info()&types.IsBoolean != 0
+	case "string":
+		return b.Info()&types.IsString != 0
+	case "bytes":
+		return false
+	}
+	return b.Info()&types.IsNumeric != 0
+}
+
+func sliceElem(t types.Type) types.Type {
+	switch u := t.(type) {
+	case *types.Slice:
+		return u.Elem()
+	case *types.Array:
+		return u.Elem()
+	}
+	return nil
+}
+
+func protoTypeString(f field) string {
+	switch {
+	case f.IsRepeated:
+		return "repeated " + f.TypeName
+	case f.MapKey != "":
+		return fmt.Sprintf("map<%s, %s>", f.MapKey, f.TypeName)
+	case len(f.Oneof) > 0:
+		return "oneof " + f.Name
+	}
+	return f.TypeName
+}
+
+// goMessageName returns the name protoc-gen-go gives the message with the
+// given full name, e.g. Order_Line for Order.Line.
+func goMessageName(fullName string) string {
+	parts := strings.Split(fullName, ".")
+	for i, part := range parts {
+		parts[i] = goCamelCase(part)
+	}
+	return strings.Join(parts, "_")
+}
+
+// goCamelCase converts a proto name to the Go name protoc-gen-go uses for it.
+func goCamelCase(s string) string {
+	var b []byte
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '.' && i+1 < len(s) && isASCIILower(s[i+1]):
+			// skip the dot in .{{lowercase}}
+		case c == '.':
+			b = append(b, '_')
+		case c == '_' && (i == 0 || s[i-1] == '.'):
+			b = append(b, 'X')
+		case c == '_' && i+1 < len(s) && isASCIILower(s[i+1]):
+			// skip the underscore in _{{lowercase}}
+		case '0' <= c && c <= '9':
+			b = append(b, c)
+		default:
+			if isASCIILower(c) {
+				c -= 'a' - 'A'
+			}
+			b = append(b, c)
+			for ; i+1 < len(s) && isASCIILower(s[i+1]); i++ {
+				b = append(b, s[i+1])
+			}
+		}
+	}
+	return string(b)
+}
+
+func isASCIILower(c byte) bool {
+	return 'a' <= c && c <= 'z'
+}
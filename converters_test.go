@@ -0,0 +1,169 @@
+package main
+
+import (
+	"go/types"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const convertersSource = `package p
+
+import "time"
+
+type Shape interface{ Area() float64 }
+
+type Circle struct{ R float64 }
+
+func (Circle) Area() float64 { return 0 }
+
+type Line struct{ Qty uint16 }
+
+type Order struct {
+	Created time.Time
+	Count   *int
+	Lines   []*Line
+	ByID    map[string]Line
+	Matrix  [][]float64
+	Main    Shape
+	Meta    struct{ Note string }
+}
+
+type Box struct {
+	Order *Order
+	Label string
+}
+`
+
+func convertersMessages(t *testing.T) []message {
+	t.Helper()
+
+	_, structTypes := checkSource(t, convertersSource)
+	resolver := newTypeResolver(Config{}, DirectiveMap{}, structTypes, ProtoMessageMap{})
+	var msgs []message
+	for _, obj := range structTypes {
+		msg, err := getMessage(obj.Name(), obj.Name(), obj.Type().Underlying().(*types.Struct), Config{}, ProtoMessageMap{}, resolver)
+		assert.NoError(t, err)
+		msg.goType = obj.Type()
+		msgs = append(msgs, msg)
+	}
+	return append(msgs, resolver.Wrappers()...)
+}
+
+func TestGenerateConverters(t *testing.T) {
+	t.Parallel()
+
+	src, err := generateConverters(convertersMessages(t), ConverterConfig{ProtoImport: "example.com/pb"})
+	assert.NoError(t, err)
+
+	for _, expected := range []string{
+		"package converters\n",
+		"\tpb \"example.com/pb\"\n",
+		"\t\"google.golang.org/protobuf/types/known/timestamppb\"\n",
+		"func OrderToProto(in *p.Order) *pb.Order {\n",
+		"func OrderFromProto(in *pb.Order) *p.Order {\n",
+		"\tout.Created = timestamppb.New(in.Created)\n",
+		"\tif in.Created != nil {\n\t\tout.Created = in.Created.AsTime()\n\t}\n",
+		"\t\tout.Count = int64((*in.Count))\n",
+		"\t\tout.Lines[i1] = LineToProto(in.Lines[i1])\n",
+		"\tout.ByID = make(map[string]*pb.Line, len(in.ByID))\n",
+		"\t\tout.Matrix[i3] = &pb.DoubleList{}\n",
+		"\tcase p.Circle:\n\t\tout.Main = &pb.Order_MainCircle{MainCircle: CircleToProto(&v5)}\n",
+		"\tout.Meta = Order_MetaToProto(&in.Meta)\n",
+		"func Order_MetaFromProto(in *pb.Order_Meta) *struct{ Note string } {\n",
+		"\tout.Qty = uint16(in.Qty)\n",
+		"\tcase *pb.Order_MainCircle:\n\t\tif x7 := CircleFromProto(v7.MainCircle); x7 != nil {\n\t\t\tout.Main = *x7\n",
+	} {
+		assert.Contains(t, string(src), expected)
+	}
+}
+
+func TestGenerateConverters_NestedMessages(t *testing.T) {
+	t.Parallel()
+
+	_, structTypes := checkSource(t, `package p
+
+type User struct {
+	Items []struct{ Name string }
+	ByKey map[string]struct{ V int32 }
+}
+
+type A struct{ Meta struct{ Note string } }
+
+type B struct{ Meta struct{ Note string } }
+`)
+	resolver := newTypeResolver(Config{}, DirectiveMap{}, structTypes, ProtoMessageMap{})
+	var msgs []message
+	for _, obj := range structTypes {
+		msg, err := getMessage(obj.Name(), obj.Name(), obj.Type().Underlying().(*types.Struct), Config{}, ProtoMessageMap{}, resolver)
+		assert.NoError(t, err)
+		msg.goType = obj.Type()
+		msgs = append(msgs, msg)
+	}
+
+	src, err := generateConverters(append(msgs, resolver.Wrappers()...), ConverterConfig{ProtoImport: "example.com/pb"})
+	assert.NoError(t, err)
+
+	for _, expected := range []string{
+		"	out.Items = make([]*pb.User_Items, len(in.Items))\n",
+		"		out.Items[i1] = User_ItemsToProto(&in.Items[i1])\n",
+		"	out.ByKey = make(map[string]*pb.User_ByKey, len(in.ByKey))\n",
+		"		var x2 *pb.User_ByKey\n		x2 = User_ByKeyToProto(&v2)\n",
+		"		if x4 := User_ByKeyFromProto(v3); x4 != nil {\n",
+		"func AToProto(in *p.A) *pb.A {\n	if in == nil {\n		return nil\n	}\n	out := &pb.A{}\n	out.Meta = A_MetaToProto(&in.Meta)\n",
+		"func BToProto(in *p.B) *pb.B {\n	if in == nil {\n		return nil\n	}\n	out := &pb.B{}\n	out.Meta = B_MetaToProto(&in.Meta)\n",
+		"	if x1 := B_MetaFromProto(in.Meta); x1 != nil {\n",
+	} {
+		assert.Contains(t, string(src), expected)
+	}
+}
+
+func TestGenerateRoundTripTests(t *testing.T) {
+	t.Parallel()
+
+	msgs := convertersMessages(t)
+	// Box.Label isn't generated, as if it was ignored
+	for i, msg := range msgs {
+		if msg.Name == "Box" {
+			msgs[i].Fields = msgs[i].Fields[:1]
+		}
+	}
+
+	src, err := generateRoundTripTests(msgs, ConverterConfig{ProtoImport: "example.com/pb"})
+	assert.NoError(t, err)
+
+	for _, expected := range []string{
+		"\t\"math/rand/v2\"\n",
+		"\t\"p\"\n",
+		"func TestRoundTrip(t *testing.T) {\n",
+		"\t\t\t\tvar given p.Order\n",
+		"\t\t\t\treturn &given, OrderFromProto(OrderToProto(&given))\n",
+		"\t\t\t\t\"Count\": \"nil is not kept\",\n",
+		"\t\t\t\t\"Label\": \"not in the proto\",\n",
+		"\t\t\t\t\"Order\": \"Order is lossy\",\n",
+		"\treflect.TypeFor[p.Shape](): {reflect.TypeFor[p.Circle]()},\n",
+		"func fillRandom(r *rand.Rand, v reflect.Value, depth int) {\n",
+	} {
+		assert.Contains(t, string(src), expected)
+	}
+}
+
+func TestGoCamelCase(t *testing.T) {
+	t.Parallel()
+
+	var testCases = []struct {
+		testName string
+		given    string
+		expected string
+	}{
+		{testName: "camel case", given: "byID", expected: "ByID"},
+		{testName: "snake case", given: "created_at", expected: "CreatedAt"},
+		{testName: "digit", given: "line_2", expected: "Line_2"},
+		{testName: "leading underscore", given: "_id", expected: "XId"},
+		{testName: "nested", given: "Order.Meta", expected: "Order_Meta"},
+	}
+
+	for _, testCase := range testCases {
+		assert.Equal(t, testCase.expected, goCamelCase(testCase.given), testCase.testName)
+	}
+}
@@ -1,7 +1,9 @@
 package main
 
 import (
-	"os"
+	"bytes"
+	"io/ioutil"
+	"strings"
 
 	"github.com/emicklei/proto"
 )
@@ -12,27 +14,53 @@ func BuildCurrentProtoMap(filename string) (ProtoMessageMap, error) {
 	if filename == "" {
 		return ProtoMessageMap{}, nil
 	}
-	reader, err := os.Open(filename)
+	src, err := ioutil.ReadFile(filename)
 	if err != nil {
 		return nil, err
 	}
-	defer reader.Close()
 
-	parser := proto.NewParser(reader)
+	parser := proto.NewParser(bytes.NewReader(src))
 	definition, err := parser.Parse()
 	if err != nil {
 		return nil, err
 	}
 
 	p := make(ProtoMessageMap)
-	proto.Walk(definition, proto.WithMessage(p.HandleMessage()))
+	proto.Walk(definition, proto.WithMessage(p.HandleMessage(src)), proto.WithEnum(p.HandleEnum()))
 
 	return p, nil
 }
 
-func (p ProtoMessageMap) HandleMessage() func(m *proto.Message) {
+func (p ProtoMessageMap) HandleMessage(src protoSource) func(m *proto.Message) {
+	// messages are walked in the order of the file
+	position := 0
 	return func(m *proto.Message) {
-		p[m.Name] = NewProtoMesssageFromMessage(m)
+		position++
+		msg := NewProtoMesssageFromMessage(m)
+		msg.annotations = readAnnotations(m, src)
+		msg.position = position
+		msg.fieldPositions = readFieldPositions(m)
+		msg.fieldTypes = readFieldTypes(m)
+		p[protoPath(m.Name, m.Parent)] = msg
+	}
+}
+
+func (p ProtoMessageMap) HandleEnum() func(e *proto.Enum) {
+	return func(e *proto.Enum) {
+		p[protoPath(e.Name, e.Parent)] = NewProtoMessageFromEnum(e)
+	}
+}
+
+// protoPath returns the fully qualified name of a message or enum, e.g.
+// Order.Line for a message Line nested in Order.
+func protoPath(name string, parent proto.Visitee) string {
+	for {
+		m, ok := parent.(*proto.Message)
+		if !ok {
+			return name
+		}
+		name = m.Name + "." + name
+		parent = m.Parent
 	}
 }
 
@@ -45,6 +73,36 @@ func (p ProtoMessageMap) GetFieldNum(messageName, fieldName string) int {
 	return fieldNum
 }
 
+// GetFieldAnnotations returns the options and inline comment of the existing
+// field, if any.
+func (p ProtoMessageMap) GetFieldAnnotations(messageName, fieldName string) FieldAnnotations {
+	msg, ok := p[messageName]
+	if !ok {
+		return FieldAnnotations{}
+	}
+	return msg.annotations[fieldName]
+}
+
+// MessagePosition returns the position of the message in the existing proto,
+// counting from 1, or 0 if it isn't there.
+func (p ProtoMessageMap) MessagePosition(messageName string) int {
+	msg, ok := p[messageName]
+	if !ok {
+		return 0
+	}
+	return msg.position
+}
+
+// FieldPosition returns the position of the field or oneof in its message in
+// the existing proto, counting from 1, or 0 if it isn't there.
+func (p ProtoMessageMap) FieldPosition(messageName, fieldName string) int {
+	msg, ok := p[messageName]
+	if !ok {
+		return 0
+	}
+	return msg.fieldPositions[fieldName]
+}
+
 func (p ProtoMessageMap) RemoveFieldNum(messageName, fieldName string) {
 	_, ok := p[messageName]
 	if !ok {
@@ -58,11 +116,120 @@ type ProtoMessage struct {
 	currMaxNum  int
 	droppedNums []int
 	fields      map[string]int
+	annotations map[string]FieldAnnotations
+	// reserved are the reserved ranges of the message, skipped when numbering
+	// new fields
+	reserved []proto.Range
+
+	// position and fieldPositions are the order of the message and its
+	// fields in the existing proto, 0 for new ones
+	position       int
+	fieldPositions map[string]int
+
+	// fieldTypes are the types of the fields and oneof members as written,
+	// like repeated string, nil for enums
+	fieldTypes map[string]string
+}
+
+// readFieldPositions returns the positions of the fields, oneofs and oneof
+// members of msg, counting from 1.
+func readFieldPositions(msg *proto.Message) map[string]int {
+	positions := make(map[string]int)
+	add := func(name string) {
+		positions[name] = len(positions) + 1
+	}
+	for _, element := range msg.Elements {
+		switch e := element.(type) {
+		case *proto.NormalField:
+			add(e.Name)
+		case *proto.MapField:
+			add(e.Name)
+		case *proto.Oneof:
+			add(e.Name)
+			for _, oneofElement := range e.Elements {
+				if member, ok := oneofElement.(*proto.OneOfField); ok {
+					add(member.Name)
+				}
+			}
+		}
+	}
+	return positions
+}
+
+// readFieldTypes returns the types of the fields and oneof members of msg,
+// described like fieldType does.
+func readFieldTypes(msg *proto.Message) map[string]string {
+	fieldTypes := make(map[string]string)
+	for _, element := range msg.Elements {
+		switch e := element.(type) {
+		case *proto.NormalField:
+			fieldTypes[e.Name] = describeType(e.Type, e.Repeated, "")
+		case *proto.MapField:
+			fieldTypes[e.Name] = describeType(e.Type, false, e.KeyType)
+		case *proto.Oneof:
+			for _, oneofElement := range e.Elements {
+				if member, ok := oneofElement.(*proto.OneOfField); ok {
+					fieldTypes[member.Name] = describeType(member.Type, false, "")
+				}
+			}
+		}
+	}
+	return fieldTypes
+}
+
+// FieldAnnotations holds what is kept of an existing field besides its number:
+// its options, like deprecated = true, other than the generated
+// (tagger.tags), and its inline comment.
+type FieldAnnotations struct {
+	Options []string
+	Comment string
+}
+
+// readAnnotations returns the annotations of the fields of msg, keyed by field
+// name. They are copied from src as written.
+func readAnnotations(msg *proto.Message, src protoSource) map[string]FieldAnnotations {
+	annotations := make(map[string]FieldAnnotations)
+	add := func(f *proto.Field) {
+		var a FieldAnnotations
+		for _, option := range f.Options {
+			if option.Name == "(tagger.tags)" {
+				continue
+			}
+			// the position of an option is that of the bracket or comma
+			// before it
+			start := option.Position.Offset + 1
+			end := src.scan(start, func(c byte, depth int) bool { return depth < 0 || (c == ',' && depth == 0) })
+			a.Options = append(a.Options, strings.TrimSpace(string(src[start:end])))
+		}
+		if f.InlineComment != nil {
+			start := f.InlineComment.Position.Offset
+			a.Comment = strings.TrimSpace(string(src[start:src.lineEnd(start)]))
+		}
+		if len(a.Options) > 0 || a.Comment != "" {
+			annotations[f.Name] = a
+		}
+	}
+	for _, element := range msg.Elements {
+		switch e := element.(type) {
+		case *proto.NormalField:
+			add(e.Field)
+		case *proto.MapField:
+			add(e.Field)
+		case *proto.Oneof:
+			for _, oneofElement := range e.Elements {
+				if member, ok := oneofElement.(*proto.OneOfField); ok {
+					add(member.Field)
+				}
+			}
+		}
+	}
+	return annotations
 }
 
 func NewProtoMesssageFromMessage(msg *proto.Message) *ProtoMessage {
 	fieldsMap := make(map[string]int, len(msg.Elements))
 	currMax := 0
+	var reserved []proto.Range
 
 	for i, _ := range msg.Elements {
 		element := msg.Elements[i]
@@ -80,9 +247,53 @@ func NewProtoMesssageFromMessage(msg *proto.Message) *ProtoMessage {
 			if field.Sequence > currMax {
 				currMax = field.Sequence
 			}
+		case *proto.Oneof:
+			// oneof members share the numbers of the enclosing message
+			for _, oneofElement := range element.(*proto.Oneof).Elements {
+				field, ok := oneofElement.(*proto.OneOfField)
+				if !ok {
+					continue
+				}
+				fieldsMap[field.Name] = field.Sequence
+				if field.Sequence > currMax {
+					currMax = field.Sequence
+				}
+			}
+		case *proto.Reserved:
+			// reserved numbers, e.g. of fields removed by a merge, are never
+			// given out again
+			for _, r := range element.(*proto.Reserved).Ranges {
+				if !r.Max && r.To > currMax {
+					currMax = r.To
+				}
+				reserved = append(reserved, r)
+			}
 		default:
 		}
 	}
+	return &ProtoMessage{
+		currMaxNum: currMax,
+		fields:     fieldsMap,
+		reserved:   reserved,
+	}
+}
+
+// NewProtoMessageFromEnum numbers the values of an enum like the fields of a
+// message.
+func NewProtoMessageFromEnum(enum *proto.Enum) *ProtoMessage {
+	fieldsMap := make(map[string]int, len(enum.Elements))
+	currMax := 0
+
+	for _, element := range enum.Elements {
+		field, ok := element.(*proto.EnumField)
+		if !ok {
+			continue
+		}
+		fieldsMap[field.Name] = field.Integer
+		if field.Integer > currMax {
+			currMax = field.Integer
+		}
+	}
 	return &ProtoMessage{
 		currMaxNum: currMax,
 		fields:     fieldsMap,
@@ -99,7 +310,7 @@ func (p *ProtoMessage) GetFieldNum(fieldName string) int {
 	}
 	// assumes fields are dropped in order
 	if len(p.droppedNums) == 0 {
-		p.currMaxNum++
+		p.currMaxNum = p.nextFree(p.currMaxNum + 1)
 		p.fields[fieldName] = p.currMaxNum
 		return p.fields[fieldName]
 	}
@@ -109,6 +320,30 @@ func (p *ProtoMessage) GetFieldNum(fieldName string) int {
 	return p.fields[fieldName]
 }
 
+// nextFree returns the first number from num on that is outside the reserved
+// ranges of the message and the numbers reserved for the protobuf
+// implementation.
+func (p *ProtoMessage) nextFree(num int) int {
+	for {
+		next := num
+		if next >= firstReservedFieldNumber && next <= lastReservedFieldNumber {
+			next = lastReservedFieldNumber + 1
+		}
+		for _, r := range p.reserved {
+			switch {
+			case r.Max && next >= r.From:
+				next = maxFieldNumber + 1
+			case next >= r.From && next <= r.To:
+				next = r.To + 1
+			}
+		}
+		if next == num {
+			return num
+		}
+		num = next
+	}
+}
+
 func (p *ProtoMessage) RemoveFieldNum(fieldName string) {
 	if p.fields == nil {
 		return
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/emicklei/proto"
@@ -131,6 +133,97 @@ func TestNewProtoMesssageFromMessage(t *testing.T) {
 				},
 			},
 		},
+		{
+			testName: "populated message - oneof fields",
+			given: &proto.Message{
+				Elements: []proto.Visitee{
+					&proto.NormalField{
+						Field: &proto.Field{
+							Name:     "field1",
+							Sequence: 1,
+						},
+					},
+					&proto.Oneof{
+						Name: "choice",
+						Elements: []proto.Visitee{
+							&proto.OneOfField{
+								Field: &proto.Field{
+									Name:     "field2",
+									Sequence: 2,
+								},
+							},
+							&proto.OneOfField{
+								Field: &proto.Field{
+									Name:     "field4",
+									Sequence: 4,
+								},
+							},
+						},
+					},
+					&proto.NormalField{
+						Field: &proto.Field{
+							Name:     "field3",
+							Sequence: 3,
+						},
+					},
+				},
+			},
+			expected: &ProtoMessage{
+				currMaxNum: 4,
+				fields: map[string]int{
+					"field1": 1,
+					"field2": 2,
+					"field3": 3,
+					"field4": 4,
+				},
+			},
+		},
+		{
+			testName: "populated message - reserved numbers",
+			given: &proto.Message{
+				Elements: []proto.Visitee{
+					&proto.NormalField{
+						Field: &proto.Field{
+							Name:     "field1",
+							Sequence: 1,
+						},
+					},
+					&proto.Reserved{
+						Ranges: []proto.Range{{From: 2, To: 2}, {From: 4, To: 6}},
+					},
+				},
+			},
+			expected: &ProtoMessage{
+				currMaxNum: 6,
+				fields: map[string]int{
+					"field1": 1,
+				},
+				reserved: []proto.Range{{From: 2, To: 2}, {From: 4, To: 6}},
+			},
+		},
+		{
+			testName: "populated message - reserved to max",
+			given: &proto.Message{
+				Elements: []proto.Visitee{
+					&proto.NormalField{
+						Field: &proto.Field{
+							Name:     "field1",
+							Sequence: 1,
+						},
+					},
+					&proto.Reserved{
+						Ranges: []proto.Range{{From: 100, Max: true}},
+					},
+				},
+			},
+			expected: &ProtoMessage{
+				currMaxNum: 1,
+				fields: map[string]int{
+					"field1": 1,
+				},
+				reserved: []proto.Range{{From: 100, Max: true}},
+			},
+		},
 	}
 
 	for _, testCase := range testCases {
@@ -139,6 +232,86 @@ func TestNewProtoMesssageFromMessage(t *testing.T) {
 	}
 }
 
+func TestNewProtoMessageFromEnum(t *testing.T) {
+	t.Parallel()
+
+	given := &proto.Enum{
+		Elements: []proto.Visitee{
+			&proto.EnumField{Name: "UNKNOWN", Integer: 0},
+			&proto.EnumField{Name: "DONE", Integer: 2},
+			&proto.EnumField{Name: "ACTIVE", Integer: 1},
+		},
+	}
+	expected := &ProtoMessage{
+		currMaxNum: 2,
+		fields: map[string]int{
+			"UNKNOWN": 0,
+			"ACTIVE":  1,
+			"DONE":    2,
+		},
+	}
+
+	assert.Equal(t, expected, NewProtoMessageFromEnum(given))
+}
+
+func TestBuildCurrentProtoMap(t *testing.T) {
+	t.Parallel()
+
+	givenProto := `syntax = "proto3";
+message Order {
+  string id = 1 [deprecated = true, (tagger.tags) = "json:\"id\""]; // the id
+  oneof payment {
+    Card card = 2;
+    string voucher = 5 [json_name = "v", (validate.rules).string = {min_len: 1, pattern: "[a-z],"}];
+  }
+  message Line {
+    string sku = 1;
+    int64 quantity = 3;
+  }
+  enum Status {
+    UNKNOWN = 0;
+    DONE = 1;
+  }
+  repeated Line lines = 4;
+}
+`
+	filename := filepath.Join(t.TempDir(), "current.proto")
+	if err := os.WriteFile(filename, []byte(givenProto), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := ProtoMessageMap{
+		"Order": {
+			currMaxNum: 5,
+			fields:     map[string]int{"id": 1, "card": 2, "voucher": 5, "lines": 4},
+			annotations: map[string]FieldAnnotations{
+				"id":      {Options: []string{"deprecated = true"}, Comment: "// the id"},
+				"voucher": {Options: []string{`json_name = "v"`, `(validate.rules).string = {min_len: 1, pattern: "[a-z],"}`}},
+			},
+			position:       1,
+			fieldPositions: map[string]int{"id": 1, "payment": 2, "card": 3, "voucher": 4, "lines": 5},
+			fieldTypes:     map[string]string{"id": "string", "card": "Card", "voucher": "string", "lines": "repeated Line"},
+		},
+		"Order.Line": {
+			currMaxNum:     3,
+			fields:         map[string]int{"sku": 1, "quantity": 3},
+			annotations:    map[string]FieldAnnotations{},
+			position:       2,
+			fieldPositions: map[string]int{"sku": 1, "quantity": 2},
+			fieldTypes:     map[string]string{"sku": "string", "quantity": "int64"},
+		},
+		"Order.Status": {
+			currMaxNum: 1,
+			fields:     map[string]int{"UNKNOWN": 0, "DONE": 1},
+		},
+	}
+
+	result, err := BuildCurrentProtoMap(filename)
+	assert.NoError(t, err)
+	assert.Equal(t, expected, result)
+	assert.Equal(t, 6, result.GetFieldNum("Order", "amount"))
+}
+
 func TestProtoMessage_GetFieldNum(t *testing.T) {
 	t.Parallel()
 
@@ -215,6 +388,46 @@ func TestProtoMessage_GetFieldNum(t *testing.T) {
 				},
 			},
 		},
+		{
+			testName: "field DNE - reserved to max",
+			givenProtoMessage: &ProtoMessage{
+				currMaxNum: 1,
+				fields: map[string]int{
+					"givenFieldName": 1,
+				},
+				reserved: []proto.Range{{From: 2, To: 3}, {From: 100, Max: true}},
+			},
+			givenFieldName: "givenFieldName2",
+			expected:       4,
+			expectedProtoMessage: &ProtoMessage{
+				currMaxNum: 4,
+				fields: map[string]int{
+					"givenFieldName":  1,
+					"givenFieldName2": 4,
+				},
+				reserved: []proto.Range{{From: 2, To: 3}, {From: 100, Max: true}},
+			},
+		},
+		{
+			testName: "field DNE - implementation reserved numbers",
+			givenProtoMessage: &ProtoMessage{
+				currMaxNum: 18999,
+				fields: map[string]int{
+					"givenFieldName": 18999,
+				},
+				reserved: []proto.Range{{From: 20000, To: 20001}},
+			},
+			givenFieldName: "givenFieldName2",
+			expected:       20002,
+			expectedProtoMessage: &ProtoMessage{
+				currMaxNum: 20002,
+				fields: map[string]int{
+					"givenFieldName":  18999,
+					"givenFieldName2": 20002,
+				},
+				reserved: []proto.Range{{From: 20000, To: 20001}},
+			},
+		},
 		{
 			testName: "field DNE - no dropped",
 			givenProtoMessage: &ProtoMessage{
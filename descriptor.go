@@ -0,0 +1,455 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+
+	"github.com/emicklei/proto"
+	gproto "google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+
+	// the well-known types register their descriptors, which are included
+	// in descriptor sets and resolve the imports of validated protos
+	_ "google.golang.org/protobuf/types/known/anypb"
+	_ "google.golang.org/protobuf/types/known/durationpb"
+	_ "google.golang.org/protobuf/types/known/emptypb"
+	_ "google.golang.org/protobuf/types/known/fieldmaskpb"
+	_ "google.golang.org/protobuf/types/known/structpb"
+	_ "google.golang.org/protobuf/types/known/timestamppb"
+	_ "google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+const (
+	outputProtoName    = "output.proto"
+	outputProtoPackage = "proto"
+)
+
+// Field numbers of the descriptor messages used in source info paths.
+const (
+	fileMessageTypeTag   = 4
+	fileServiceTag       = 6
+	messageFieldTag      = 2
+	messageNestedTypeTag = 3
+	messageOneofDeclTag  = 8
+	serviceMethodTag     = 2
+)
+
+var protoScalarTypes = map[string]descriptorpb.FieldDescriptorProto_Type{
+	"double":   descriptorpb.FieldDescriptorProto_TYPE_DOUBLE,
+	"float":    descriptorpb.FieldDescriptorProto_TYPE_FLOAT,
+	"int32":    descriptorpb.FieldDescriptorProto_TYPE_INT32,
+	"int64":    descriptorpb.FieldDescriptorProto_TYPE_INT64,
+	"uint32":   descriptorpb.FieldDescriptorProto_TYPE_UINT32,
+	"uint64":   descriptorpb.FieldDescriptorProto_TYPE_UINT64,
+	"sint32":   descriptorpb.FieldDescriptorProto_TYPE_SINT32,
+	"sint64":   descriptorpb.FieldDescriptorProto_TYPE_SINT64,
+	"fixed32":  descriptorpb.FieldDescriptorProto_TYPE_FIXED32,
+	"fixed64":  descriptorpb.FieldDescriptorProto_TYPE_FIXED64,
+	"sfixed32": descriptorpb.FieldDescriptorProto_TYPE_SFIXED32,
+	"sfixed64": descriptorpb.FieldDescriptorProto_TYPE_SFIXED64,
+	"bool":     descriptorpb.FieldDescriptorProto_TYPE_BOOL,
+	"string":   descriptorpb.FieldDescriptorProto_TYPE_STRING,
+	"bytes":    descriptorpb.FieldDescriptorProto_TYPE_BYTES,
+}
+
+// writeDescriptorSet writes the binary FileDescriptorSet of the output proto
+// and the well-known types it imports. src is the text of the written output
+// proto, which the source info of the descriptor points into.
+func writeDescriptorSet(msgs []message, services []service, syntax Syntax, src []byte, path string) error {
+	set, err := buildDescriptorSet(msgs, services, syntax, src)
+	if err != nil {
+		return err
+	}
+	out, err := gproto.Marshal(set)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, out, 0644)
+}
+
+// buildDescriptorSet builds the descriptors of the generated messages and
+// services, without the (tagger.tags) options, which need tagger.proto.
+func buildDescriptorSet(msgs []message, services []service, syntax Syntax, src []byte) (*descriptorpb.FileDescriptorSet, error) {
+	b := &descriptorBuilder{
+		names: make(map[string]struct{}),
+		paths: make(map[string][]int32),
+	}
+	var index func(msgs []message)
+	index = func(msgs []message) {
+		for _, msg := range msgs {
+			b.names[msg.fullName] = struct{}{}
+			index(msg.Nested)
+		}
+	}
+	index(msgs)
+
+	file := &descriptorpb.FileDescriptorProto{
+		Name:    gproto.String(outputProtoName),
+		Package: gproto.String(outputProtoPackage),
+		Syntax:  gproto.String(string(SyntaxProto3)),
+	}
+	switch {
+	case syntax.isEdition():
+		file.Syntax = gproto.String("editions")
+		file.Edition = descriptorpb.Edition_EDITION_2023.Enum()
+		file.Options = &descriptorpb.FileOptions{Features: &descriptorpb.FeatureSet{
+			FieldPresence: descriptorpb.FeatureSet_IMPLICIT.Enum(),
+		}}
+	case !syntax.isProto3():
+		file.Syntax = gproto.String(string(syntax))
+	}
+	set := &descriptorpb.FileDescriptorSet{}
+	for _, path := range getImports(msgs, services) {
+		dep, err := protoregistry.GlobalFiles.FindFileByPath(path)
+		if err != nil {
+			// tagger.proto and validate.proto are only needed by the
+			// options, which are left out
+			continue
+		}
+		file.Dependency = append(file.Dependency, path)
+		set.File = append(set.File, protodesc.ToFileDescriptorProto(dep))
+	}
+
+	for i, msg := range msgs {
+		file.MessageType = append(file.MessageType, b.message(msg, []int32{fileMessageTypeTag, int32(i)}))
+	}
+	for i, svc := range services {
+		file.Service = append(file.Service, b.service(svc, []int32{fileServiceTag, int32(i)}))
+	}
+
+	info, err := b.sourceInfo(src)
+	if err != nil {
+		return nil, err
+	}
+	file.SourceCodeInfo = info
+	set.File = append(set.File, file)
+	return set, nil
+}
+
+// descriptorBuilder builds the descriptors of the generated messages. names
+// are the full names of the messages, paths the source info paths of the
+// messages, fields and services, keyed like sourceKey.
+type descriptorBuilder struct {
+	names map[string]struct{}
+	paths map[string][]int32
+}
+
+// sourceKey identifies a message by its full name, or a field, oneof or rpc by
+// its name within the full name of its message or service.
+func sourceKey(parent, name string) string {
+	if parent == "" {
+		return name
+	}
+	return parent + "#" + name
+}
+
+func (b *descriptorBuilder) message(msg message, path []int32) *descriptorpb.DescriptorProto {
+	b.paths[sourceKey("", msg.fullName)] = path
+	desc := &descriptorpb.DescriptorProto{Name: gproto.String(msg.Name)}
+
+	add := func(f field, oneofIndex *int32) {
+		fieldPath := appendPath(path, messageFieldTag, len(desc.Field))
+		b.paths[sourceKey(msg.fullName, f.Name)] = fieldPath
+		fd := b.field(f, msg.fullName)
+		fd.OneofIndex = oneofIndex
+		if f.MapKey != "" {
+			entry := b.mapEntry(f, msg.fullName)
+			fd.Type = descriptorpb.FieldDescriptorProto_TYPE_MESSAGE.Enum()
+			fd.TypeName = gproto.String("." + outputProtoPackage + "." + msg.fullName + "." + entry.GetName())
+			desc.NestedType = append(desc.NestedType, entry)
+		}
+		desc.Field = append(desc.Field, fd)
+	}
+	for _, f := range msg.Fields {
+		if len(f.Oneof) == 0 {
+			add(f, nil)
+			continue
+		}
+		index := int32(len(desc.OneofDecl))
+		b.paths[sourceKey(msg.fullName, f.Name)] = appendPath(path, messageOneofDeclTag, int(index))
+		desc.OneofDecl = append(desc.OneofDecl, &descriptorpb.OneofDescriptorProto{Name: gproto.String(f.Name)})
+		for _, member := range f.Oneof {
+			add(member, gproto.Int32(index))
+		}
+	}
+	for _, nested := range msg.Nested {
+		nestedPath := appendPath(path, messageNestedTypeTag, len(desc.NestedType))
+		desc.NestedType = append(desc.NestedType, b.message(nested, nestedPath))
+	}
+	return desc
+}
+
+func (b *descriptorBuilder) field(f field, scope string) *descriptorpb.FieldDescriptorProto {
+	fd := &descriptorpb.FieldDescriptorProto{
+		Name:     gproto.String(f.Name),
+		Number:   gproto.Int32(int32(f.Order)),
+		Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+		JsonName: gproto.String(jsonCamelCase(f.Name)),
+	}
+	switch {
+	case f.IsRepeated || f.MapKey != "":
+		fd.Label = descriptorpb.FieldDescriptorProto_LABEL_REPEATED.Enum()
+	case f.Label == "required":
+		fd.Label = descriptorpb.FieldDescriptorProto_LABEL_REQUIRED.Enum()
+	}
+	if f.MapKey == "" {
+		b.setType(fd, f.TypeName, scope)
+	}
+	options := func() *descriptorpb.FieldOptions {
+		if fd.Options == nil {
+			fd.Options = &descriptorpb.FieldOptions{}
+		}
+		return fd.Options
+	}
+	// options other than these have no descriptor field without their
+	// definition, and are left out
+	for _, option := range fieldOptions(f) {
+		name, value, ok := strings.Cut(option, "=")
+		if !ok {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		switch strings.TrimSpace(name) {
+		case "deprecated":
+			if value == "true" {
+				options().Deprecated = gproto.Bool(true)
+			}
+		case "json_name":
+			if jsonName, err := strconv.Unquote(value); err == nil {
+				fd.JsonName = gproto.String(jsonName)
+			}
+		case defaultOption:
+			if unquoted, err := strconv.Unquote(value); err == nil {
+				value = unquoted
+			}
+			fd.DefaultValue = gproto.String(value)
+		case fieldPresenceFeature:
+			if presence, ok := descriptorpb.FeatureSet_FieldPresence_value[value]; ok {
+				options().Features = &descriptorpb.FeatureSet{
+					FieldPresence: descriptorpb.FeatureSet_FieldPresence(presence).Enum(),
+				}
+			}
+		}
+	}
+	return fd
+}
+
+// mapEntry returns the nested message protoc generates for map field f.
+func (b *descriptorBuilder) mapEntry(f field, scope string) *descriptorpb.DescriptorProto {
+	key := &descriptorpb.FieldDescriptorProto{
+		Name:     gproto.String("key"),
+		Number:   gproto.Int32(1),
+		Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+		JsonName: gproto.String("key"),
+	}
+	b.setType(key, f.MapKey, scope)
+	value := &descriptorpb.FieldDescriptorProto{
+		Name:     gproto.String("value"),
+		Number:   gproto.Int32(2),
+		Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+		JsonName: gproto.String("value"),
+	}
+	b.setType(value, f.TypeName, scope)
+	return &descriptorpb.DescriptorProto{
+		Name:    gproto.String(mapEntryName(f.Name)),
+		Field:   []*descriptorpb.FieldDescriptorProto{key, value},
+		Options: &descriptorpb.MessageOptions{MapEntry: gproto.Bool(true)},
+	}
+}
+
+func (b *descriptorBuilder) setType(fd *descriptorpb.FieldDescriptorProto, typeName, scope string) {
+	if t, ok := protoScalarTypes[typeName]; ok {
+		fd.Type = t.Enum()
+		return
+	}
+	fd.Type = descriptorpb.FieldDescriptorProto_TYPE_MESSAGE.Enum()
+	fd.TypeName = gproto.String(b.resolve(scope, typeName))
+}
+
+// resolve returns the fully qualified name of message typeName referred to
+// from within message scope, see resolveName.
+func (b *descriptorBuilder) resolve(scope, typeName string) string {
+	if _, ok := wellKnownImports[typeName]; ok {
+		return "." + typeName
+	}
+	name, _ := resolveName(scope, typeName, func(name string) bool {
+		_, ok := b.names[name]
+		return ok
+	})
+	return "." + outputProtoPackage + "." + name
+}
+
+func (b *descriptorBuilder) service(svc service, path []int32) *descriptorpb.ServiceDescriptorProto {
+	key := sourceKey("", "service "+svc.Name)
+	b.paths[key] = path
+	desc := &descriptorpb.ServiceDescriptorProto{Name: gproto.String(svc.Name)}
+	for i, method := range svc.Methods {
+		b.paths[sourceKey(key, method.Name)] = appendPath(path, serviceMethodTag, i)
+		md := &descriptorpb.MethodDescriptorProto{
+			Name:       gproto.String(method.Name),
+			InputType:  gproto.String(b.resolve("", method.Request)),
+			OutputType: gproto.String(b.resolve("", method.Response)),
+		}
+		if method.ClientStreaming {
+			md.ClientStreaming = gproto.Bool(true)
+		}
+		if method.ServerStreaming {
+			md.ServerStreaming = gproto.Bool(true)
+		}
+		desc.Method = append(desc.Method, md)
+	}
+	return desc
+}
+
+// sourceInfo locates the generated messages, fields and services in src, the
+// text of the output proto, with their comments. Elements of src that aren't
+// generated, like hand-written messages kept by a merge, aren't in the
+// descriptor and are skipped.
+func (b *descriptorBuilder) sourceInfo(src protoSource) (*descriptorpb.SourceCodeInfo, error) {
+	definition, err := proto.NewParser(bytes.NewReader(src)).Parse()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", outputProtoName, err)
+	}
+
+	info := &descriptorpb.SourceCodeInfo{}
+	info.Location = append(info.Location, &descriptorpb.SourceCodeInfo_Location{
+		Path: []int32{},
+		Span: src.span(0, len(src)),
+	})
+	add := func(key string, start, end int, leading, trailing *proto.Comment) {
+		path, ok := b.paths[key]
+		if !ok {
+			return
+		}
+		loc := &descriptorpb.SourceCodeInfo_Location{Path: path, Span: src.span(start, end)}
+		if leading != nil {
+			loc.LeadingComments = gproto.String(commentText(leading))
+		}
+		if trailing != nil {
+			loc.TrailingComments = gproto.String(commentText(trailing))
+		}
+		info.Location = append(info.Location, loc)
+	}
+	addField := func(parent string, f *proto.Field) {
+		start := src.statementStart(f.Position.Offset)
+		add(sourceKey(parent, f.Name), start, src.statementEnd(start), f.Comment, f.InlineComment)
+	}
+
+	var addMessage func(m *proto.Message)
+	addMessage = func(m *proto.Message) {
+		name := protoPath(m.Name, m.Parent)
+		add(sourceKey("", name), m.Position.Offset, src.blockEnd(m.Position.Offset)+1, m.Comment, nil)
+		for _, element := range m.Elements {
+			switch e := element.(type) {
+			case *proto.NormalField:
+				addField(name, e.Field)
+			case *proto.MapField:
+				addField(name, e.Field)
+			case *proto.Oneof:
+				add(sourceKey(name, e.Name), e.Position.Offset, src.blockEnd(e.Position.Offset)+1, e.Comment, nil)
+				for _, oneofElement := range e.Elements {
+					if member, ok := oneofElement.(*proto.OneOfField); ok {
+						addField(name, member.Field)
+					}
+				}
+			case *proto.Message:
+				addMessage(e)
+			}
+		}
+	}
+	for _, element := range definition.Elements {
+		switch e := element.(type) {
+		case *proto.Message:
+			addMessage(e)
+		case *proto.Service:
+			key := sourceKey("", "service "+e.Name)
+			add(key, e.Position.Offset, src.blockEnd(e.Position.Offset)+1, e.Comment, nil)
+			for _, serviceElement := range e.Elements {
+				if r, ok := serviceElement.(*proto.RPC); ok {
+					add(sourceKey(key, r.Name), r.Position.Offset, src.statementEnd(r.Position.Offset), r.Comment, r.InlineComment)
+				}
+			}
+		}
+	}
+	return info, nil
+}
+
+// span returns the source info span from offset start to end: the line and
+// column of the start, the line of the end if it differs and the column of
+// the end, counting from 0.
+func (s protoSource) span(start, end int) []int32 {
+	startLine, startColumn := s.lineColumn(start)
+	endLine, endColumn := s.lineColumn(end)
+	if startLine == endLine {
+		return []int32{startLine, startColumn, endColumn}
+	}
+	return []int32{startLine, startColumn, endLine, endColumn}
+}
+
+func (s protoSource) lineColumn(offset int) (int32, int32) {
+	return int32(bytes.Count(s[:offset], []byte("\n"))), int32(offset - s.lineStart(offset))
+}
+
+// commentText returns the text of comment c like protoc puts it in the source
+// info: each line without the slashes, ending with a newline.
+func commentText(c *proto.Comment) string {
+	var text strings.Builder
+	for _, line := range c.Lines {
+		text.WriteString(line)
+		text.WriteString("\n")
+	}
+	return text.String()
+}
+
+func appendPath(path []int32, tag, index int) []int32 {
+	out := make([]int32, len(path), len(path)+2)
+	copy(out, path)
+	return append(out, int32(tag), int32(index))
+}
+
+// mapEntryName returns the name protoc gives the entry message of a map field,
+// e.g. ByIdEntry for by_id.
+func mapEntryName(fieldName string) string {
+	var name []byte
+	upper := true
+	for i := 0; i < len(fieldName); i++ {
+		c := fieldName[i]
+		switch {
+		case c == '_':
+			upper = true
+		case upper && isASCIILower(c):
+			name = append(name, c-('a'-'A'))
+			upper = false
+		default:
+			name = append(name, c)
+			upper = false
+		}
+	}
+	return string(name) + "Entry"
+}
+
+// jsonCamelCase returns the default JSON name protoc gives a field, e.g.
+// createdAt for created_at.
+func jsonCamelCase(fieldName string) string {
+	var name []byte
+	upper := false
+	for i := 0; i < len(fieldName); i++ {
+		c := fieldName[i]
+		switch {
+		case c == '_':
+			upper = true
+		case upper && isASCIILower(c):
+			name = append(name, c-('a'-'A'))
+			upper = false
+		default:
+			name = append(name, c)
+			upper = false
+		}
+	}
+	return string(name)
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"go/types"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+func TestProtocNames(t *testing.T) {
+	t.Parallel()
+
+	var testCases = []struct {
+		testName         string
+		given            string
+		expectedEntry    string
+		expectedJSONName string
+	}{
+		{testName: "camel case", given: "byID", expectedEntry: "ByIDEntry", expectedJSONName: "byID"},
+		{testName: "snake case", given: "by_id", expectedEntry: "ByIdEntry", expectedJSONName: "byId"},
+	}
+
+	for _, testCase := range testCases {
+		assert.Equal(t, testCase.expectedEntry, mapEntryName(testCase.given), testCase.testName)
+		assert.Equal(t, testCase.expectedJSONName, jsonCamelCase(testCase.given), testCase.testName)
+	}
+}
+
+func TestBuildDescriptorSet(t *testing.T) {
+	t.Parallel()
+
+	msgs := []message{
+		{
+			Name:     "Order",
+			fullName: "Order",
+			Fields: []field{
+				{Name: "created_at", TypeName: "google.protobuf.Timestamp", Order: 1, Comment: "// when"},
+				{Name: "lines", TypeName: "Line", Order: 2, IsRepeated: true, Options: []string{"deprecated = true"}},
+				{Name: "byID", TypeName: "Line", MapKey: "string", Order: 3},
+				{Name: "main", Oneof: []field{{Name: "mainLine", TypeName: "Line", Order: 4}}},
+				{Name: "meta", TypeName: "Meta", Order: 5, Options: []string{`json_name = "m"`}},
+			},
+			Nested: []message{
+				{Name: "Meta", fullName: "Order.Meta", Fields: []field{{Name: "a", TypeName: "string", Order: 1}}},
+			},
+		},
+		{Name: "Line", fullName: "Line", Fields: []field{{Name: "qty", TypeName: "uint32", Order: 1}}},
+	}
+	services := []service{{Name: "Orders", Methods: []rpc{{Name: "Watch", Request: "Line", Response: "Order", ServerStreaming: true}}}}
+
+	var src strings.Builder
+	err := outputTemplate().Execute(&src, outputData{Imports: getImports(msgs, services), Messages: msgs, Services: services})
+	assert.NoError(t, err)
+
+	set, err := buildDescriptorSet(msgs, services, "", []byte(src.String()))
+	assert.NoError(t, err)
+	_, err = protodesc.NewFiles(set)
+	assert.NoError(t, err)
+
+	assert.Len(t, set.File, 2)
+	assert.Equal(t, "google/protobuf/timestamp.proto", set.File[0].GetName())
+	file := set.File[1]
+	assert.Equal(t, []string{"google/protobuf/timestamp.proto"}, file.Dependency)
+
+	order := file.MessageType[0]
+	assert.Equal(t, "createdAt", order.Field[0].GetJsonName())
+	assert.True(t, order.Field[1].GetOptions().GetDeprecated())
+	assert.Equal(t, ".proto.Order.ByIDEntry", order.Field[2].GetTypeName())
+	assert.Equal(t, "ByIDEntry", order.NestedType[0].GetName())
+	assert.True(t, order.NestedType[0].GetOptions().GetMapEntry())
+	assert.Equal(t, int32(0), order.Field[3].GetOneofIndex())
+	assert.Equal(t, ".proto.Order.Meta", order.Field[4].GetTypeName())
+	assert.Equal(t, "m", order.Field[4].GetJsonName())
+	assert.Equal(t, "Meta", order.NestedType[1].GetName())
+	assert.True(t, file.Service[0].Method[0].GetServerStreaming())
+
+	locations := make(map[string]string)
+	for _, loc := range file.SourceCodeInfo.Location {
+		locations[fmt.Sprint(loc.Path)] = fmt.Sprint(loc.Span) + " " + loc.GetLeadingComments() + "|" + loc.GetTrailingComments()
+	}
+	assert.Equal(t, "[7 0 18 1] easyjson:json\n|", locations["[4 0]"])
+	assert.Equal(t, "[8 2 43] | when\n", locations["[4 0 2 0]"])
+	assert.Equal(t, "[11 2 13 3] |", locations["[4 0 8 0]"])
+	assert.Equal(t, "[12 4 22] |", locations["[4 0 2 3]"])
+	assert.Equal(t, "[26 2 41] |", locations["[6 0 2 0]"])
+}
+
+func TestBuildDescriptorSet_Syntax(t *testing.T) {
+	t.Parallel()
+
+	var testCases = []struct {
+		testName         string
+		given            Syntax
+		expectedPresence []bool
+	}{
+		{testName: "proto3", given: SyntaxProto3, expectedPresence: []bool{false, false, false, true}},
+		{testName: "proto2", given: SyntaxProto2, expectedPresence: []bool{true, true, true, true}},
+		{testName: "edition", given: SyntaxEdition2023, expectedPresence: []bool{false, true, true, true}},
+	}
+
+	for _, testCase := range testCases {
+		fields := []field{
+			{Name: "id", TypeName: "string", Order: 1},
+			{Name: "note", TypeName: "string", Order: 2, goType: types.NewPointer(types.Typ[types.String])},
+			{Name: "retries", TypeName: "int32", Order: 3},
+			{Name: "meta", TypeName: "Meta", Order: 4},
+		}
+		if !testCase.given.isProto3() {
+			fields[2].defaultValue = "5"
+			fields = append(fields, field{Name: "total", TypeName: "int64", Order: 5, required: true})
+		}
+		msgs, err := applySyntax([]message{
+			{Name: "Order", fullName: "Order", Fields: fields},
+			{Name: "Meta", fullName: "Meta"},
+		}, testCase.given)
+		assert.NoError(t, err, testCase.testName)
+
+		var src strings.Builder
+		assert.NoError(t, outputTemplate().Execute(&src, outputData{Syntax: testCase.given, Imports: getImports(msgs, nil), Messages: msgs}), testCase.testName)
+		assert.NoError(t, validateProto([]byte(src.String()), outputProtoName), testCase.testName)
+
+		set, err := buildDescriptorSet(msgs, nil, testCase.given, []byte(src.String()))
+		assert.NoError(t, err, testCase.testName)
+		files, err := protodesc.NewFiles(set)
+		if !assert.NoError(t, err, testCase.testName) {
+			continue
+		}
+		desc, err := files.FindDescriptorByName("proto.Order")
+		assert.NoError(t, err, testCase.testName)
+		order := desc.(protoreflect.MessageDescriptor)
+		for i, expected := range testCase.expectedPresence {
+			assert.Equal(t, expected, order.Fields().Get(i).HasPresence(), "%s: %s", testCase.testName, order.Fields().Get(i).Name())
+		}
+		if !testCase.given.isProto3() {
+			assert.Equal(t, int64(5), order.Fields().ByName("retries").Default().Int(), testCase.testName)
+			assert.Equal(t, protoreflect.Required, order.Fields().ByName("total").Cardinality(), testCase.testName)
+		}
+	}
+}
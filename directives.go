@@ -0,0 +1,204 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+	"strings"
+
+	"golang.org/x/tools/go/packages"
+)
+
+const directivePrefix = "//go2proto:"
+
+// Directive holds the options set by //go2proto: comments on a type or a field.
+type Directive struct {
+	Ignore   bool
+	Name     string
+	TypeName string
+	// Repeated makes the field of TypeName repeated, whatever its Go type
+	Repeated bool
+	Encoding Encoding
+	// Service marks an interface as a gRPC service, see getServices
+	Service bool
+	// Required and Default set the label and default value of a field, for
+	// the proto2 syntax and editions, see withPresence
+	Required bool
+	Default  string
+}
+
+type DirectiveMap map[types.Object]Directive
+
+func BuildDirectiveMap(pkgs []*packages.Package) (DirectiveMap, error) {
+	d := make(DirectiveMap)
+	for _, p := range pkgs {
+		for _, file := range p.Syntax {
+			var err error
+			ast.Inspect(file, func(n ast.Node) bool {
+				if err != nil {
+					return false
+				}
+				switch n := n.(type) {
+				case *ast.GenDecl:
+					err = d.addTypeDecl(p, n)
+				case *ast.StructType:
+					err = d.addStructFields(p, n)
+				}
+				return true
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return d, nil
+}
+
+func (d DirectiveMap) addTypeDecl(p *packages.Package, decl *ast.GenDecl) error {
+	if decl.Tok != token.TYPE {
+		return nil
+	}
+	for _, spec := range decl.Specs {
+		typeSpec := spec.(*ast.TypeSpec)
+		doc := typeSpec.Doc
+		if doc == nil && len(decl.Specs) == 1 {
+			doc = decl.Doc
+		}
+		if err := d.add(p, []*ast.Ident{typeSpec.Name}, doc, typeSpec.Comment); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d DirectiveMap) addStructFields(p *packages.Package, s *ast.StructType) error {
+	for _, f := range s.Fields.List {
+		idents := f.Names
+		if len(idents) == 0 {
+			if ident := embeddedFieldIdent(f.Type); ident != nil {
+				idents = []*ast.Ident{ident}
+			}
+		}
+		if err := d.add(p, idents, f.Doc, f.Comment); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d DirectiveMap) add(p *packages.Package, idents []*ast.Ident, groups ...*ast.CommentGroup) error {
+	directive, found, err := parseDirectives(p.Fset, groups...)
+	if err != nil || !found {
+		return err
+	}
+	for _, ident := range idents {
+		if obj := p.TypesInfo.Defs[ident]; obj != nil {
+			d[obj] = directive
+		}
+	}
+	return nil
+}
+
+// Get returns the directive attached to obj, if any.
+func (d DirectiveMap) Get(obj types.Object) Directive {
+	if obj == nil {
+		return Directive{}
+	}
+	return d[obj]
+}
+
+// MessageName returns the proto message name for the named type obj.
+func (d DirectiveMap) MessageName(obj types.Object) string {
+	if name := d.Get(obj).Name; name != "" {
+		return name
+	}
+	return obj.Name()
+}
+
+func parseDirectives(fset *token.FileSet, groups ...*ast.CommentGroup) (Directive, bool, error) {
+	var (
+		directive Directive
+		found     bool
+	)
+	for _, group := range groups {
+		if group == nil {
+			continue
+		}
+		for _, c := range group.List {
+			if !strings.HasPrefix(c.Text, directivePrefix) {
+				continue
+			}
+			found = true
+			if err := directive.parse(strings.TrimPrefix(c.Text, directivePrefix)); err != nil {
+				return Directive{}, false, fmt.Errorf("%s: %v", fset.Position(c.Pos()), err)
+			}
+		}
+	}
+	return directive, found, nil
+}
+
+func (d *Directive) parse(text string) error {
+	parts := strings.Fields(text)
+	for i := 0; i < len(parts); i++ {
+		part := parts[i]
+		kv := strings.SplitN(part, "=", 2)
+		key, value := kv[0], ""
+		if len(kv) == 2 {
+			value = kv[1]
+		}
+		switch key {
+		case "ignore":
+			d.Ignore = true
+		case "service":
+			d.Service = true
+		case "required":
+			d.Required = true
+		case "default":
+			if value == "" {
+				return fmt.Errorf("go2proto directive %q requires a value", key)
+			}
+			d.Default = value
+		case "name":
+			if value == "" {
+				return fmt.Errorf("go2proto directive %q requires a value", key)
+			}
+			d.Name = value
+		case "type":
+			if value == "" {
+				return fmt.Errorf("go2proto directive %q requires a value", key)
+			}
+			// type=repeated Foo makes a repeated field of type Foo
+			if value == "repeated" && i+1 < len(parts) {
+				d.Repeated = true
+				i++
+				value = parts[i]
+			}
+			d.TypeName = value
+		case "encoding":
+			d.Encoding = Encoding(value)
+			if err := d.Encoding.validate(); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("unknown go2proto directive %q", key)
+		}
+	}
+	return nil
+}
+
+func embeddedFieldIdent(e ast.Expr) *ast.Ident {
+	switch e := e.(type) {
+	case *ast.Ident:
+		return e
+	case *ast.StarExpr:
+		return embeddedFieldIdent(e.X)
+	case *ast.SelectorExpr:
+		return e.Sel
+	case *ast.IndexExpr:
+		return embeddedFieldIdent(e.X)
+	case *ast.IndexListExpr:
+		return embeddedFieldIdent(e.X)
+	}
+	return nil
+}
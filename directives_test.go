@@ -0,0 +1,109 @@
+package main
+
+import (
+	"go/ast"
+	"go/token"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseDirectives(t *testing.T) {
+	t.Parallel()
+
+	var testCases = []struct {
+		testName      string
+		given         []string
+		expected      Directive
+		expectedFound bool
+		expectedErr   bool
+	}{
+		{
+			testName: "no comments",
+			given:    []string{},
+			expected: Directive{},
+		},
+		{
+			testName: "regular comment",
+			given:    []string{"// User is a user."},
+			expected: Directive{},
+		},
+		{
+			testName:      "ignore",
+			given:         []string{"//go2proto:ignore"},
+			expected:      Directive{Ignore: true},
+			expectedFound: true,
+		},
+		{
+			testName:      "name and type on separate lines",
+			given:         []string{"// Data is raw data.", "//go2proto:name=payload", "//go2proto:type=bytes"},
+			expected:      Directive{Name: "payload", TypeName: "bytes"},
+			expectedFound: true,
+		},
+		{
+			testName:      "name and type on one line",
+			given:         []string{"//go2proto:name=payload type=bytes"},
+			expected:      Directive{Name: "payload", TypeName: "bytes"},
+			expectedFound: true,
+		},
+		{
+			testName:      "repeated type",
+			given:         []string{"//go2proto:type=repeated bytes name=chunks"},
+			expected:      Directive{Name: "chunks", TypeName: "bytes", Repeated: true},
+			expectedFound: true,
+		},
+		{
+			testName: "directive with space is a regular comment",
+			given:    []string{"// go2proto:ignore"},
+			expected: Directive{},
+		},
+		{
+			testName:      "service",
+			given:         []string{"//go2proto:service name=Users"},
+			expected:      Directive{Service: true, Name: "Users"},
+			expectedFound: true,
+		},
+		{
+			testName:      "encoding",
+			given:         []string{"//go2proto:encoding=fixed"},
+			expected:      Directive{Encoding: EncodingFixed},
+			expectedFound: true,
+		},
+		{
+			testName:      "required and default",
+			given:         []string{"//go2proto:required default=5"},
+			expected:      Directive{Required: true, Default: "5"},
+			expectedFound: true,
+		},
+		{
+			testName:    "unknown encoding",
+			given:       []string{"//go2proto:encoding=zigzag"},
+			expectedErr: true,
+		},
+		{
+			testName:    "missing value",
+			given:       []string{"//go2proto:name"},
+			expectedErr: true,
+		},
+		{
+			testName:    "unknown directive",
+			given:       []string{"//go2proto:bogus"},
+			expectedErr: true,
+		},
+	}
+
+	for _, testCase := range testCases {
+		group := &ast.CommentGroup{}
+		for _, text := range testCase.given {
+			group.List = append(group.List, &ast.Comment{Text: text})
+		}
+		result, found, err := parseDirectives(token.NewFileSet(), group)
+		if testCase.expectedErr {
+			assert.Error(t, err, testCase.testName)
+			continue
+		}
+		assert.NoError(t, err, testCase.testName)
+		assert.Equal(t, testCase.expectedFound, found, testCase.testName)
+		assert.Equal(t, testCase.expected, result, testCase.testName)
+	}
+}
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"fmt"
+	"go/types"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// protoType is the proto type of a field: a scalar or message name, which is
+// repeated or the value of a map with key type MapKey. For interfaces with
+// known implementations, Oneof holds the member types.
+type protoType struct {
+	Name     string
+	Repeated bool
+	MapKey   string
+	Oneof    []string
+}
+
+// nestedRef names the nested message generated for an anonymous struct, both
+// relative to its parent and fully qualified.
+type nestedRef struct {
+	Name     string
+	FullName string
+}
+
+// typeResolver maps Go types to proto types. Proto has no repeated repeated
+// fields, no repeated maps and no maps of repeated values, so the inner
+// dimension of such types is wrapped in a generated message with a single
+// values field, shared by all fields of the same shape.
+type typeResolver struct {
+	cfg               Config
+	directives        DirectiveMap
+	structTypes       []types.Object
+	messageNames      map[string]struct{}
+	currProtoMessages ProtoMessageMap
+
+	wrappers      map[string]message
+	wrapperNames  map[string]string
+	instances     map[string]message
+	instanceNames map[string]string
+	// messages are the request and response messages of rpcs
+	messages map[string]message
+}
+
+// newTypeResolver returns a resolver for fields referring to structTypes, the
+// struct types of the loaded packages.
+func newTypeResolver(cfg Config, directives DirectiveMap, structTypes []types.Object, currProtoMessages ProtoMessageMap) *typeResolver {
+	messageNames := make(map[string]struct{}, len(structTypes))
+	for _, t := range structTypes {
+		messageNames[directives.MessageName(t)] = struct{}{}
+	}
+	return &typeResolver{
+		cfg:               cfg,
+		directives:        directives,
+		structTypes:       structTypes,
+		messageNames:      messageNames,
+		currProtoMessages: currProtoMessages,
+		wrappers:          make(map[string]message),
+		wrapperNames:      make(map[string]string),
+		instances:         make(map[string]message),
+		instanceNames:     make(map[string]string),
+		messages:          make(map[string]message),
+	}
+}
+
+// Wrappers returns the generated wrapper messages sorted by name.
+func (r *typeResolver) Wrappers() []message {
+	out := make([]message, 0, len(r.wrappers))
+	for _, msg := range r.wrappers {
+		out = append(out, msg)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+// Messages returns the generated request and response messages of rpcs
+// sorted by name.
+func (r *typeResolver) Messages() []message {
+	out := make([]message, 0, len(r.messages))
+	for _, msg := range r.messages {
+		out = append(out, msg)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+// Instances returns the messages generated for instantiated generic types,
+// keyed by name.
+func (r *typeResolver) Instances() map[string]message {
+	return r.instances
+}
+
+// generatedNames returns the names of all messages, generated or not.
+func (r *typeResolver) generatedNames() map[string]struct{} {
+	taken := make(map[string]struct{}, len(r.messageNames)+len(r.wrappers)+len(r.instances)+len(r.messages))
+	for name := range r.messageNames {
+		taken[name] = struct{}{}
+	}
+	for name := range r.wrappers {
+		taken[name] = struct{}{}
+	}
+	for name := range r.instances {
+		taken[name] = struct{}{}
+	}
+	for name := range r.messages {
+		taken[name] = struct{}{}
+	}
+	return taken
+}
+
+// resolve returns the proto type of t. time.Time is a Timestamp, named types
+// carrying a name or type directive use it, byte slices and byte arrays are
+// bytes, slices and arrays are repeated. anon is used for the anonymous struct
+// at the leaf of t, if any. Funcs and channels have no proto type.
+func (r *typeResolver) resolve(t types.Type, enc Encoding, anon nestedRef) (protoType, error) {
+	for {
+		if isTime(t) {
+			return protoType{Name: timestampTypeName}, nil
+		}
+		if named, ok := t.(*types.Named); ok {
+			directive := r.directives.Get(named.Obj())
+			if directive.TypeName != "" {
+				return protoType{Name: directive.TypeName}, nil
+			}
+			if _, ok := named.Underlying().(*types.Struct); ok && isInstance(named) {
+				name, err := r.instance(named)
+				return protoType{Name: name}, err
+			}
+			if directive.Name != "" {
+				return protoType{Name: directive.Name}, nil
+			}
+		}
+		if isBytes(t) {
+			return protoType{Name: "bytes"}, nil
+		}
+
+		switch u := t.Underlying().(type) {
+		case *types.Pointer:
+			t = u.Elem()
+			continue
+		case *types.Slice:
+			name, err := r.element(u.Elem(), enc, anon)
+			return protoType{Name: name, Repeated: true}, err
+		case *types.Array:
+			name, err := r.element(u.Elem(), enc, anon)
+			return protoType{Name: name, Repeated: true}, err
+		case *types.Map:
+			key, err := r.mapKey(u.Key(), enc)
+			if err != nil {
+				return protoType{}, err
+			}
+			name, err := r.element(u.Elem(), enc, anon)
+			return protoType{Name: name, MapKey: key}, err
+		case *types.Basic:
+			name, err := scalarType(u, enc)
+			return protoType{Name: name}, err
+		case *types.Interface:
+			return r.interfaceType(t, u), nil
+		case *types.Struct:
+			if _, ok := t.(*types.Named); !ok && anon.Name != "" {
+				return protoType{Name: anon.Name}, nil
+			}
+		case *types.Signature, *types.Chan:
+			return protoType{}, fmt.Errorf("unsupported type %s", t)
+		}
+
+		if named, ok := t.(*types.Named); ok {
+			return protoType{Name: named.Obj().Name()}, nil
+		}
+		return protoType{}, fmt.Errorf("unsupported type %s", t)
+	}
+}
+
+// element returns the type name of a repeated element or map value of type t,
+// wrapping it in a message if it is repeated or a map itself.
+func (r *typeResolver) element(t types.Type, enc Encoding, anon nestedRef) (string, error) {
+	// wrappers are top-level messages, so they refer to nested messages by
+	// their full name
+	pt, err := r.resolve(t, enc, nestedRef{Name: anon.FullName, FullName: anon.FullName})
+	if err != nil {
+		return "", err
+	}
+	switch {
+	case pt.Repeated:
+		return r.wrapper(wrapperBaseName(pt.Name)+"List", pt), nil
+	case pt.MapKey != "":
+		return r.wrapper(wrapperBaseName(pt.MapKey)+wrapperBaseName(pt.Name)+"Map", pt), nil
+	case len(pt.Oneof) > 0:
+		return r.oneofWrapper(pt), nil
+	}
+	if anon.Name != "" && pt.Name == anon.FullName {
+		return anon.Name, nil
+	}
+	return pt.Name, nil
+}
+
+func (r *typeResolver) wrapper(baseName string, pt protoType) string {
+	key := fmt.Sprintf("%t/%s/%s", pt.Repeated, pt.MapKey, pt.Name)
+	return r.addWrapper(key, baseName, field{
+		Name:       "values",
+		TypeName:   pt.Name,
+		IsRepeated: pt.Repeated,
+		MapKey:     pt.MapKey,
+	})
+}
+
+// addWrapper adds a generated message with the single field f, unless one was
+// already added for key, and returns its name.
+func (r *typeResolver) addWrapper(key, baseName string, f field) string {
+	if name, ok := r.wrapperNames[key]; ok {
+		return name
+	}
+
+	name := uniqueName(baseName, r.generatedNames())
+	r.wrapperNames[key] = name
+	r.wrappers[name] = message{
+		Name:     name,
+		Fields:   []field{numberField(f, name, r.currProtoMessages)},
+		fullName: name,
+	}
+	return name
+}
+
+// mapKey returns the proto type of map key type t. Proto only allows integer,
+// bool and string keys.
+func (r *typeResolver) mapKey(t types.Type, enc Encoding) (string, error) {
+	b, ok := t.Underlying().(*types.Basic)
+	if !ok || b.Info()&(types.IsInteger|types.IsBoolean|types.IsString) == 0 {
+		return "", fmt.Errorf("unsupported map key type %s", t)
+	}
+	return scalarType(b, enc)
+}
+
+// wrapperBaseName turns a proto type name into a message name prefix, e.g.
+// int64 into Int64 and Order.Line into OrderLine.
+func wrapperBaseName(typeName string) string {
+	var b strings.Builder
+	for _, part := range strings.Split(typeName, ".") {
+		r, n := utf8.DecodeRuneInString(part)
+		b.WriteRune(unicode.ToUpper(r))
+		b.WriteString(part[n:])
+	}
+	return b.String()
+}
+
+// isTime reports whether t is time.Time, which is a google.protobuf.Timestamp.
+func isTime(t types.Type) bool {
+	named, ok := t.(*types.Named)
+	return ok && named.Obj().Pkg() != nil && named.Obj().Pkg().Path() == "time" && named.Obj().Name() == "Time"
+}
+
+// isBytes reports whether t is a byte slice or a byte array, including named
+// ones like json.RawMessage or net.IP.
+func isBytes(t types.Type) bool {
+	var elem types.Type
+	switch u := t.Underlying().(type) {
+	case *types.Slice:
+		elem = u.Elem()
+	case *types.Array:
+		elem = u.Elem()
+	default:
+		return false
+	}
+	b, ok := elem.Underlying().(*types.Basic)
+	return ok && b.Kind() == types.Uint8
+}
+
+// anonymousStruct returns the struct literal type of t, looking through
+// pointers, slices, arrays and map values.
+func anonymousStruct(t types.Type) (*types.Struct, bool) {
+	for {
+		switch typ := t.(type) {
+		case *types.Pointer:
+			t = typ.Elem()
+		case *types.Slice:
+			t = typ.Elem()
+		case *types.Array:
+			t = typ.Elem()
+		case *types.Map:
+			t = typ.Elem()
+		case *types.Struct:
+			return typ, true
+		default:
+			return nil, false
+		}
+	}
+}
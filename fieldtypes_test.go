@@ -0,0 +1,152 @@
+package main
+
+import (
+	"go/types"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsBytes(t *testing.T) {
+	t.Parallel()
+
+	byteType := types.Typ[types.Byte]
+	named := func(name string, underlying types.Type) types.Type {
+		return types.NewNamed(types.NewTypeName(0, nil, name, nil), underlying, nil)
+	}
+
+	var testCases = []struct {
+		testName string
+		given    types.Type
+		expected bool
+	}{
+		{testName: "byte", given: byteType, expected: false},
+		{testName: "byte slice", given: types.NewSlice(byteType), expected: true},
+		{testName: "uint8 slice", given: types.NewSlice(types.Typ[types.Uint8]), expected: true},
+		{testName: "byte array", given: types.NewArray(byteType, 16), expected: true},
+		{testName: "named byte slice", given: named("RawMessage", types.NewSlice(byteType)), expected: true},
+		{testName: "named byte array", given: named("UUID", types.NewArray(byteType, 16)), expected: true},
+		{testName: "slice of named byte", given: types.NewSlice(named("Octet", byteType)), expected: true},
+		{testName: "slice of byte slices", given: types.NewSlice(types.NewSlice(byteType)), expected: false},
+		{testName: "int slice", given: types.NewSlice(types.Typ[types.Int]), expected: false},
+		{testName: "rune slice", given: types.NewSlice(types.Typ[types.Rune]), expected: false},
+	}
+
+	for _, testCase := range testCases {
+		result := isBytes(testCase.given)
+		assert.Equal(t, testCase.expected, result, testCase.testName)
+	}
+}
+
+func TestTypeResolver_Resolve(t *testing.T) {
+	t.Parallel()
+
+	float64Type := types.Typ[types.Float64]
+	stringType := types.Typ[types.String]
+	userType := types.NewNamed(types.NewTypeName(0, nil, "User", nil), types.NewStruct(nil, nil), nil)
+
+	var testCases = []struct {
+		testName         string
+		given            types.Type
+		expected         protoType
+		expectedWrappers []message
+	}{
+		{
+			testName: "scalar",
+			given:    float64Type,
+			expected: protoType{Name: "double"},
+		},
+		{
+			testName: "pointer to message",
+			given:    types.NewPointer(userType),
+			expected: protoType{Name: "User"},
+		},
+		{
+			testName: "slice",
+			given:    types.NewSlice(float64Type),
+			expected: protoType{Name: "double", Repeated: true},
+		},
+		{
+			testName: "array",
+			given:    types.NewArray(stringType, 3),
+			expected: protoType{Name: "string", Repeated: true},
+		},
+		{
+			testName: "slice of slices",
+			given:    types.NewSlice(types.NewSlice(float64Type)),
+			expected: protoType{Name: "DoubleList", Repeated: true},
+			expectedWrappers: []message{
+				{
+					Name:     "DoubleList",
+					Fields:   []field{{Name: "values", TypeName: "double", IsRepeated: true, Order: 1}},
+					fullName: "DoubleList",
+				},
+			},
+		},
+		{
+			testName: "map",
+			given:    types.NewMap(stringType, userType),
+			expected: protoType{Name: "User", MapKey: "string"},
+		},
+		{
+			testName: "map of slices",
+			given:    types.NewMap(stringType, types.NewSlice(userType)),
+			expected: protoType{Name: "UserList", MapKey: "string"},
+			expectedWrappers: []message{
+				{
+					Name:     "UserList",
+					Fields:   []field{{Name: "values", TypeName: "User", IsRepeated: true, Order: 1}},
+					fullName: "UserList",
+				},
+			},
+		},
+		{
+			testName: "slice of maps",
+			given:    types.NewSlice(types.NewMap(stringType, float64Type)),
+			expected: protoType{Name: "StringDoubleMap", Repeated: true},
+			expectedWrappers: []message{
+				{
+					Name:     "StringDoubleMap",
+					Fields:   []field{{Name: "values", TypeName: "double", MapKey: "string", Order: 1}},
+					fullName: "StringDoubleMap",
+				},
+			},
+		},
+	}
+
+	for _, testCase := range testCases {
+		resolver := newTypeResolver(Config{}, DirectiveMap{}, []types.Object{userType.Obj()}, ProtoMessageMap{})
+		result, err := resolver.resolve(testCase.given, "", nestedRef{})
+		assert.NoError(t, err, testCase.testName)
+		assert.Equal(t, testCase.expected, result, testCase.testName)
+		if testCase.expectedWrappers == nil {
+			testCase.expectedWrappers = []message{}
+		}
+		assert.Equal(t, testCase.expectedWrappers, resolver.Wrappers(), testCase.testName)
+	}
+}
+
+func TestTypeResolver_ResolveWrapperNameTaken(t *testing.T) {
+	t.Parallel()
+
+	doubleList := types.NewNamed(types.NewTypeName(0, nil, "DoubleList", nil), types.NewStruct(nil, nil), nil)
+	resolver := newTypeResolver(Config{}, DirectiveMap{}, []types.Object{doubleList.Obj()}, ProtoMessageMap{})
+	matrix := types.NewSlice(types.NewSlice(types.Typ[types.Float64]))
+
+	first, err := resolver.resolve(matrix, "", nestedRef{})
+	assert.NoError(t, err)
+	second, err := resolver.resolve(matrix, "", nestedRef{})
+	assert.NoError(t, err)
+
+	assert.Equal(t, protoType{Name: "DoubleList2", Repeated: true}, first)
+	assert.Equal(t, first, second)
+	assert.Len(t, resolver.Wrappers(), 1)
+}
+
+func TestTypeResolver_ResolveInvalidMapKey(t *testing.T) {
+	t.Parallel()
+
+	resolver := newTypeResolver(Config{}, DirectiveMap{}, nil, ProtoMessageMap{})
+	_, err := resolver.resolve(types.NewMap(types.Typ[types.Float64], types.Typ[types.Int]), "", nestedRef{})
+	assert.Error(t, err)
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"go/types"
+	"text/template"
+)
+
+// defaultGenericNameTemplate names Page[User] PageUser and Pair[string, int]
+// PairStringInt64.
+const defaultGenericNameTemplate = "{{.Name}}{{range .Args}}{{.}}{{end}}"
+
+// genericName is the data of the generic name template: the message name of
+// the generic type and the names of its type arguments, in the style of
+// wrapper names (User, Int64, UserList).
+type genericName struct {
+	Name string
+	Args []string
+}
+
+func parseGenericNameTemplate(text string) (*template.Template, error) {
+	if text == "" {
+		text = defaultGenericNameTemplate
+	}
+	return template.New("generic_name").Parse(text)
+}
+
+// isGeneric reports whether t is a generic type declaration, which can't be
+// generated as is.
+func isGeneric(t types.Type) bool {
+	named, ok := t.(*types.Named)
+	return ok && named.TypeParams().Len() > 0
+}
+
+// isInstance reports whether t is an instantiated generic type.
+func isInstance(t types.Type) bool {
+	named, ok := t.(*types.Named)
+	return ok && named.TypeArgs().Len() > 0
+}
+
+// instance returns the name of the message generated for the instantiated
+// generic struct type named, generating it on first use.
+func (r *typeResolver) instance(named *types.Named) (string, error) {
+	key := types.TypeString(named, nil)
+	if name, ok := r.instanceNames[key]; ok {
+		return name, nil
+	}
+
+	data := genericName{Name: r.directives.MessageName(named.Obj())}
+	for i := 0; i < named.TypeArgs().Len(); i++ {
+		arg, err := r.typeArgName(named.TypeArgs().At(i))
+		if err != nil {
+			return "", err
+		}
+		data.Args = append(data.Args, arg)
+	}
+	tmpl, err := parseGenericNameTemplate(r.cfg.GenericNameTemplate)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", err
+	}
+
+	name := uniqueName(buf.String(), r.generatedNames())
+	// registered before the fields are resolved, so that recursive types
+	// refer to the message being built
+	r.instanceNames[key] = name
+	r.instances[name] = message{Name: name, fullName: name}
+
+	msg, err := getMessage(name, name, named.Underlying().(*types.Struct), r.cfg, r.currProtoMessages, r)
+	if err != nil {
+		return "", err
+	}
+	msg.pos = named.Obj().Pos()
+	msg.goType = named
+	r.instances[name] = msg
+	return name, nil
+}
+
+// typeArgName returns the name of type argument t used in generated names.
+func (r *typeResolver) typeArgName(t types.Type) (string, error) {
+	pt, err := r.resolve(t, "", nestedRef{})
+	if err != nil {
+		return "", err
+	}
+	switch {
+	case pt.Repeated:
+		return wrapperBaseName(pt.Name) + "List", nil
+	case pt.MapKey != "":
+		return wrapperBaseName(pt.MapKey) + wrapperBaseName(pt.Name) + "Map", nil
+	}
+	return wrapperBaseName(pt.Name), nil
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"go/types"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const genericsSource = `package p
+
+type Page[T any] struct {
+	Items []T
+	Next  *Page[T]
+}
+
+type Pair[K comparable, V any] struct {
+	Key   K
+	Value V
+}
+
+type User struct{ Name string }
+
+type PageUser struct{ ID string }
+
+type Resp struct {
+	Users  Page[User]
+	Counts Pair[string, []int32]
+	Lookup Pair[string, map[string]User]
+}
+`
+
+func TestTypeResolver_ResolveGeneric(t *testing.T) {
+	t.Parallel()
+
+	pkg, structTypes := checkSource(t, genericsSource)
+	resp := pkg.Scope().Lookup("Resp").Type().Underlying().(*types.Struct)
+
+	var testCases = []struct {
+		testName          string
+		givenConfig       Config
+		givenField        int
+		expected          protoType
+		expectedInstances []string
+	}{
+		{
+			testName:          "name taken",
+			givenField:        0,
+			expected:          protoType{Name: "PageUser2"},
+			expectedInstances: []string{"PageUser2"},
+		},
+		{
+			testName:          "repeated argument",
+			givenField:        1,
+			expected:          protoType{Name: "PairStringInt32List"},
+			expectedInstances: []string{"PairStringInt32List"},
+		},
+		{
+			testName:          "map argument",
+			givenField:        2,
+			expected:          protoType{Name: "PairStringStringUserMap"},
+			expectedInstances: []string{"PairStringStringUserMap"},
+		},
+		{
+			testName:          "name template",
+			givenConfig:       Config{GenericNameTemplate: "{{.Name}}Of{{range .Args}}{{.}}{{end}}"},
+			givenField:        0,
+			expected:          protoType{Name: "PageOfUser"},
+			expectedInstances: []string{"PageOfUser"},
+		},
+	}
+
+	for _, testCase := range testCases {
+		resolver := newTypeResolver(testCase.givenConfig, DirectiveMap{}, structTypes, ProtoMessageMap{})
+		result, err := resolver.resolve(resp.Field(testCase.givenField).Type(), "", nestedRef{})
+		assert.NoError(t, err, testCase.testName)
+		assert.Equal(t, testCase.expected, result, testCase.testName)
+
+		var instances []string
+		for name := range resolver.Instances() {
+			instances = append(instances, name)
+		}
+		assert.Equal(t, testCase.expectedInstances, instances, testCase.testName)
+	}
+}
+
+func TestTypeResolver_ResolveGenericRecursive(t *testing.T) {
+	t.Parallel()
+
+	pkg, structTypes := checkSource(t, genericsSource)
+	resp := pkg.Scope().Lookup("Resp").Type().Underlying().(*types.Struct)
+
+	resolver := newTypeResolver(Config{}, DirectiveMap{}, structTypes, ProtoMessageMap{})
+	_, err := resolver.resolve(resp.Field(0).Type(), "", nestedRef{})
+	assert.NoError(t, err)
+
+	msg := resolver.Instances()["PageUser2"]
+	assert.Len(t, msg.Fields, 2)
+	for i, expected := range []field{
+		{Name: "items", TypeName: "User", Order: 1, IsRepeated: true, goName: "Items"},
+		{Name: "next", TypeName: "PageUser2", Order: 2, goName: "Next"},
+	} {
+		msg.Fields[i].goType = nil
+		assert.Equal(t, expected, msg.Fields[i])
+	}
+}
@@ -1,11 +1,19 @@
 module github.com/emarcey/go2proto
 
-go 1.12
+go 1.26.0
 
 require (
 	github.com/emarcey/go-string-converters v0.0.0-20200625154128-657efe3eabab
-	github.com/emicklei/proto v1.9.0
+	github.com/emicklei/proto v1.14.2
+	github.com/pmezard/go-difflib v1.0.0
 	github.com/stretchr/testify v1.5.1
-	golang.org/x/tools v0.0.0-20190319232107-3f1ed9edd1b4
-	google.golang.org/protobuf v1.22.0 // indirect
+	golang.org/x/tools v0.51.0
+	google.golang.org/protobuf v1.36.11
+	gopkg.in/yaml.v2 v2.2.2
+)
+
+require (
+	github.com/davecgh/go-spew v1.1.0 // indirect
+	golang.org/x/mod v0.41.0 // indirect
+	golang.org/x/sync v0.23.0 // indirect
 )
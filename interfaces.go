@@ -0,0 +1,86 @@
+package main
+
+import (
+	"go/types"
+	"strings"
+)
+
+const anyTypeName = "google.protobuf.Any"
+
+// interfaceType returns the proto type of a field of interface type t: a oneof
+// over the implementations of t, or google.protobuf.Any if there are none.
+func (r *typeResolver) interfaceType(t types.Type, iface *types.Interface) protoType {
+	name := ""
+	if named, ok := t.(*types.Named); ok {
+		name = named.Obj().Name()
+	}
+
+	members := r.implementations(name, iface)
+	if len(members) == 0 {
+		return protoType{Name: anyTypeName}
+	}
+	if name == "" {
+		name = strings.Join(members, "Or")
+	}
+	return protoType{Name: name, Oneof: members}
+}
+
+// implementations returns the message names of the types listed for the
+// interface in the config or, if it isn't listed, of the struct types
+// implementing it. The empty interface is never matched against struct types.
+func (r *typeResolver) implementations(name string, iface *types.Interface) []string {
+	var members []string
+	if listed, ok := r.cfg.Interfaces[name]; ok && name != "" {
+		for _, typeName := range listed {
+			members = append(members, r.memberName(typeName))
+		}
+		return members
+	}
+	if iface.Empty() {
+		return nil
+	}
+
+	for _, t := range r.structTypes {
+		if r.directives.Get(t).Ignore {
+			continue
+		}
+		if types.Implements(t.Type(), iface) || types.Implements(types.NewPointer(t.Type()), iface) {
+			members = append(members, r.directives.MessageName(t))
+		}
+	}
+	return members
+}
+
+// memberName returns the message name of the loaded struct type typeName, or
+// typeName itself if there is none.
+func (r *typeResolver) memberName(typeName string) string {
+	for _, t := range r.structTypes {
+		if t.Name() == typeName {
+			return r.directives.MessageName(t)
+		}
+	}
+	return typeName
+}
+
+// oneofMembers returns the oneof members of a field named goName, one per
+// member type, named after the field and the type.
+func (r *typeResolver) oneofMembers(goName string, memberTypes []string) []field {
+	members := make([]field, len(memberTypes))
+	for i, typeName := range memberTypes {
+		members[i] = field{
+			Name:     toProtoFieldName(goName+wrapperBaseName(typeName), r.cfg.UseSnakeFieldNames),
+			TypeName: typeName,
+		}
+	}
+	return members
+}
+
+// oneofWrapper returns the message wrapping the oneof of pt, for repeated and
+// map fields of interface type, which can't be a oneof themselves.
+func (r *typeResolver) oneofWrapper(pt protoType) string {
+	key := "oneof/" + strings.Join(pt.Oneof, ",")
+	return r.addWrapper(key, pt.Name, field{
+		Name:  "value",
+		Oneof: r.oneofMembers("", pt.Oneof),
+	})
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"go/types"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const interfacesSource = `package p
+
+type Shape interface{ Area() float64 }
+
+type Named interface{ Name() string }
+
+type Circle struct{ R float64 }
+
+func (c Circle) Area() float64 { return 3 * c.R * c.R }
+
+type Square struct{ S float64 }
+
+func (s *Square) Area() float64 { return s.S * s.S }
+
+type Label struct{ Text string }
+`
+
+func TestTypeResolver_ResolveInterface(t *testing.T) {
+	t.Parallel()
+
+	pkg, structTypes := checkSource(t, interfacesSource)
+	shape := pkg.Scope().Lookup("Shape").Type()
+	named := pkg.Scope().Lookup("Named").Type()
+
+	var testCases = []struct {
+		testName         string
+		givenConfig      Config
+		given            types.Type
+		expected         protoType
+		expectedWrappers []string
+	}{
+		{
+			testName: "implementations discovered",
+			given:    shape,
+			expected: protoType{Name: "Shape", Oneof: []string{"Circle", "Square"}},
+		},
+		{
+			testName:    "implementations listed in config",
+			givenConfig: Config{Interfaces: map[string][]string{"Shape": {"Square"}}},
+			given:       shape,
+			expected:    protoType{Name: "Shape", Oneof: []string{"Square"}},
+		},
+		{
+			testName: "no implementations",
+			given:    named,
+			expected: protoType{Name: "google.protobuf.Any"},
+		},
+		{
+			testName: "empty interface",
+			given:    types.NewInterfaceType(nil, nil),
+			expected: protoType{Name: "google.protobuf.Any"},
+		},
+		{
+			testName:         "repeated interface",
+			given:            types.NewSlice(shape),
+			expected:         protoType{Name: "Shape", Repeated: true},
+			expectedWrappers: []string{"Shape"},
+		},
+	}
+
+	for _, testCase := range testCases {
+		resolver := newTypeResolver(testCase.givenConfig, DirectiveMap{}, structTypes, ProtoMessageMap{})
+		result, err := resolver.resolve(testCase.given, "", nestedRef{})
+		assert.NoError(t, err, testCase.testName)
+		assert.Equal(t, testCase.expected, result, testCase.testName)
+
+		var wrappers []string
+		for _, msg := range resolver.Wrappers() {
+			wrappers = append(wrappers, msg.Name)
+		}
+		assert.Equal(t, testCase.expectedWrappers, wrappers, testCase.testName)
+	}
+}
+
+func TestTypeResolver_OneofMembers(t *testing.T) {
+	t.Parallel()
+
+	resolver := newTypeResolver(Config{UseSnakeFieldNames: true}, DirectiveMap{}, nil, ProtoMessageMap{})
+	result := resolver.oneofMembers("MainShape", []string{"Circle", "Square"})
+
+	assert.Equal(t, []field{
+		{Name: "main_shape_circle", TypeName: "Circle"},
+		{Name: "main_shape_square", TypeName: "Square"},
+	}, result)
+}
@@ -0,0 +1,518 @@
+package main
+
+import (
+	"encoding/json"
+	"go/types"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const jsonSchemaDialect = "https://json-schema.org/draft/2020-12/schema"
+
+// jsonSchema is a JSON Schema draft 2020-12 schema, with the keywords in the
+// order they are written.
+type jsonSchema struct {
+	Schema               string                 `json:"$schema,omitempty"`
+	Ref                  string                 `json:"$ref,omitempty"`
+	Title                string                 `json:"title,omitempty"`
+	Type                 interface{}            `json:"type,omitempty"`
+	Format               string                 `json:"format,omitempty"`
+	Pattern              string                 `json:"pattern,omitempty"`
+	ContentEncoding      string                 `json:"contentEncoding,omitempty"`
+	Const                interface{}            `json:"const,omitempty"`
+	Enum                 []interface{}          `json:"enum,omitempty"`
+	Minimum              json.Number            `json:"minimum,omitempty"`
+	Maximum              json.Number            `json:"maximum,omitempty"`
+	ExclusiveMinimum     json.Number            `json:"exclusiveMinimum,omitempty"`
+	ExclusiveMaximum     json.Number            `json:"exclusiveMaximum,omitempty"`
+	MinLength            *uint64                `json:"minLength,omitempty"`
+	MaxLength            *uint64                `json:"maxLength,omitempty"`
+	Items                *jsonSchema            `json:"items,omitempty"`
+	MinItems             *uint64                `json:"minItems,omitempty"`
+	MaxItems             *uint64                `json:"maxItems,omitempty"`
+	UniqueItems          bool                   `json:"uniqueItems,omitempty"`
+	Properties           map[string]*jsonSchema `json:"properties,omitempty"`
+	PropertyNames        *jsonSchema            `json:"propertyNames,omitempty"`
+	AdditionalProperties *jsonSchema            `json:"additionalProperties,omitempty"`
+	MinProperties        *uint64                `json:"minProperties,omitempty"`
+	MaxProperties        *uint64                `json:"maxProperties,omitempty"`
+	Required             []string               `json:"required,omitempty"`
+	OneOf                []*jsonSchema          `json:"oneOf,omitempty"`
+	AnyOf                []*jsonSchema          `json:"anyOf,omitempty"`
+	Defs                 map[string]*jsonSchema `json:"$defs,omitempty"`
+}
+
+// jsonSchemaFormats are the go-playground string rules with a JSON Schema
+// format.
+var jsonSchemaFormats = map[string]string{
+	"email":    "email",
+	"url":      "uri",
+	"uri":      "uri",
+	"uuid":     "uuid",
+	"hostname": "hostname",
+	"ipv4":     "ipv4",
+	"ipv6":     "ipv6",
+}
+
+// writeJSONSchemas writes a JSON Schema document per message generated from
+// a Go struct type to folder, named <Message>.schema.json.
+func writeJSONSchemas(msgs []message, folder string) error {
+	docs := buildJSONSchemas(msgs)
+	if len(docs) == 0 {
+		return nil
+	}
+	if err := os.MkdirAll(folder, 0755); err != nil {
+		return err
+	}
+	for _, name := range sortedKeys(docs) {
+		out, err := json.MarshalIndent(docs[name], "", "  ")
+		if err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(filepath.Join(folder, name+".schema.json"), append(out, '\n'), 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// buildJSONSchemas returns the JSON Schema documents of the top-level
+// messages generated from Go struct types, keyed by message name. A document
+// describes the encoding/json form of the struct, and refers to its message
+// in $defs along with the messages of the structs it uses.
+func buildJSONSchemas(msgs []message) map[string]*jsonSchema {
+	all := make(map[string]message)
+	var index func(msgs []message)
+	index = func(msgs []message) {
+		for _, msg := range msgs {
+			all[msg.fullName] = msg
+			index(msg.Nested)
+		}
+	}
+	index(msgs)
+	names := sortedKeys(all)
+
+	docs := make(map[string]*jsonSchema)
+	for _, msg := range msgs {
+		if msg.goType == nil || isInstance(msg.goType) {
+			continue
+		}
+		b := &jsonSchemaBuilder{messages: all, names: names, defs: make(map[string]*jsonSchema)}
+		docs[msg.Name] = &jsonSchema{
+			Schema: jsonSchemaDialect,
+			Ref:    b.ref(msg.fullName).Ref,
+			Defs:   b.defs,
+		}
+	}
+	return docs
+}
+
+// jsonSchemaBuilder builds the schemas of the messages of a document, keyed
+// by full name in defs. names are the sorted full names of messages.
+type jsonSchemaBuilder struct {
+	messages map[string]message
+	names    []string
+	defs     map[string]*jsonSchema
+}
+
+// ref returns a reference to the schema of the message fullName, adding it
+// to defs on first use.
+func (b *jsonSchemaBuilder) ref(fullName string) *jsonSchema {
+	ref := &jsonSchema{Ref: "#/$defs/" + fullName}
+	if _, ok := b.defs[fullName]; ok {
+		return ref
+	}
+	msg := b.messages[fullName]
+	schema := &jsonSchema{Title: msg.Name, Type: "object", Properties: make(map[string]*jsonSchema)}
+	// set first, so that recursive messages refer to it
+	b.defs[fullName] = schema
+	for _, f := range msg.Fields {
+		name, ok := jsonPropertyName(f)
+		if !ok || f.goType == nil {
+			continue
+		}
+		property, required := b.field(f, fullName)
+		schema.Properties[name] = property
+		if required {
+			schema.Required = append(schema.Required, name)
+		}
+	}
+	return ref
+}
+
+// jsonPropertyName returns the name encoding/json gives the Go field of f:
+// the name of its json tag, or the name of the Go field. It returns false for
+// fields left out by json:"-".
+func jsonPropertyName(f field) (string, bool) {
+	value, ok := reflect.StructTag(f.goTag).Lookup("json")
+	name := strings.Split(value, ",")[0]
+	switch {
+	case ok && value == "-":
+		return "", false
+	case name != "":
+		return name, true
+	}
+	return f.goName[strings.LastIndexByte(f.goName, '.')+1:], true
+}
+
+// jsonQuoted tells whether the json tag of f has the string option, with
+// which encoding/json writes numbers and booleans as strings.
+func jsonQuoted(f field) bool {
+	value, _ := reflect.StructTag(f.goTag).Lookup("json")
+	options := strings.Split(value, ",")[1:]
+	for _, option := range options {
+		if option == "string" {
+			return true
+		}
+	}
+	return false
+}
+
+// field returns the schema of the Go field of f, in message scope, with the
+// constraints of its validate tag, and whether it is required. Nil pointers,
+// slices and maps are null, unless the validate tag requires the field.
+func (b *jsonSchemaBuilder) field(f field, scope string) (*jsonSchema, bool) {
+	hint, _ := resolveName(scope, f.TypeName, b.defined)
+	t, nilable := f.goType, canBeNull(f.goType)
+	if p, ok := t.Underlying().(*types.Pointer); ok {
+		t = p.Elem()
+	}
+	schema := b.valueSchema(t, hint)
+
+	validated := false
+	if validate, ok := reflect.StructTag(f.goTag).Lookup(validateTagKey); ok {
+		validated = applyValidateTag(schema, validate, t)
+	}
+	if basic, ok := t.Underlying().(*types.Basic); ok && jsonQuoted(f) && basic.Info()&(types.IsBoolean|types.IsNumeric|types.IsString) != 0 {
+		schema = &jsonSchema{Type: "string"}
+	}
+	if nilable && !validated {
+		schema = nullable(schema)
+	}
+	return schema, validated || f.required
+}
+
+// defined tells whether there is a message named fullName.
+func (b *jsonSchemaBuilder) defined(fullName string) bool {
+	_, ok := b.messages[fullName]
+	return ok
+}
+
+// typeSchema returns the schema of the values of Go type t, which may be null
+// for pointers, slices and maps. hint is the message a struct is looked up in
+// first.
+func (b *jsonSchemaBuilder) typeSchema(t types.Type, hint string) *jsonSchema {
+	schema := b.valueSchema(t, hint)
+	if canBeNull(t) {
+		return nullable(schema)
+	}
+	return schema
+}
+
+// valueSchema returns the schema of the JSON encoding/json writes for the
+// non-nil values of Go type t. Structs refer to the schema of their message,
+// and interfaces are any of the messages of the structs implementing them.
+func (b *jsonSchemaBuilder) valueSchema(t types.Type, hint string) *jsonSchema {
+	switch {
+	case isTime(t):
+		return &jsonSchema{Type: "string", Format: "date-time"}
+	case hasMethod(t, "MarshalJSON"):
+		// whatever the method writes
+		return &jsonSchema{}
+	case hasMethod(t, "MarshalText"):
+		return &jsonSchema{Type: "string"}
+	case isBytes(t) && isSlice(t):
+		return &jsonSchema{Type: "string", ContentEncoding: "base64"}
+	}
+	switch u := t.Underlying().(type) {
+	case *types.Basic:
+		return jsonBasicSchema(u)
+	case *types.Pointer:
+		return b.typeSchema(u.Elem(), hint)
+	case *types.Slice:
+		return &jsonSchema{Type: "array", Items: b.typeSchema(u.Elem(), hint)}
+	case *types.Array:
+		n := uint64(u.Len())
+		return &jsonSchema{Type: "array", Items: b.typeSchema(u.Elem(), hint), MinItems: &n, MaxItems: &n}
+	case *types.Map:
+		return &jsonSchema{Type: "object", PropertyNames: jsonKeySchema(u.Key()), AdditionalProperties: b.typeSchema(u.Elem(), hint)}
+	case *types.Interface:
+		return b.interfaceSchema(u)
+	case *types.Struct:
+		if fullName, ok := b.message(t, hint); ok {
+			return b.ref(fullName)
+		}
+		return &jsonSchema{Type: "object"}
+	}
+	// funcs and channels, which encoding/json does not write
+	return &jsonSchema{}
+}
+
+// message returns the full name of the message generated from the struct
+// type t, looking at hint first.
+func (b *jsonSchemaBuilder) message(t types.Type, hint string) (string, bool) {
+	if msg, ok := b.messages[hint]; ok && msg.goType != nil && types.Identical(msg.goType, t) {
+		return hint, true
+	}
+	for _, fullName := range b.names {
+		if msg := b.messages[fullName]; msg.goType != nil && types.Identical(msg.goType, t) {
+			return fullName, true
+		}
+	}
+	return "", false
+}
+
+// interfaceSchema returns the schema of the values of the interface iface:
+// null or any of the messages of the named struct types implementing it.
+// Empty interfaces, and interfaces without such types, can be anything.
+func (b *jsonSchemaBuilder) interfaceSchema(iface *types.Interface) *jsonSchema {
+	schema := &jsonSchema{}
+	if iface.Empty() {
+		return schema
+	}
+	for _, fullName := range b.names {
+		named, ok := b.messages[fullName].goType.(*types.Named)
+		if ok && (types.Implements(named, iface) || types.Implements(types.NewPointer(named), iface)) {
+			schema.AnyOf = append(schema.AnyOf, b.ref(fullName))
+		}
+	}
+	if len(schema.AnyOf) > 0 {
+		schema.AnyOf = append(schema.AnyOf, &jsonSchema{Type: "null"})
+	}
+	return schema
+}
+
+// jsonBasicSchema returns the schema of the values of a basic Go type.
+func jsonBasicSchema(t *types.Basic) *jsonSchema {
+	info := t.Info()
+	switch {
+	case info&types.IsBoolean != 0:
+		return &jsonSchema{Type: "boolean"}
+	case info&types.IsUnsigned != 0:
+		return &jsonSchema{Type: "integer", Minimum: "0"}
+	case info&types.IsInteger != 0:
+		return &jsonSchema{Type: "integer"}
+	case info&types.IsFloat != 0:
+		return &jsonSchema{Type: "number"}
+	case info&types.IsString != 0:
+		return &jsonSchema{Type: "string"}
+	}
+	return &jsonSchema{}
+}
+
+// jsonKeySchema returns the schema of the property names of a map with keys
+// of Go type t, nil for strings. encoding/json writes integer keys in
+// decimal.
+func jsonKeySchema(t types.Type) *jsonSchema {
+	basic, ok := t.Underlying().(*types.Basic)
+	switch {
+	case !ok || basic.Info()&types.IsInteger == 0 || hasMethod(t, "MarshalText"):
+		return nil
+	case basic.Info()&types.IsUnsigned != 0:
+		return &jsonSchema{Type: "string", Pattern: "^[0-9]+$"}
+	}
+	return &jsonSchema{Type: "string", Pattern: "^-?[0-9]+$"}
+}
+
+// hasMethod tells whether t, or a pointer to it, has the method name.
+func hasMethod(t types.Type, name string) bool {
+	obj, _, _ := types.LookupFieldOrMethod(t, true, nil, name)
+	_, ok := obj.(*types.Func)
+	return ok
+}
+
+func isSlice(t types.Type) bool {
+	_, ok := t.Underlying().(*types.Slice)
+	return ok
+}
+
+// canBeNull tells whether encoding/json writes null for the nil values of t.
+func canBeNull(t types.Type) bool {
+	switch t.Underlying().(type) {
+	case *types.Pointer, *types.Slice, *types.Map:
+		return true
+	}
+	return false
+}
+
+// nullable returns schema allowing null too.
+func nullable(schema *jsonSchema) *jsonSchema {
+	if reflect.ValueOf(*schema).IsZero() {
+		// already anything
+		return schema
+	}
+	if typeName, ok := schema.Type.(string); ok && schema.Ref == "" {
+		schema.Type = []string{typeName, "null"}
+		return schema
+	}
+	if typeNames, ok := schema.Type.([]string); ok && schema.Ref == "" {
+		if typeNames[len(typeNames)-1] != "null" {
+			schema.Type = append(typeNames[:len(typeNames):len(typeNames)], "null")
+		}
+		return schema
+	}
+	return &jsonSchema{AnyOf: []*jsonSchema{schema, {Type: "null"}}}
+}
+
+// applyValidateTag adds the constraints of the rules of a go-playground
+// validate tag to the schema of a field of Go type t, and tells whether the
+// field is required. After dive, the rules apply to the items of an array or
+// the values of a map, and between keys and endkeys to the keys of the map.
+// Rules without a JSON Schema equivalent, and the rules after a dive into
+// anything else, are left out.
+func applyValidateTag(schema *jsonSchema, tag string, t types.Type) bool {
+	var key, elem types.Type
+	switch u := t.Underlying().(type) {
+	case *types.Slice:
+		elem = u.Elem()
+	case *types.Array:
+		elem = u.Elem()
+	case *types.Map:
+		key, elem = u.Key(), u.Elem()
+	}
+
+	required := false
+	target, kind := schema, t
+	dived := false
+	for _, rule := range splitValidateTag(tag) {
+		switch {
+		case rule == "dive" && !dived && schema.Items != nil:
+			target, kind, dived = schema.Items, elem, true
+			continue
+		case rule == "dive" && !dived && schema.AdditionalProperties != nil:
+			target, kind, dived = schema.AdditionalProperties, elem, true
+			continue
+		case rule == "keys" && dived && key != nil:
+			if schema.PropertyNames == nil {
+				schema.PropertyNames = &jsonSchema{Type: "string"}
+			}
+			target, kind = schema.PropertyNames, key
+			continue
+		case rule == "endkeys" && dived && key != nil:
+			target, kind = schema.AdditionalProperties, elem
+			continue
+		case rule == "required" && !dived:
+			required = true
+			continue
+		case rule == "dive" || rule == "keys" || rule == "endkeys":
+			// the rules after a dive into anything else aren't translated
+			return required
+		}
+		applyValidateRule(target, rule, kind)
+	}
+	return required
+}
+
+// applyValidateRule adds the constraint of a single go-playground rule to the
+// schema of a value of Go type t.
+func applyValidateRule(schema *jsonSchema, rule string, t types.Type) {
+	name, param, _ := strings.Cut(rule, "=")
+	if p, ok := t.Underlying().(*types.Pointer); ok {
+		t = p.Elem()
+	}
+	switch u := t.Underlying().(type) {
+	case *types.Slice, *types.Array:
+		// the length of bytes is not the length of their base64
+		if schema.Items != nil {
+			applyCountRule(name, param, &schema.MinItems, &schema.MaxItems)
+			schema.UniqueItems = schema.UniqueItems || name == "unique"
+		}
+	case *types.Map:
+		applyCountRule(name, param, &schema.MinProperties, &schema.MaxProperties)
+	case *types.Basic:
+		info := u.Info()
+		switch {
+		case info&types.IsString != 0:
+			applyStringRule(schema, name, param)
+		case info&types.IsNumeric != 0:
+			applyNumberRule(schema, name, param, info&types.IsInteger != 0, info&types.IsUnsigned != 0)
+		}
+	}
+}
+
+func applyStringRule(schema *jsonSchema, name, param string) {
+	if format, ok := jsonSchemaFormats[name]; ok && param == "" {
+		schema.Format = format
+		return
+	}
+	if pattern, ok := protovalidatePatterns[name]; ok && param == "" {
+		schema.Pattern = pattern
+		return
+	}
+	switch name {
+	case "eq":
+		schema.Const = param
+	case "oneof":
+		for _, value := range splitOneofParam(param) {
+			schema.Enum = append(schema.Enum, value)
+		}
+	case "contains":
+		schema.Pattern = regexp.QuoteMeta(param)
+	case "startswith":
+		schema.Pattern = "^" + regexp.QuoteMeta(param)
+	case "endswith":
+		schema.Pattern = regexp.QuoteMeta(param) + "$"
+	default:
+		applyCountRule(name, param, &schema.MinLength, &schema.MaxLength)
+	}
+}
+
+// applyCountRule applies the rules on the length of strings, the
+// number of items of arrays and the number of properties of maps.
+func applyCountRule(name, param string, minCount, maxCount **uint64) {
+	n, err := strconv.ParseUint(param, 10, 64)
+	if err != nil {
+		return
+	}
+	count := func(n uint64) *uint64 { return &n }
+	switch name {
+	case "min", "gte":
+		*minCount = count(n)
+	case "max", "lte":
+		*maxCount = count(n)
+	case "len":
+		*minCount, *maxCount = count(n), count(n)
+	case "gt":
+		*minCount = count(n + 1)
+	case "lt":
+		if n > 0 {
+			*maxCount = count(n - 1)
+		}
+	}
+}
+
+func applyNumberRule(schema *jsonSchema, name, param string, integer, unsigned bool) {
+	if name == "oneof" {
+		var values []interface{}
+		for _, p := range splitOneofParam(param) {
+			value, ok := numberLiteral(p, integer, unsigned)
+			if !ok {
+				return
+			}
+			values = append(values, json.Number(value))
+		}
+		schema.Enum = values
+		return
+	}
+	value, ok := numberLiteral(param, integer, unsigned)
+	if !ok {
+		return
+	}
+	switch name {
+	case "min", "gte":
+		schema.Minimum = json.Number(value)
+	case "max", "lte":
+		schema.Maximum = json.Number(value)
+	case "gt":
+		schema.ExclusiveMinimum = json.Number(value)
+	case "lt":
+		schema.ExclusiveMaximum = json.Number(value)
+	case "eq", "len":
+		schema.Const = json.Number(value)
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/tools/go/packages"
+)
+
+const jsonSchemaSource = `package p
+
+import "time"
+
+type Shape interface{ Area() float64 }
+
+type Circle struct{ R float64 }
+
+func (Circle) Area() float64 { return 0 }
+
+type Line struct {
+	SKU string ` + "`json:\"sku\"`" + `
+}
+
+type Order struct {
+	ID      string            ` + "`json:\"id\" validate:\"required,uuid\"`" + `
+	Status  string            ` + "`validate:\"oneof=new paid\"`" + `
+	Qty     uint32            ` + "`validate:\"gt=0,lte=10\"`" + `
+	Total   int64
+	Cents   int64             ` + "`json:\",string\"`" + `
+	Tags    []string          ` + "`validate:\"required,max=3,dive,min=2\"`" + `
+	Note    *string
+	Created time.Time
+	Timeout time.Duration
+	Secret  string            ` + "`json:\"-\"`" + `
+	Data    []byte
+	Code    string            ` + "`validate:\"dive,max=3\"`" + `
+	Lines   []Line
+	Grid    [][]int32
+	Counts  map[int]string
+	Parent  *Order
+	Shape   Shape
+	Extra   interface{}
+	Meta    struct{ A bool }
+}
+`
+
+func TestBuildJSONSchemas(t *testing.T) {
+	t.Parallel()
+
+	pkgs := []*packages.Package{checkPackage(t, jsonSchemaSource)}
+	currProtoMessages, err := BuildCurrentProtoMap("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	directives, err := BuildDirectiveMap(pkgs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msgs, _, err := getMessages(pkgs, Config{}, currProtoMessages, directives)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `{
+  "$schema": "https://json-schema.org/draft/2020-12/schema",
+  "$ref": "#/$defs/Order",
+  "$defs": {
+    "Circle": {"title": "Circle", "type": "object", "properties": {"R": {"type": "number"}}},
+    "Line": {"title": "Line", "type": "object", "properties": {"sku": {"type": "string"}}},
+    "Order": {
+      "title": "Order",
+      "type": "object",
+      "properties": {
+        "id": {"type": "string", "format": "uuid"},
+        "Status": {"type": "string", "enum": ["new", "paid"]},
+        "Qty": {"type": "integer", "minimum": 0, "exclusiveMinimum": 0, "maximum": 10},
+        "Total": {"type": "integer"},
+        "Cents": {"type": "string"},
+        "Tags": {"type": "array", "maxItems": 3, "items": {"type": "string", "minLength": 2}},
+        "Note": {"type": ["string", "null"]},
+        "Created": {"type": "string", "format": "date-time"},
+        "Timeout": {"type": "integer"},
+        "Data": {"type": ["string", "null"], "contentEncoding": "base64"},
+        "Code": {"type": "string"},
+        "Lines": {"type": ["array", "null"], "items": {"$ref": "#/$defs/Line"}},
+        "Grid": {"type": ["array", "null"], "items": {"type": ["array", "null"], "items": {"type": "integer"}}},
+        "Counts": {"type": ["object", "null"], "propertyNames": {"type": "string", "pattern": "^-?[0-9]+$"}, "additionalProperties": {"type": "string"}},
+        "Parent": {"anyOf": [{"$ref": "#/$defs/Order"}, {"type": "null"}]},
+        "Shape": {"anyOf": [{"$ref": "#/$defs/Circle"}, {"type": "null"}]},
+        "Extra": {},
+        "Meta": {"$ref": "#/$defs/Order.Meta"}
+      },
+      "required": ["id", "Tags"]
+    },
+    "Order.Meta": {"title": "Meta", "type": "object", "properties": {"A": {"type": "boolean"}}}
+  }
+}`
+
+	docs := buildJSONSchemas(msgs)
+	result, err := json.Marshal(docs["Order"])
+	assert.NoError(t, err)
+	assert.JSONEq(t, expected, string(result))
+	assert.Equal(t, []string{"Circle", "Line", "Order"}, sortedKeys(docs))
+}
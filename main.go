@@ -1,13 +1,16 @@
 package main
 
 import (
-	"flag"
+	"bytes"
+	"fmt"
 	"go/token"
 	"go/types"
+	"io/ioutil"
 	"log"
 	"os"
 	"path/filepath"
 	"sort"
+	"strconv"
 	"strings"
 	"text/template"
 	"unicode"
@@ -29,47 +32,99 @@ func (i *arrFlags) Set(value string) error {
 	return nil
 }
 
-var (
-	filter             = flag.String("filter", "", "Filter struct names.")
-	protoFolder        = flag.String("f", "", "Proto output path.")
-	currProtoFileName  = flag.String("c", "", "Full filepath for existing version of proto, if applicable.")
-	useSnakeFieldNames = flag.Bool("s", false, "Use to set proto structs names to snake_case instead of camelCase.")
-	pkgFlags           arrFlags
-)
-
 func main() {
-	flag.Var(&pkgFlags, "p", "Go source packages.")
-	flag.Parse()
+	os.Exit(run(os.Args[1:]))
+}
+
+// generator writes the output proto, and the files generated along with it,
+// from the loaded packages.
+type generator struct {
+	cfg         Config
+	protoFolder string
+	// currProtoFileName is the existing proto numbering the fields, if any
+	currProtoFileName string
+}
 
-	if len(pkgFlags) == 0 || protoFolder == nil {
-		flag.PrintDefaults()
-		os.Exit(1)
+func (g *generator) outFileName() string {
+	return filepath.Join(g.protoFolder, outputProtoName)
+}
+
+// generate writes the output for pkgs and returns its messages.
+func (g *generator) generate(pkgs []*packages.Package) ([]message, error) {
+	cfg, outFileName := g.cfg, g.outFileName()
+	msgs, services, src, err := g.render(pkgs)
+	if err != nil {
+		return nil, err
+	}
+	// invalid output is never written, keeping the previous file
+	if err := validateProto(src, outFileName); err != nil {
+		return nil, err
+	}
+	if err := ioutil.WriteFile(outFileName, src, 0644); err != nil {
+		return nil, err
 	}
 
-	if err := checkOutFolder(*protoFolder); err != nil {
-		log.Fatal(err)
+	if cfg.Descriptor {
+		if err := writeDescriptorSet(msgs, services, cfg.Syntax, src, filepath.Join(g.protoFolder, "output.binpb")); err != nil {
+			return nil, err
+		}
 	}
 
-	currProtoMessages, err := BuildCurrentProtoMap(*currProtoFileName)
-	if err != nil {
-		log.Fatal(err)
+	if cfg.JSONSchema {
+		if err := writeJSONSchemas(msgs, filepath.Join(g.protoFolder, "schema")); err != nil {
+			return nil, err
+		}
 	}
 
-	pwd, err := os.Getwd()
+	if cfg.Converters.ProtoImport != "" {
+		if err := writeConverters(msgs, cfg.Converters, g.protoFolder); err != nil {
+			return nil, err
+		}
+	}
+	return msgs, nil
+}
+
+// render returns the messages and services of pkgs, and the output proto
+// generate would write for them. Nothing is written.
+func (g *generator) render(pkgs []*packages.Package) ([]message, []service, []byte, error) {
+	cfg, outFileName := g.cfg, g.outFileName()
+	currProtoFileName := g.currProtoFileName
+	// when merging, the file being updated numbers the fields unless another
+	// one is given
+	if cfg.Merge && currProtoFileName == "" {
+		if _, err := os.Stat(outFileName); err == nil {
+			currProtoFileName = outFileName
+		}
+	}
+
+	currProtoMessages, err := BuildCurrentProtoMap(currProtoFileName)
 	if err != nil {
-		log.Fatal(err)
+		return nil, nil, nil, err
 	}
 
-	pkgs, err := loadPackages(pwd, pkgFlags)
+	directives, err := BuildDirectiveMap(pkgs)
 	if err != nil {
-		log.Fatal(err)
+		return nil, nil, nil, err
 	}
 
-	msgs := getMessages(pkgs, *filter, currProtoMessages, *useSnakeFieldNames)
+	msgs, services, err := getMessages(pkgs, cfg, currProtoMessages, directives)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	for _, line := range untranslatedRulesReport(msgs) {
+		log.Print(line)
+	}
 
-	if err := writeOutput(msgs, *protoFolder); err != nil {
-		log.Fatal(err)
+	var src []byte
+	if cfg.Merge {
+		src, err = mergedOutput(msgs, services, cfg.Syntax, outFileName)
+	} else {
+		src, err = renderOutput(msgs, services, cfg.Syntax)
 	}
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return msgs, services, src, nil
 }
 
 func checkOutFolder(path string) error {
@@ -77,37 +132,107 @@ func checkOutFolder(path string) error {
 	return err
 }
 
-func loadPackages(pwd string, pkgs []string) ([]*packages.Package, error) {
-	fset := token.NewFileSet()
+// loadPackages loads the packages matching patterns into fset.
+func loadPackages(pwd string, fset *token.FileSet, patterns []string) ([]*packages.Package, error) {
 	cfg := &packages.Config{
-		Dir:  pwd,
-		Mode: packages.LoadSyntax,
+		Dir: pwd,
+		// dependencies are loaded from export data, only the given packages
+		// are parsed and type-checked from source
+		Mode: packages.NeedName | packages.NeedFiles | packages.NeedCompiledGoFiles | packages.NeedTypes |
+			packages.NeedTypesSizes | packages.NeedSyntax | packages.NeedTypesInfo,
 		Fset: fset,
 	}
-	return packages.Load(cfg, pkgs...)
+	return packages.Load(cfg, patterns...)
 }
 
 type message struct {
 	Name   string
 	Fields []field
+	Nested []message
+
+	// fullName is the dot-separated path of the message, used to look up
+	// field numbers of nested messages
+	fullName string
+	// pos is the position of the Go declaration, if any
+	pos token.Pos
+	// goType is the Go struct the message is generated from, nil for
+	// wrappers and rpc messages
+	goType types.Type
 }
 
 type field struct {
+	// Label is optional or required in proto2, empty otherwise
+	Label      string
 	Name       string
 	TypeName   string
 	Order      int
 	IsRepeated bool
+	MapKey     string
 	Tags       string
 	IsEmbedded bool
+	// Options and Comment are kept from the field in the existing proto
+	Options []string
+	Comment string
+	// TagOptions are the options generated from the struct tag, by the tag
+	// option rules and the validate tag translation, rendered before Options
+	TagOptions []string
+	// presenceOptions set the field presence and default value, per syntax,
+	// and are rendered first
+	presenceOptions []string
+	// required and defaultValue are set by directives, see withPresence
+	required     bool
+	defaultValue string
+
+	// Oneof holds the members if the field is a oneof, which has no type or
+	// number of its own
+	Oneof []field
+
+	// goTag is the struct tag of the Go field
+	goTag string
+	// goName is the selector of the Go field, through the embedded structs
+	// for flattened fields; goEmbeds are the pointer embeds on the way
+	goName   string
+	goType   types.Type
+	goEmbeds []goEmbed
+	// untranslatedRules are the validate rules without protovalidate option
+	untranslatedRules []string
+}
+
+// goEmbed is an embedded pointer to a struct whose fields are flattened.
+type goEmbed struct {
+	Path string
+	Type types.Type
+}
+
+// numberField sets the number of f, or of each of its members if f is a oneof,
+// along with the options and comment of the existing field.
+func numberField(f field, msgName string, currProtoMessages ProtoMessageMap) field {
+	if len(f.Oneof) == 0 {
+		f.Order = currProtoMessages.GetFieldNum(msgName, f.Name)
+		annotations := currProtoMessages.GetFieldAnnotations(msgName, f.Name)
+		f.Options, f.Comment = annotations.Options, annotations.Comment
+		return f
+	}
+	members := make([]field, len(f.Oneof))
+	for i, member := range f.Oneof {
+		members[i] = numberField(member, msgName, currProtoMessages)
+	}
+	f.Oneof = members
+	return f
 }
 
-func getMessages(pkgs []*packages.Package, filter string, currProtoMessages ProtoMessageMap, useSnakeFieldNames bool) []message {
+// getMessages returns the messages for the struct types of pkgs and the
+// services for their interface types.
+func getMessages(pkgs []*packages.Package, cfg Config, currProtoMessages ProtoMessageMap, directives DirectiveMap) ([]message, []service, error) {
 	seen := map[string]struct{}{}
+	ignored := map[string]struct{}{}
 
-	messageMap := make(map[string]message)
+	var structTypes, ifaceTypes []types.Object
 	for _, p := range pkgs {
 		for _, t := range p.TypesInfo.Defs {
-			if t == nil {
+			// only type declarations become messages; struct-typed fields and
+			// variables are also recorded in Defs
+			if _, ok := t.(*types.TypeName); !ok {
 				continue
 			}
 			if !t.Exported() {
@@ -116,141 +241,267 @@ func getMessages(pkgs []*packages.Package, filter string, currProtoMessages Prot
 			if _, ok := seen[t.Name()]; ok {
 				continue
 			}
-			if s, ok := t.Type().Underlying().(*types.Struct); ok {
+			// generic types are generated per instantiation, see
+			// typeResolver.instance
+			if isGeneric(t.Type()) {
+				continue
+			}
+			switch t.Type().Underlying().(type) {
+			case *types.Struct:
 				seen[t.Name()] = struct{}{}
-				if filter == "" || strings.Contains(t.Name(), filter) {
-					messageMap[t.Name()] = getMessage(t, s, currProtoMessages, useSnakeFieldNames)
-				}
+				structTypes = append(structTypes, t)
+			case *types.Interface:
+				seen[t.Name()] = struct{}{}
+				ifaceTypes = append(ifaceTypes, t)
 			}
 		}
 	}
+	// Defs is a map, sort so that generated names are deterministic
+	sort.Slice(structTypes, func(i, j int) bool { return structTypes[i].Name() < structTypes[j].Name() })
+	sort.Slice(ifaceTypes, func(i, j int) bool { return ifaceTypes[i].Name() < ifaceTypes[j].Name() })
+
+	resolver := newTypeResolver(cfg, directives, structTypes, currProtoMessages)
+
+	messageMap := make(map[string]message)
+	for _, t := range structTypes {
+		name := directives.MessageName(t)
+		// ignored types are still built so that they can be embedded;
+		// types mapped onto another proto type are never emitted
+		if directive := directives.Get(t); directive.Ignore || directive.TypeName != "" {
+			ignored[name] = struct{}{}
+		}
+		if cfg.Filter == "" || strings.Contains(t.Name(), cfg.Filter) {
+			s := t.Type().Underlying().(*types.Struct)
+			msg, err := getMessage(name, name, s, cfg, currProtoMessages, resolver)
+			if err != nil {
+				return nil, nil, err
+			}
+			msg.pos = t.Pos()
+			msg.goType = t.Type()
+			messageMap[name] = msg
+		}
+	}
+
+	services, err := getServices(ifaceTypes, resolver)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for name, msg := range resolver.Instances() {
+		messageMap[name] = msg
+	}
 
 	var out []message
 
 	for _, msg := range messageMap {
-		msg.Fields = resolveEmbedded(msg.Fields, messageMap, currProtoMessages, msg.Name)
-		out = append(out, msg)
+		if _, ok := ignored[msg.Name]; ok {
+			continue
+		}
+		out = append(out, resolveEmbedded(msg, messageMap, currProtoMessages))
 	}
+	out = append(out, resolver.Wrappers()...)
+	out = append(out, resolver.Messages()...)
 
-	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
-	return out
+	sort.Slice(services, func(i, j int) bool { return services[i].Name < services[j].Name })
+	out, err = applySyntax(out, cfg.Syntax)
+	if err != nil {
+		return nil, nil, err
+	}
+	return orderMessages(out, cfg.Order, currProtoMessages, false), services, nil
 }
 
-func getMessage(t types.Object, s *types.Struct, currProtoMessages ProtoMessageMap, useSnakeFieldNames bool) message {
+// getMessage builds the message for struct s. Fields of anonymous struct type
+// get a nested message named after the Go field, made unique against the
+// top-level message names, the message's own field names and its other nested
+// messages.
+func getMessage(name, fullName string, s *types.Struct, cfg Config, currProtoMessages ProtoMessageMap, resolver *typeResolver) (message, error) {
 	msg := message{
-		Name:   t.Name(),
-		Fields: []field{},
+		Name:     name,
+		Fields:   []field{},
+		fullName: fullName,
 	}
 
+	var vars []*types.Var
+	var tags []string
+	var encodings []Encoding
 	for i := 0; i < s.NumFields(); i++ {
 		f := s.Field(i)
-		if !f.Exported() || isElasticsearchNoSource(s.Tag(i)) {
+		// fields of instantiated generic types are distinct objects, the
+		// directives are on the fields of the generic type
+		directive := resolver.directives.Get(f.Origin())
+		if !f.Exported() || directive.Ignore || cfg.Tags.skip(s.Tag(i)) {
 			continue
 		}
-		fieldName := toProtoFieldName(f.Name(), useSnakeFieldNames)
-		order := currProtoMessages.GetFieldNum(t.Name(), fieldName)
+		fieldName := directive.Name
+		if fieldName == "" {
+			fieldName = cfg.Tags.name(s.Tag(i))
+		}
+		if fieldName == "" {
+			fieldName = toProtoFieldName(f.Name(), cfg.UseSnakeFieldNames)
+		}
+		enc, err := fieldEncoding(fullName, f, s.Tag(i), directive, cfg.Encodings)
+		if err != nil {
+			return message{}, err
+		}
 		newField := field{
 			Name:       fieldName,
-			TypeName:   toProtoFieldTypeName(f),
-			IsRepeated: isRepeated(f),
-			Order:      int(order),
-			Tags:       s.Tag(i),
+			Tags:       cfg.Tags.forward(s.Tag(i)),
+			TagOptions: cfg.Tags.options(s.Tag(i)),
 			IsEmbedded: f.Embedded(),
+			goName:     f.Name(),
+			goType:     f.Type(),
+			goTag:      s.Tag(i),
+
+			required:     directive.Required,
+			defaultValue: directive.Default,
+		}
+		// a json_name option rule takes precedence over the json tag
+		if option, ok := jsonNameOption(fieldName, s.Tag(i)); ok && cfg.JSONNames && !hasOption(newField.TagOptions, "json_name") {
+			newField.TagOptions = append(newField.TagOptions, option)
 		}
 		msg.Fields = append(msg.Fields, newField)
+		vars = append(vars, f)
+		tags = append(tags, s.Tag(i))
+		encodings = append(encodings, enc)
 	}
-	return msg
-}
 
-func resolveEmbedded(msgFields []field, messageMap map[string]message, currProtoMessages ProtoMessageMap, msgName string) []field {
-	var newFields []field
-	for _, field := range msgFields {
-		if !field.IsEmbedded {
-			order := currProtoMessages.GetFieldNum(msgName, field.Name)
-			field.Order = int(order)
-			newFields = append(newFields, field)
+	taken := make(map[string]struct{}, len(resolver.messageNames)+len(msg.Fields))
+	for name := range resolver.messageNames {
+		taken[name] = struct{}{}
+	}
+	for _, field := range msg.Fields {
+		taken[field.Name] = struct{}{}
+	}
+	for i, f := range vars {
+		if directive := resolver.directives.Get(f.Origin()); directive.TypeName != "" {
+			msg.Fields[i].TypeName = directive.TypeName
+			msg.Fields[i].IsRepeated = directive.Repeated
+			msg.Fields[i] = numberField(msg.Fields[i], fullName, currProtoMessages)
+			if cfg.ValidateRules {
+				msg.Fields[i] = withValidateRules(msg.Fields[i], tags[i])
+			}
 			continue
 		}
-		currProtoMessages.RemoveFieldNum(msgName, field.Name)
-
-		embeddedMsg := messageMap[field.TypeName]
-		embeddedFields := resolveEmbedded(embeddedMsg.Fields, messageMap, currProtoMessages, embeddedMsg.Name)
 
-		for _, embeddedField := range embeddedFields {
-			order := currProtoMessages.GetFieldNum(msgName, embeddedField.Name)
-			embeddedField.Order = int(order)
-			newFields = append(newFields, embeddedField)
+		var anon nestedRef
+		s, isAnonymous := anonymousStruct(f.Type())
+		if isAnonymous {
+			nestedName := uniqueName(f.Name(), taken)
+			taken[nestedName] = struct{}{}
+			anon = nestedRef{Name: nestedName, FullName: fullName + "." + nestedName}
+			nested, err := getMessage(anon.Name, anon.FullName, s, cfg, currProtoMessages, resolver)
+			if err != nil {
+				return message{}, err
+			}
+			nested.pos = f.Pos()
+			nested.goType = s
+			msg.Nested = append(msg.Nested, nested)
 		}
 
+		pt, err := resolver.resolve(f.Type(), encodings[i], anon)
+		if err != nil {
+			return message{}, fmt.Errorf("%s.%s: %v", fullName, f.Name(), err)
+		}
+		msg.Fields[i].TypeName = pt.Name
+		msg.Fields[i].IsRepeated = pt.Repeated
+		msg.Fields[i].MapKey = pt.MapKey
+		if len(pt.Oneof) > 0 {
+			msg.Fields[i].Oneof = resolver.oneofMembers(f.Name(), pt.Oneof)
+		}
+		msg.Fields[i] = numberField(msg.Fields[i], fullName, currProtoMessages)
+		if cfg.ValidateRules && len(pt.Oneof) == 0 {
+			msg.Fields[i] = withValidateRules(msg.Fields[i], tags[i])
+		}
 	}
-	return newFields
+	return msg, nil
 }
 
-func toProtoFieldTypeName(f *types.Var) string {
-	switch f.Type().Underlying().(type) {
-	case *types.Basic:
-		name := f.Type().String()
-		return normalizeType(name)
-	case *types.Slice:
-		name := splitNameHelper(f)
-		return normalizeType(strings.TrimLeft(name, "[]"))
-
-	case *types.Pointer, *types.Struct:
-		name := splitNameHelper(f)
-		return normalizeType(name)
+// resolveEmbedded flattens the fields of embedded structs into msg and its
+// nested messages. Nested messages of an embedded struct are copied into msg,
+// renamed if their name is already used there.
+func resolveEmbedded(msg message, messageMap map[string]message, currProtoMessages ProtoMessageMap) message {
+	nested := msg.Nested
+	msg.Nested = nil
+	nestedNames := make(map[string]struct{})
+	for _, n := range nested {
+		msg.Nested = append(msg.Nested, resolveEmbedded(n, messageMap, currProtoMessages))
+		nestedNames[n.Name] = struct{}{}
 	}
-	return f.Type().String()
-}
 
-func splitNameHelper(f *types.Var) string {
-	// TODO: this is ugly. Find another way of getting field type name.
-	parts := strings.Split(f.Type().String(), ".")
+	var newFields []field
+	for _, field := range msg.Fields {
+		if !field.IsEmbedded {
+			newFields = append(newFields, numberField(field, msg.fullName, currProtoMessages))
+			continue
+		}
+		currProtoMessages.RemoveFieldNum(msg.fullName, field.Name)
 
-	name := parts[len(parts)-1]
+		embeddedMsg := resolveEmbedded(messageMap[field.TypeName], messageMap, currProtoMessages)
+
+		renamed := make(map[string]string)
+		for _, n := range embeddedMsg.Nested {
+			name := uniqueName(n.Name, nestedNames)
+			nestedNames[name] = struct{}{}
+			renamed[n.Name] = name
+			msg.Nested = append(msg.Nested, moveNested(n, name, msg.fullName+"."+name, currProtoMessages))
+		}
+
+		for _, embeddedField := range embeddedMsg.Fields {
+			if name, ok := renamed[embeddedField.TypeName]; ok {
+				embeddedField.TypeName = name
+			}
+			embeddedField.goName, embeddedField.goEmbeds = embeddedGoPath(field, embeddedField)
+			newFields = append(newFields, numberField(embeddedField, msg.fullName, currProtoMessages))
+		}
 
-	if name[0] == '*' {
-		name = name[1:]
 	}
-	return name
+	msg.Fields = newFields
+	return msg
 }
 
-func normalizeType(name string) string {
-	switch name {
-	case "int":
-		return "int64"
-	case "float32":
-		return "float"
-	case "float64":
-		return "double"
-	default:
-		return name
+// embeddedGoPath returns the Go selector of field f of the struct embedded as
+// field embedded, and the pointer embeds on the way.
+func embeddedGoPath(embedded, f field) (string, []goEmbed) {
+	var embeds []goEmbed
+	if p, ok := embedded.goType.(*types.Pointer); ok {
+		embeds = append(embeds, goEmbed{Path: embedded.goName, Type: p.Elem()})
+	}
+	for _, e := range f.goEmbeds {
+		embeds = append(embeds, goEmbed{Path: embedded.goName + "." + e.Path, Type: e.Type})
 	}
+	return embedded.goName + "." + f.goName, embeds
 }
 
-func isElasticsearchNoSource(tagString string) bool {
-	if tagString == "" {
-		return false
+// moveNested renames a nested message copied from an embedded struct and
+// numbers its fields under its new path.
+func moveNested(msg message, name, fullName string, currProtoMessages ProtoMessageMap) message {
+	msg.Name = name
+	msg.fullName = fullName
+	fields := make([]field, len(msg.Fields))
+	for i, field := range msg.Fields {
+		fields[i] = numberField(field, fullName, currProtoMessages)
 	}
-
-	tags := strings.Split(tagString, " ")
-	for _, tag := range tags {
-		tagSplit := strings.Split(tag, ":")
-		if len(tagSplit) != 2 || tagSplit[0] != "elasticsearch" {
-			continue
-		}
-		cleanTag := strings.Trim(tagSplit[1], "\"")
-		for _, val := range strings.Split(cleanTag, ",") {
-			if val == "no_source" {
-				return true
-			}
-		}
+	msg.Fields = fields
+	nested := make([]message, len(msg.Nested))
+	for i, n := range msg.Nested {
+		nested[i] = moveNested(n, n.Name, fullName+"."+n.Name, currProtoMessages)
 	}
-	return false
+	msg.Nested = nested
+	return msg
 }
 
-func isRepeated(f *types.Var) bool {
-	_, ok := f.Type().Underlying().(*types.Slice)
-	return ok
+// uniqueName returns name, or name followed by the first free numeric suffix
+// starting at 2 if name is taken.
+func uniqueName(name string, taken map[string]struct{}) string {
+	if _, ok := taken[name]; !ok {
+		return name
+	}
+	for i := 2; ; i++ {
+		candidate := name + strconv.Itoa(i)
+		if _, ok := taken[candidate]; !ok {
+			return candidate
+		}
+	}
 }
 
 func toProtoFieldName(name string, useSnakeFieldNames bool) string {
@@ -269,39 +520,204 @@ func escapeQuotes(tag string) string {
 	return strings.Replace(tag, `"`, `\"`, -1)
 }
 
+func indent(s, prefix string) string {
+	lines := strings.Split(s, "\n")
+	for i, line := range lines {
+		if line != "" {
+			lines[i] = prefix + line
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
 var FUNC_MAP = template.FuncMap{
 	"escapeQuotes": escapeQuotes,
+	"join":         strings.Join,
+	"fieldOptions": fieldOptions,
 }
 
-func writeOutput(msgs []message, path string) error {
-	msgTemplate := `syntax = "proto3";
-package proto;
-
-import "tagger/tagger.proto";
+// fieldOptions returns the options of f, the generated ones first. The options kept from the existing proto are dropped when they
+// were generated from the same tag: options of the same name, and the
+// protovalidate options when the validate tag was translated.
+func fieldOptions(f field) []string {
+	if len(f.presenceOptions) == 0 && len(f.TagOptions) == 0 && len(f.untranslatedRules) == 0 {
+		return f.Options
+	}
+	options := append(append([]string{}, f.presenceOptions...), f.TagOptions...)
+	generated := make(map[string]struct{}, len(options))
+	validated := len(f.untranslatedRules) > 0
+	for _, option := range options {
+		generated[optionName(option)] = struct{}{}
+		validated = validated || strings.HasPrefix(option, protovalidateOption)
+	}
+	for _, option := range f.Options {
+		if _, ok := generated[optionName(option)]; ok {
+			continue
+		}
+		if validated && strings.HasPrefix(option, protovalidateOption) {
+			continue
+		}
+		options = append(options, option)
+	}
+	return options
+}
 
-{{range .}}
-//easyjson:json
-message {{.Name}} {
+// outputTemplate renders the output file. Its "message", "oneof" and "field"
+// templates are also used on their own when merging into an existing file.
+func outputTemplate() *template.Template {
+	msgTemplate := `{{define "field"}}
+{{- if .IsRepeated}}repeated {{else if .Label}}{{.Label}} {{end}}
+{{- if .MapKey}}map<{{.MapKey}}, {{.TypeName}}>{{else}}{{.TypeName}}{{end}} {{.Name}} = {{.Order}}
+{{- $options := fieldOptions .}}
+{{- if or $options .Tags}} [{{join $options ", "}}{{if and $options .Tags}}, {{end}}{{if .Tags}}(tagger.tags) = "{{escapeQuotes .Tags}}"{{end}}]; {{else}};{{end}}
+{{- with .Comment}}{{if not (or $options $.Tags)}} {{end}}{{.}}{{end}}
+{{- end}}{{define "oneof"}}oneof {{.Name}} {
+{{- range .Oneof}}
+  {{template "field" .}}
+{{- end}}
+}{{end}}{{define "message"}}message {{.Name}} {
 {{- range .Fields}}
-{{- if .IsRepeated}}
-  repeated {{.TypeName}} {{.Name}} = {{if ne .Tags "" }}{{.Order}} [(tagger.tags) = "{{escapeQuotes .Tags}}"]; {{ else }}{{.Order}};{{ end }}
+{{- if .Oneof}}
+{{nested "oneof" .}}
 {{- else}}
-  {{.TypeName}} {{.Name}} = {{if ne .Tags "" }}{{.Order}} [(tagger.tags) = "{{escapeQuotes .Tags}}"]; {{ else }}{{.Order}};{{ end }}
+  {{template "field" .}}
 {{- end}}
 {{- end}}
-}
+{{- range .Nested}}
+{{nested "message" .}}
+{{- end}}
+}{{end}}{{define "rpc"}}rpc {{.Name}}({{if .ClientStreaming}}stream {{end}}{{.Request}}) returns ({{if .ServerStreaming}}stream {{end}}{{.Response}});
+{{- end}}{{define "service"}}service {{.Name}} {
+{{- range .Methods}}
+  {{template "rpc" .}}
+{{- end}}
+}{{end}}{{.Syntax.Statement}}
+package proto;
+{{range .Imports}}
+import "{{.}}";
+{{- end}}
+{{- with .Syntax.FileOptions}}
+{{range .}}
+option {{.}};
+{{- end}}
+{{- end}}
+
+{{range .Messages}}
+//easyjson:json
+{{template "message" .}}
+{{end}}
+{{- range .Services}}
+{{template "service" .}}
 {{end}}
 `
-	tmpl, err := template.New("test").Funcs(FUNC_MAP).Parse(msgTemplate)
-	if err != nil {
+	tmpl := template.New("test").Funcs(FUNC_MAP)
+	// nested messages and oneofs are rendered with the same template and
+	// indented
+	tmpl.Funcs(template.FuncMap{
+		"nested": func(name string, data interface{}) (string, error) {
+			var buf bytes.Buffer
+			if err := tmpl.ExecuteTemplate(&buf, name, data); err != nil {
+				return "", err
+			}
+			return indent(buf.String(), "  "), nil
+		},
+	})
+	if _, err := tmpl.Parse(msgTemplate); err != nil {
 		panic(err)
 	}
+	return tmpl
+}
 
-	f, err := os.Create(filepath.Join(path, "output.proto"))
+// renderOutput executes the output template for msgs and services.
+func renderOutput(msgs []message, services []service, syntax Syntax) ([]byte, error) {
+	var buf bytes.Buffer
+	err := outputTemplate().Execute(&buf, outputData{
+		Syntax:   syntax,
+		Imports:  getImports(msgs, services),
+		Messages: msgs,
+		Services: services,
+	})
 	if err != nil {
-		return err
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// outputData is the data of the output template.
+type outputData struct {
+	Syntax   Syntax
+	Imports  []string
+	Messages []message
+	Services []service
+}
+
+// wellKnownImports maps the well-known types that can be generated to the file
+// defining them.
+var wellKnownImports = map[string]string{
+	"google.protobuf.Any":         "google/protobuf/any.proto",
+	"google.protobuf.Duration":    "google/protobuf/duration.proto",
+	"google.protobuf.Empty":       "google/protobuf/empty.proto",
+	"google.protobuf.FieldMask":   "google/protobuf/field_mask.proto",
+	"google.protobuf.ListValue":   "google/protobuf/struct.proto",
+	"google.protobuf.Struct":      "google/protobuf/struct.proto",
+	"google.protobuf.Value":       "google/protobuf/struct.proto",
+	"google.protobuf.Timestamp":   "google/protobuf/timestamp.proto",
+	"google.protobuf.DoubleValue": "google/protobuf/wrappers.proto",
+	"google.protobuf.FloatValue":  "google/protobuf/wrappers.proto",
+	"google.protobuf.Int64Value":  "google/protobuf/wrappers.proto",
+	"google.protobuf.UInt64Value": "google/protobuf/wrappers.proto",
+	"google.protobuf.Int32Value":  "google/protobuf/wrappers.proto",
+	"google.protobuf.UInt32Value": "google/protobuf/wrappers.proto",
+	"google.protobuf.BoolValue":   "google/protobuf/wrappers.proto",
+	"google.protobuf.StringValue": "google/protobuf/wrappers.proto",
+	"google.protobuf.BytesValue":  "google/protobuf/wrappers.proto",
+}
+
+// getImports returns the files to import for msgs and services, sorted.
+func getImports(msgs []message, services []service) []string {
+	imports := make(map[string]struct{})
+	var addFields func(fields []field)
+	addFields = func(fields []field) {
+		for _, f := range fields {
+			if file, ok := wellKnownImports[f.TypeName]; ok {
+				imports[file] = struct{}{}
+			}
+			if f.Tags != "" {
+				imports[taggerImport] = struct{}{}
+			}
+			for _, option := range fieldOptions(f) {
+				switch {
+				case strings.HasPrefix(option, protovalidateOption):
+					imports[protovalidateImport] = struct{}{}
+				case strings.HasPrefix(option, taggerOption):
+					imports[taggerImport] = struct{}{}
+				}
+			}
+			addFields(f.Oneof)
+		}
+	}
+	var addMessages func(msgs []message)
+	addMessages = func(msgs []message) {
+		for _, msg := range msgs {
+			addFields(msg.Fields)
+			addMessages(msg.Nested)
+		}
+	}
+	addMessages(msgs)
+	for _, svc := range services {
+		for _, method := range svc.Methods {
+			for _, typeName := range []string{method.Request, method.Response} {
+				if file, ok := wellKnownImports[typeName]; ok {
+					imports[file] = struct{}{}
+				}
+			}
+		}
 	}
-	defer f.Close()
 
-	return tmpl.Execute(f, msgs)
+	out := make([]string, 0, len(imports))
+	for file := range imports {
+		out = append(out, file)
+	}
+	sort.Strings(out)
+	return out
 }
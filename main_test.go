@@ -0,0 +1,327 @@
+package main
+
+import (
+	"go/ast"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/tools/go/packages"
+)
+
+func TestUniqueName(t *testing.T) {
+	t.Parallel()
+
+	var testCases = []struct {
+		testName   string
+		givenName  string
+		givenTaken map[string]struct{}
+		expected   string
+	}{
+		{
+			testName:   "name free",
+			givenName:  "Meta",
+			givenTaken: map[string]struct{}{"User": {}},
+			expected:   "Meta",
+		},
+		{
+			testName:   "name taken",
+			givenName:  "Meta",
+			givenTaken: map[string]struct{}{"Meta": {}},
+			expected:   "Meta2",
+		},
+		{
+			testName:   "name and first suffix taken",
+			givenName:  "Meta",
+			givenTaken: map[string]struct{}{"Meta": {}, "Meta2": {}},
+			expected:   "Meta3",
+		},
+	}
+
+	for _, testCase := range testCases {
+		result := uniqueName(testCase.givenName, testCase.givenTaken)
+		assert.Equal(t, testCase.expected, result, testCase.testName)
+	}
+}
+
+func TestOutputTemplate_Field(t *testing.T) {
+	t.Parallel()
+
+	var testCases = []struct {
+		testName string
+		given    field
+		expected string
+	}{
+		{
+			testName: "plain",
+			given:    field{Name: "id", TypeName: "string", Order: 1},
+			expected: "string id = 1;",
+		},
+		{
+			testName: "tags",
+			given:    field{Name: "id", TypeName: "string", Order: 1, Tags: `json:"id"`},
+			expected: `string id = 1 [(tagger.tags) = "json:\"id\""]; `,
+		},
+		{
+			testName: "options and comment",
+			given:    field{Name: "id", TypeName: "string", Order: 1, Options: []string{"deprecated = true", `json_name = "ID"`}, Comment: "// old"},
+			expected: `string id = 1 [deprecated = true, json_name = "ID"]; // old`,
+		},
+		{
+			testName: "options and tags",
+			given:    field{Name: "id", TypeName: "string", Order: 1, Options: []string{"deprecated = true"}, Tags: `json:"id"`},
+			expected: `string id = 1 [deprecated = true, (tagger.tags) = "json:\"id\""]; `,
+		},
+		{
+			testName: "tag options replace options of the same name",
+			given:    field{Name: "id", TypeName: "string", Order: 1, TagOptions: []string{`json_name = "ID"`}, Options: []string{`json_name = "old"`, "deprecated = true"}, Tags: `json:"ID"`},
+			expected: `string id = 1 [json_name = "ID", deprecated = true, (tagger.tags) = "json:\"ID\""]; `,
+		},
+		{
+			testName: "comment",
+			given:    field{Name: "ids", TypeName: "string", Order: 1, IsRepeated: true, Comment: "/* ids */"},
+			expected: "repeated string ids = 1; /* ids */",
+		},
+	}
+
+	tmpl := outputTemplate()
+	for _, testCase := range testCases {
+		var result strings.Builder
+		err := tmpl.ExecuteTemplate(&result, "field", testCase.given)
+		assert.NoError(t, err, testCase.testName)
+		assert.Equal(t, testCase.expected, result.String(), testCase.testName)
+	}
+}
+
+func TestGetImports(t *testing.T) {
+	t.Parallel()
+
+	var testCases = []struct {
+		testName string
+		given    []field
+		expected []string
+	}{
+		{
+			testName: "untagged fields",
+			given:    []field{{Name: "id", TypeName: "string"}},
+			expected: []string{},
+		},
+		{
+			testName: "forwarded tags",
+			given:    []field{{Name: "id", TypeName: "string", Tags: `json:"id"`}},
+			expected: []string{taggerImport},
+		},
+		{
+			testName: "tags kept from the existing proto",
+			given:    []field{{Name: "id", TypeName: "string", Options: []string{`(tagger.tags) = "json:\"id\""`}}},
+			expected: []string{taggerImport},
+		},
+		{
+			testName: "oneof members",
+			given: []field{{Name: "main", Oneof: []field{
+				{Name: "mainAt", TypeName: "google.protobuf.Timestamp"},
+				{Name: "mainID", TypeName: "string", TagOptions: []string{"(buf.validate.field).string.uuid = true"}},
+			}}},
+			expected: []string{protovalidateImport, "google/protobuf/timestamp.proto"},
+		},
+	}
+
+	for _, testCase := range testCases {
+		msgs := []message{{Name: "Order", Fields: testCase.given}}
+		assert.Equal(t, testCase.expected, getImports(msgs, nil), testCase.testName)
+	}
+}
+
+func TestGetMessage_UnsupportedTypes(t *testing.T) {
+	t.Parallel()
+
+	_, structTypes := checkSource(t, `package p
+
+type Signal struct {
+	Name  string
+	Phase complex64
+}
+
+type Spectrum struct {
+	Bins map[string]complex128
+}
+
+type Handler func() error
+
+type Worker struct {
+	ID string
+	Fn func()
+}
+
+type Queue struct {
+	Done chan struct{}
+}
+
+type Router struct {
+	OnErr Handler
+}
+`)
+
+	var testCases = []struct {
+		testName      string
+		givenStruct   int
+		expectedError string
+	}{
+		{
+			testName:      "complex64",
+			givenStruct:   2,
+			expectedError: "Signal.Phase: unsupported type complex64, proto has no scalar for it",
+		},
+		{
+			testName:      "map of complex128",
+			givenStruct:   3,
+			expectedError: "Spectrum.Bins: unsupported type complex128, proto has no scalar for it",
+		},
+		{
+			testName:      "func",
+			givenStruct:   4,
+			expectedError: "Worker.Fn: unsupported type func()",
+		},
+		{
+			testName:      "chan",
+			givenStruct:   0,
+			expectedError: "Queue.Done: unsupported type chan struct{}",
+		},
+		{
+			testName:      "named func",
+			givenStruct:   1,
+			expectedError: "Router.OnErr: unsupported type p.Handler",
+		},
+	}
+
+	for _, testCase := range testCases {
+		obj := structTypes[testCase.givenStruct]
+		resolver := newTypeResolver(Config{}, DirectiveMap{}, structTypes, ProtoMessageMap{})
+		_, err := getMessage(obj.Name(), obj.Name(), obj.Type().Underlying().(*types.Struct), Config{}, ProtoMessageMap{}, resolver)
+		assert.EqualError(t, err, testCase.expectedError, testCase.testName)
+	}
+}
+
+func TestGetMessages_TypeDirectives(t *testing.T) {
+	t.Parallel()
+
+	pkgs := []*packages.Package{checkPackage(t, `package p
+
+type Upload struct {
+	//go2proto:type=bytes
+	Lines []string
+	//go2proto:type=repeated bytes
+	Chunks [][]byte
+	//go2proto:type=string
+	Raw []byte
+}
+`)}
+	directives, err := BuildDirectiveMap(pkgs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msgs, _, err := getMessages(pkgs, Config{}, ProtoMessageMap{}, directives)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var testCases = []struct {
+		testName         string
+		givenField       int
+		expectedType     string
+		expectedRepeated bool
+	}{
+		{
+			testName:     "slice made singular",
+			givenField:   0,
+			expectedType: "bytes",
+		},
+		{
+			testName:         "repeated type",
+			givenField:       1,
+			expectedType:     "bytes",
+			expectedRepeated: true,
+		},
+		{
+			testName:     "bytes",
+			givenField:   2,
+			expectedType: "string",
+		},
+	}
+
+	for _, testCase := range testCases {
+		f := msgs[0].Fields[testCase.givenField]
+		assert.Equal(t, testCase.expectedType, f.TypeName, testCase.testName)
+		assert.Equal(t, testCase.expectedRepeated, f.IsRepeated, testCase.testName)
+	}
+}
+
+func TestGenerator_InvalidOutputNotWritten(t *testing.T) {
+	t.Parallel()
+
+	pkg := checkPackage(t, `package p
+
+type Order struct {
+	ID string
+	//go2proto:type=Missing
+	Ref string
+}
+`)
+	dir := t.TempDir()
+	outFileName := filepath.Join(dir, outputProtoName)
+	previous := []byte("syntax = \"proto3\";\n")
+	if err := os.WriteFile(outFileName, previous, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	g := &generator{protoFolder: dir}
+	_, err := g.generate([]*packages.Package{pkg})
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "undefined type Missing")
+	}
+
+	result, err := os.ReadFile(outFileName)
+	assert.NoError(t, err)
+	assert.Equal(t, previous, result)
+}
+
+// checkSource type-checks src as package p and returns the exported struct
+// types declared in it sorted by name, as getMessages collects them.
+func checkSource(t *testing.T, src string) (*types.Package, []types.Object) {
+	t.Helper()
+
+	pkg := checkPackage(t, src)
+	var structTypes []types.Object
+	for _, name := range pkg.Types.Scope().Names() {
+		obj := pkg.Types.Scope().Lookup(name)
+		if _, ok := obj.Type().Underlying().(*types.Struct); ok && obj.Exported() {
+			structTypes = append(structTypes, obj)
+		}
+	}
+	return pkg.Types, structTypes
+}
+
+// checkPackage type-checks src as package p, with the syntax and type info
+// loadPackages loads.
+func checkPackage(t *testing.T, src string) *packages.Package {
+	t.Helper()
+
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "p.go", src, parser.ParseComments)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info := &types.Info{Defs: make(map[*ast.Ident]types.Object)}
+	conf := types.Config{Importer: importer.ForCompiler(fset, "source", nil)}
+	pkg, err := conf.Check("p", fset, []*ast.File{file}, info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &packages.Package{Fset: fset, Syntax: []*ast.File{file}, Types: pkg, TypesInfo: info}
+}
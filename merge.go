@@ -0,0 +1,576 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/emicklei/proto"
+)
+
+// mergedOutput returns the proto file filename with its generated messages
+// updated to msgs, keeping everything else as it is: services, options,
+// comments, hand-written messages and the order of the file. If the file
+// doesn't exist yet, the output is rendered as usual.
+func mergedOutput(msgs []message, services []service, syntax Syntax, filename string) ([]byte, error) {
+	src, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return renderOutput(msgs, services, syntax)
+	}
+	if err != nil {
+		return nil, err
+	}
+	out, err := mergeProto(src, msgs, services, syntax, outputTemplate())
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", filename, err)
+	}
+	return out, nil
+}
+
+// mergeProto merges msgs into the proto source src. Messages of src with the
+// name of a generated message have their fields replaced in place, new fields
+// appended and fields no longer generated reserved, and the names and numbers
+// of generated fields unreserved. Generated messages not in src are appended
+// to it, and missing imports added after the existing ones.
+// Services are merged the same way, rpcs that aren't generated are kept.
+func mergeProto(src []byte, msgs []message, services []service, syntax Syntax, tmpl *template.Template) ([]byte, error) {
+	definition, err := proto.NewParser(bytes.NewReader(src)).Parse()
+	if err != nil {
+		return nil, err
+	}
+
+	m := &merger{protoSource: src, tmpl: tmpl}
+	generated := make(map[string]message, len(msgs))
+	for _, msg := range msgs {
+		generated[msg.Name] = msg
+	}
+	generatedServices := make(map[string]service, len(services))
+	for _, svc := range services {
+		generatedServices[svc.Name] = svc
+	}
+	merged := make(map[string]struct{})
+	mergedServices := make(map[string]struct{})
+	imported := make(map[string]struct{})
+	fileOptions := make(map[string]struct{})
+	headerEnd := -1
+
+	for _, element := range definition.Elements {
+		switch e := element.(type) {
+		case *proto.Syntax:
+			headerEnd = m.mergeSyntax(e.Position.Offset, Syntax(e.Value), syntax)
+		case *proto.Edition:
+			headerEnd = m.mergeSyntax(e.Position.Offset, Syntax(e.Value), syntax)
+		case *proto.Option:
+			fileOptions[e.Name] = struct{}{}
+		case *proto.Package:
+			headerEnd = m.statementEnd(e.Position.Offset)
+		case *proto.Import:
+			headerEnd = m.statementEnd(e.Position.Offset)
+			imported[e.Filename] = struct{}{}
+		case *proto.Message:
+			msg, ok := generated[e.Name]
+			if !ok {
+				continue
+			}
+			merged[e.Name] = struct{}{}
+			if err := m.mergeMessage(e, msg); err != nil {
+				return nil, err
+			}
+		case *proto.Service:
+			svc, ok := generatedServices[e.Name]
+			if !ok {
+				continue
+			}
+			mergedServices[e.Name] = struct{}{}
+			if err := m.mergeService(e, svc); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	var imports strings.Builder
+	for _, file := range getImports(msgs, services) {
+		if _, ok := imported[file]; !ok {
+			fmt.Fprintf(&imports, "\nimport %q;", file)
+		}
+	}
+	for _, option := range syntax.FileOptions() {
+		if _, ok := fileOptions[optionName(option)]; !ok {
+			fmt.Fprintf(&imports, "\noption %s;", option)
+		}
+	}
+	if imports.Len() > 0 {
+		if headerEnd < 0 {
+			m.insert(0, strings.TrimPrefix(imports.String(), "\n")+"\n")
+		} else {
+			m.insert(headerEnd, imports.String())
+		}
+	}
+
+	var added strings.Builder
+	if len(src) > 0 && src[len(src)-1] != '\n' {
+		added.WriteString("\n")
+	}
+	for _, msg := range msgs {
+		if _, ok := merged[msg.Name]; ok {
+			continue
+		}
+		text, err := m.render("message", msg, "")
+		if err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(&added, "\n//easyjson:json\n%s\n", text)
+	}
+	for _, svc := range services {
+		if _, ok := mergedServices[svc.Name]; ok {
+			continue
+		}
+		text, err := m.render("service", svc, "")
+		if err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(&added, "\n%s\n", text)
+	}
+	if added.Len() > 0 {
+		m.insert(len(src), added.String())
+	}
+
+	return m.apply(), nil
+}
+
+type edit struct {
+	start, end int
+	text       string
+}
+
+// merger collects the edits merging generated messages into src, which are
+// applied at once so that the offsets of the parsed proto stay valid.
+type merger struct {
+	protoSource
+	tmpl  *template.Template
+	edits []edit
+}
+
+func (m *merger) mergeMessage(existing *proto.Message, msg message) error {
+	fields := make(map[string]field, len(msg.Fields))
+	used := make(map[string]struct{})
+	for _, f := range msg.Fields {
+		fields[f.Name] = f
+		used[f.Name] = struct{}{}
+		used[strconv.Itoa(f.Order)] = struct{}{}
+		for _, member := range f.Oneof {
+			used[member.Name] = struct{}{}
+			used[strconv.Itoa(member.Order)] = struct{}{}
+		}
+	}
+	nested := make(map[string]message, len(msg.Nested))
+	for _, n := range msg.Nested {
+		nested[n.Name] = n
+	}
+	seenFields := make(map[string]struct{})
+	seenNested := make(map[string]struct{})
+
+	for _, element := range existing.Elements {
+		switch e := element.(type) {
+		case *proto.NormalField:
+			if err := m.mergeField(e.Field, fields, used, seenFields); err != nil {
+				return err
+			}
+		case *proto.MapField:
+			if err := m.mergeField(e.Field, fields, used, seenFields); err != nil {
+				return err
+			}
+		case *proto.Oneof:
+			if err := m.mergeOneof(e, fields, used, seenFields); err != nil {
+				return err
+			}
+		case *proto.Reserved:
+			m.mergeReserved(e, used)
+		case *proto.Message:
+			// nested messages not generated may be hand-written, they are kept
+			n, ok := nested[e.Name]
+			if !ok {
+				continue
+			}
+			seenNested[e.Name] = struct{}{}
+			if err := m.mergeMessage(e, n); err != nil {
+				return err
+			}
+		}
+	}
+
+	prefix := m.lineIndent(existing.Position.Offset) + "  "
+	var lines []string
+	for _, f := range msg.Fields {
+		if _, ok := seenFields[f.Name]; ok {
+			continue
+		}
+		text, err := m.renderField(f, prefix)
+		if err != nil {
+			return err
+		}
+		lines = append(lines, prefix+text)
+	}
+	for _, n := range msg.Nested {
+		if _, ok := seenNested[n.Name]; ok {
+			continue
+		}
+		text, err := m.render("message", n, prefix)
+		if err != nil {
+			return err
+		}
+		lines = append(lines, prefix+text)
+	}
+	m.appendToBlock(existing.Position.Offset, lines)
+	return nil
+}
+
+// mergeService replaces the rpcs of existing service with the generated rpcs
+// of the same name, keeping their options, and appends the new ones.
+func (m *merger) mergeService(existing *proto.Service, svc service) error {
+	methods := make(map[string]rpc, len(svc.Methods))
+	for _, method := range svc.Methods {
+		methods[method.Name] = method
+	}
+	seen := make(map[string]struct{})
+
+	for _, element := range existing.Elements {
+		e, ok := element.(*proto.RPC)
+		if !ok {
+			continue
+		}
+		method, ok := methods[e.Name]
+		if !ok {
+			continue
+		}
+		seen[e.Name] = struct{}{}
+		text, err := m.render("rpc", method, "")
+		if err != nil {
+			return err
+		}
+		start := m.statementStart(e.Position.Offset)
+		end := m.scan(start, func(c byte, depth int) bool { return (c == ';' || c == '{') && depth == 0 })
+		if end < len(m.protoSource) && m.protoSource[end] == ';' {
+			end++
+		} else {
+			// the body holding the options is kept
+			for end > start && (m.protoSource[end-1] == ' ' || m.protoSource[end-1] == '\t') {
+				end--
+			}
+			text = strings.TrimSuffix(text, ";")
+		}
+		m.replace(start, end, []string{text}, "")
+	}
+
+	prefix := m.lineIndent(existing.Position.Offset) + "  "
+	var lines []string
+	for _, method := range svc.Methods {
+		if _, ok := seen[method.Name]; ok {
+			continue
+		}
+		text, err := m.render("rpc", method, prefix)
+		if err != nil {
+			return err
+		}
+		lines = append(lines, prefix+text)
+	}
+	m.appendToBlock(existing.Position.Offset, lines)
+	return nil
+}
+
+// appendToBlock adds lines at the end of the block of the definition at
+// offset.
+func (m *merger) appendToBlock(offset int, lines []string) {
+	if len(lines) == 0 {
+		return
+	}
+	end := m.blockEnd(offset)
+	if start := m.lineStart(end); strings.TrimSpace(string(m.protoSource[start:end])) == "" {
+		m.insert(start, strings.Join(lines, "\n")+"\n")
+	} else {
+		m.insert(end, "\n"+strings.Join(lines, "\n")+"\n"+m.lineIndent(offset))
+	}
+}
+
+// mergeField replaces existing field f with the generated field of the same
+// name, or with reserved statements if there is none.
+func (m *merger) mergeField(f *proto.Field, fields map[string]field, used, seen map[string]struct{}) error {
+	start := m.statementStart(f.Position.Offset)
+	end := m.statementEnd(f.Position.Offset)
+	prefix := m.lineIndent(f.Position.Offset)
+
+	if generated, ok := fields[f.Name]; ok && len(generated.Oneof) == 0 {
+		seen[f.Name] = struct{}{}
+		// the inline comment is rendered with the field if it is kept
+		if f.InlineComment != nil && generated.Comment != "" {
+			end = m.lineEnd(end)
+		}
+		text, err := m.renderField(generated, prefix)
+		if err != nil {
+			return err
+		}
+		m.replace(start, end, []string{text}, prefix)
+		return nil
+	}
+	m.replace(start, end, reserved(f, used), prefix)
+	return nil
+}
+
+// mergeOneof replaces existing oneof o with the generated oneof of the same
+// name, reserving the members that are no longer generated.
+func (m *merger) mergeOneof(o *proto.Oneof, fields map[string]field, used, seen map[string]struct{}) error {
+	start := m.statementStart(o.Position.Offset)
+	end := m.blockEnd(o.Position.Offset) + 1
+	prefix := m.lineIndent(o.Position.Offset)
+
+	var lines []string
+	if generated, ok := fields[o.Name]; ok && len(generated.Oneof) > 0 {
+		seen[o.Name] = struct{}{}
+		text, err := m.render("oneof", generated, prefix)
+		if err != nil {
+			return err
+		}
+		lines = append(lines, text)
+	}
+	for _, element := range o.Elements {
+		if member, ok := element.(*proto.OneOfField); ok {
+			lines = append(lines, reserved(member.Field, used)...)
+		}
+	}
+	m.replace(start, end, lines, prefix)
+	return nil
+}
+
+// reserved returns the statements reserving the number and name of removed
+// field f, unless they are used by a generated field.
+func reserved(f *proto.Field, used map[string]struct{}) []string {
+	var lines []string
+	if _, ok := used[strconv.Itoa(f.Sequence)]; !ok {
+		lines = append(lines, fmt.Sprintf("reserved %d;", f.Sequence))
+	}
+	if _, ok := used[f.Name]; !ok {
+		lines = append(lines, fmt.Sprintf("reserved %q;", f.Name))
+	}
+	return lines
+}
+
+// mergeReserved removes the names and numbers used by generated fields from
+// reserved statement r, so that a removed field can be added back.
+func (m *merger) mergeReserved(r *proto.Reserved, used map[string]struct{}) {
+	var values []string
+	changed := false
+	for _, rng := range r.Ranges {
+		kept := unusedRanges(rng, used)
+		if len(kept) != 1 || kept[0] != rng {
+			changed = true
+		}
+		for _, k := range kept {
+			values = append(values, k.SourceRepresentation())
+		}
+	}
+	for _, name := range r.FieldNames {
+		if _, ok := used[name]; ok {
+			changed = true
+			continue
+		}
+		values = append(values, strconv.Quote(name))
+	}
+	if !changed {
+		return
+	}
+
+	var lines []string
+	if len(values) > 0 {
+		lines = append(lines, "reserved "+strings.Join(values, ", ")+";")
+	}
+	m.replace(m.statementStart(r.Position.Offset), m.statementEnd(r.Position.Offset), lines, m.lineIndent(r.Position.Offset))
+}
+
+// unusedRanges returns the parts of reserved range r without the numbers used
+// by generated fields.
+func unusedRanges(r proto.Range, used map[string]struct{}) []proto.Range {
+	var numbers []int
+	for key := range used {
+		if n, err := strconv.Atoi(key); err == nil && n >= r.From && (r.Max || n <= r.To) {
+			numbers = append(numbers, n)
+		}
+	}
+	sort.Ints(numbers)
+
+	var ranges []proto.Range
+	from := r.From
+	for _, n := range numbers {
+		if n > from {
+			ranges = append(ranges, proto.Range{From: from, To: n - 1})
+		}
+		from = n + 1
+	}
+	if r.Max || from <= r.To {
+		ranges = append(ranges, proto.Range{From: from, To: r.To, Max: r.Max})
+	}
+	return ranges
+}
+
+// mergeSyntax replaces the syntax or edition statement at offset, declaring
+// current, if it isn't the one of syntax, and returns the end of the
+// statement.
+func (m *merger) mergeSyntax(offset int, current, syntax Syntax) int {
+	end := m.statementEnd(offset)
+	if current.Statement() != syntax.Statement() {
+		m.replace(offset, end, []string{syntax.Statement()}, "")
+	}
+	return end
+}
+
+func (m *merger) renderField(f field, prefix string) (string, error) {
+	if len(f.Oneof) > 0 {
+		return m.render("oneof", f, prefix)
+	}
+	return m.render("field", f, prefix)
+}
+
+// render executes the named template, indenting all lines but the first with
+// prefix.
+func (m *merger) render(name string, data interface{}, prefix string) (string, error) {
+	var buf bytes.Buffer
+	if err := m.tmpl.ExecuteTemplate(&buf, name, data); err != nil {
+		return "", err
+	}
+	text := strings.TrimRight(buf.String(), " ")
+	return strings.TrimPrefix(indent(text, prefix), prefix), nil
+}
+
+// replace replaces src[start:end] with lines, one per line. Without lines, the
+// line of the replaced text is removed if nothing else is on it.
+func (m *merger) replace(start, end int, lines []string, prefix string) {
+	if len(lines) > 0 {
+		m.edits = append(m.edits, edit{start: start, end: end, text: strings.Join(lines, "\n"+prefix)})
+		return
+	}
+	lineStart, lineEnd := m.lineStart(start), m.lineEnd(end)
+	if lineEnd < len(m.protoSource) && strings.TrimSpace(string(m.protoSource[lineStart:start])+string(m.protoSource[end:lineEnd])) == "" {
+		start, end = lineStart, lineEnd+1
+	}
+	m.edits = append(m.edits, edit{start: start, end: end})
+}
+
+func (m *merger) insert(offset int, text string) {
+	m.edits = append(m.edits, edit{start: offset, end: offset, text: text})
+}
+
+// apply returns src with the edits applied. Edits don't overlap; insertions at
+// the same offset keep the order in which they were added.
+func (m *merger) apply() []byte {
+	edits := make([]edit, len(m.edits))
+	copy(edits, m.edits)
+	sort.SliceStable(edits, func(i, j int) bool { return edits[i].start < edits[j].start })
+
+	var out bytes.Buffer
+	offset := 0
+	for _, e := range edits {
+		out.Write(m.protoSource[offset:e.start])
+		out.WriteString(e.text)
+		offset = e.end
+	}
+	out.Write(m.protoSource[offset:])
+	return out.Bytes()
+}
+
+// protoSource is the text of a proto file, located by the offsets of the
+// parsed elements.
+type protoSource []byte
+
+func (s protoSource) lineStart(offset int) int {
+	return bytes.LastIndexByte(s[:offset], '\n') + 1
+}
+
+// lineEnd returns the offset of the end of the line at offset.
+func (s protoSource) lineEnd(offset int) int {
+	if end := bytes.IndexByte(s[offset:], '\n'); end >= 0 {
+		return offset + end
+	}
+	return len(s)
+}
+
+// lineIndent returns the leading whitespace of the line at offset.
+func (s protoSource) lineIndent(offset int) string {
+	start := s.lineStart(offset)
+	end := start
+	for end < len(s) && (s[end] == ' ' || s[end] == '\t') {
+		end++
+	}
+	return string(s[start:end])
+}
+
+// statementStart returns the start of the statement at offset. The parser
+// reports the position of a field after its label, e.g. repeated.
+func (s protoSource) statementStart(offset int) int {
+	start := s.lineStart(offset) + len(s.lineIndent(offset))
+	if start > offset {
+		return offset
+	}
+	return start
+}
+
+// statementEnd returns the offset after the semicolon ending the statement at
+// offset.
+func (s protoSource) statementEnd(offset int) int {
+	return s.scan(offset, func(c byte, depth int) bool { return c == ';' && depth == 0 }) + 1
+}
+
+// blockEnd returns the offset of the brace closing the block of the
+// definition at offset.
+func (s protoSource) blockEnd(offset int) int {
+	opened := false
+	return s.scan(offset, func(c byte, depth int) bool {
+		if c == '{' {
+			opened = true
+		}
+		return opened && c == '}' && depth == 0
+	})
+}
+
+// scan returns the offset of the first character from offset for which stop
+// returns true, skipping strings and comments. depth is the nesting of
+// brackets, braces and parentheses after an opening and before a closing one.
+func (s protoSource) scan(offset int, stop func(c byte, depth int) bool) int {
+	depth := 0
+	for i := offset; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '"' || c == '\'':
+			for i++; i < len(s) && s[i] != c; i++ {
+				if s[i] == '\\' {
+					i++
+				}
+			}
+			continue
+		case c == '/' && i+1 < len(s) && s[i+1] == '/':
+			for i < len(s) && s[i] != '\n' {
+				i++
+			}
+			continue
+		case c == '/' && i+1 < len(s) && s[i+1] == '*':
+			end := bytes.Index(s[i+2:], []byte("*/"))
+			if end < 0 {
+				return len(s)
+			}
+			i += end + 3
+			continue
+		case c == '}' || c == ']' || c == ')':
+			depth--
+		}
+		if stop(c, depth) {
+			return i
+		}
+		if c == '{' || c == '[' || c == '(' {
+			depth++
+		}
+	}
+	return len(s)
+}
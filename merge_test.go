@@ -0,0 +1,251 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMergeProto(t *testing.T) {
+	t.Parallel()
+
+	user := message{
+		Name: "User",
+		Fields: []field{
+			{Name: "name", TypeName: "string", Order: 1, Tags: `json:"name"`},
+			{Name: "age", TypeName: "int32", Order: 4},
+			{Name: "shape", Oneof: []field{
+				{Name: "shapeCircle", TypeName: "Circle", Order: 5},
+			}},
+		},
+	}
+
+	var testCases = []struct {
+		testName      string
+		given         string
+		givenMsgs     []message
+		givenServices []service
+		givenSyntax   Syntax
+		expected      string
+	}{
+		{
+			testName: "syntax changed",
+			givenMsgs: []message{{Name: "Line", fullName: "Line", Fields: []field{
+				{Name: "qty", TypeName: "uint32", Order: 1, presenceOptions: []string{"features.field_presence = EXPLICIT"}},
+			}}},
+			givenSyntax: SyntaxEdition2023,
+			given: `syntax = "proto3";
+package proto;
+
+import "tagger/tagger.proto";
+
+message Line {
+  uint32 qty = 1;
+}
+`,
+			expected: `edition = "2023";
+package proto;
+
+import "tagger/tagger.proto";
+option features.field_presence = IMPLICIT;
+
+message Line {
+  uint32 qty = 1 [features.field_presence = EXPLICIT];
+}
+`,
+		},
+		{
+			testName:  "fields replaced, added and reserved",
+			givenMsgs: []message{user},
+			given: `syntax = "proto3";
+package proto;
+
+import "tagger/tagger.proto";
+
+// kept
+message User {
+  option deprecated = true;
+  // name
+  string name = 1; // inline
+  repeated int64 scores = 2 [(foo) = "a;b"];
+  oneof shape {
+    Circle shapeCircle = 5;
+    Square shapeSquare = 6;
+  }
+}
+
+service Users {
+  rpc Get(User) returns (User);
+}
+`,
+			expected: `syntax = "proto3";
+package proto;
+
+import "tagger/tagger.proto";
+
+// kept
+message User {
+  option deprecated = true;
+  // name
+  string name = 1 [(tagger.tags) = "json:\"name\""]; // inline
+  reserved 2;
+  reserved "scores";
+  oneof shape {
+    Circle shapeCircle = 5;
+  }
+  reserved 6;
+  reserved "shapeSquare";
+  int32 age = 4;
+}
+
+service Users {
+  rpc Get(User) returns (User);
+}
+`,
+		},
+		{
+			testName: "removed fields added back",
+			givenMsgs: []message{{Name: "Account", Fields: []field{
+				{Name: "id", TypeName: "string", Order: 1},
+				{Name: "old", TypeName: "string", Order: 3},
+				{Name: "extra", TypeName: "int32", Order: 6},
+			}}},
+			given: `syntax = "proto3";
+
+message Account {
+  string id = 1;
+  reserved 2;
+  reserved "old";
+  reserved 5 to 7;
+}
+`,
+			expected: `syntax = "proto3";
+
+message Account {
+  string id = 1;
+  reserved 2;
+  reserved 5, 7;
+  string old = 3;
+  int32 extra = 6;
+}
+`,
+		},
+		{
+			testName: "messages and imports added",
+			givenMsgs: []message{
+				{Name: "Event", Fields: []field{{Name: "payload", TypeName: "google.protobuf.Any", Order: 1}}},
+				{Name: "Order", Fields: []field{{Name: "id", TypeName: "string", Order: 1}}},
+			},
+			given: `syntax = "proto3";
+package proto;
+
+import "tagger/tagger.proto";
+
+message Order {
+  string id = 1;
+  message Line {
+    string sku = 1;
+  }
+}`,
+			expected: `syntax = "proto3";
+package proto;
+
+import "tagger/tagger.proto";
+import "google/protobuf/any.proto";
+
+message Order {
+  string id = 1;
+  message Line {
+    string sku = 1;
+  }
+}
+
+//easyjson:json
+message Event {
+  google.protobuf.Any payload = 1;
+}
+`,
+		},
+		{
+			testName: "nested messages merged",
+			givenMsgs: []message{
+				{
+					Name:   "Order",
+					Fields: []field{{Name: "lines", TypeName: "Line", Order: 1, IsRepeated: true}},
+					Nested: []message{
+						{Name: "Line", Fields: []field{{Name: "sku", TypeName: "string", Order: 1}}},
+						{Name: "Meta", Fields: []field{{Name: "a", TypeName: "string", Order: 1}}},
+					},
+				},
+			},
+			given: `syntax = "proto3";
+import "tagger/tagger.proto";
+message Order {
+  repeated Line lines = 1;
+  message Line {
+    int64 sku = 1;
+  }
+}
+`,
+			expected: `syntax = "proto3";
+import "tagger/tagger.proto";
+message Order {
+  repeated Line lines = 1;
+  message Line {
+    string sku = 1;
+  }
+  message Meta {
+    string a = 1;
+  }
+}
+`,
+		},
+		{
+			testName: "services merged",
+			givenServices: []service{
+				{Name: "Users", Methods: []rpc{
+					{Name: "Get", Request: "GetRequest", Response: "User"},
+					{Name: "Watch", Request: "GetRequest", Response: "User", ServerStreaming: true},
+					{Name: "Delete", Request: "GetRequest", Response: "google.protobuf.Empty"},
+				}},
+				{Name: "Admin", Methods: []rpc{
+					{Name: "Ban", Request: "BanRequest", Response: "google.protobuf.Empty"},
+				}},
+			},
+			given: `syntax = "proto3";
+
+service Users {
+  // get a user
+  rpc Get(GetRequest) returns (Profile) {
+    option deprecated = true;
+  }
+  rpc Watch(GetRequest) returns (User);
+  rpc Custom(GetRequest) returns (User);
+}
+`,
+			expected: `syntax = "proto3";
+import "google/protobuf/empty.proto";
+
+service Users {
+  // get a user
+  rpc Get(GetRequest) returns (User) {
+    option deprecated = true;
+  }
+  rpc Watch(GetRequest) returns (stream User);
+  rpc Custom(GetRequest) returns (User);
+  rpc Delete(GetRequest) returns (google.protobuf.Empty);
+}
+
+service Admin {
+  rpc Ban(BanRequest) returns (google.protobuf.Empty);
+}
+`,
+		},
+	}
+
+	for _, testCase := range testCases {
+		result, err := mergeProto([]byte(testCase.given), testCase.givenMsgs, testCase.givenServices, testCase.givenSyntax, outputTemplate())
+		assert.NoError(t, err, testCase.testName)
+		assert.Equal(t, testCase.expected, string(result), testCase.testName)
+	}
+}
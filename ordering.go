@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Ordering selects the order in which messages and fields are written.
+type Ordering string
+
+const (
+	// OrderDefault sorts messages by name and keeps fields and nested
+	// messages in Go declaration order.
+	OrderDefault Ordering = ""
+	// OrderExisting follows the existing proto, items not in it are appended
+	// in the default order.
+	OrderExisting Ordering = "existing"
+	// OrderAlphabetical sorts messages, nested messages and fields by name.
+	OrderAlphabetical Ordering = "alphabetical"
+	// OrderSource follows the position of the Go declarations. Generated
+	// wrapper messages have none and come last.
+	OrderSource Ordering = "source"
+	// OrderNumber sorts fields by number, messages in the default order.
+	OrderNumber Ordering = "number"
+)
+
+func (o Ordering) validate() error {
+	switch o {
+	case OrderDefault, OrderExisting, OrderAlphabetical, OrderSource, OrderNumber:
+		return nil
+	}
+	return fmt.Errorf("unknown order %q, expected one of %q, %q, %q, %q", string(o), OrderExisting, OrderAlphabetical, OrderSource, OrderNumber)
+}
+
+// orderMessages returns msgs, their nested messages and fields in the given
+// ordering. Nested messages and fields are copied, as they can be shared
+// between a struct and the structs embedding it.
+func orderMessages(msgs []message, ordering Ordering, currProtoMessages ProtoMessageMap, nested bool) []message {
+	out := make([]message, len(msgs))
+	copy(out, msgs)
+
+	byName := func(i, j int) bool { return out[i].Name < out[j].Name }
+	// the default order of top-level messages is also the order of those
+	// without an existing or source position
+	if !nested || ordering == OrderAlphabetical {
+		sort.SliceStable(out, byName)
+	}
+	switch ordering {
+	case OrderExisting:
+		sort.SliceStable(out, func(i, j int) bool {
+			return positionLess(currProtoMessages.MessagePosition(out[i].fullName), currProtoMessages.MessagePosition(out[j].fullName))
+		})
+	case OrderSource:
+		sort.SliceStable(out, func(i, j int) bool { return positionLess(int(out[i].pos), int(out[j].pos)) })
+	}
+
+	for i := range out {
+		out[i].Fields = orderFields(out[i].Fields, out[i].fullName, ordering, currProtoMessages)
+		out[i].Nested = orderMessages(out[i].Nested, ordering, currProtoMessages, true)
+	}
+	return out
+}
+
+// orderFields returns the fields of message msgName, and the members of its
+// oneofs, in the given ordering. Fields are in Go declaration order to start
+// with.
+func orderFields(fields []field, msgName string, ordering Ordering, currProtoMessages ProtoMessageMap) []field {
+	out := make([]field, len(fields))
+	copy(out, fields)
+
+	switch ordering {
+	case OrderExisting:
+		sort.SliceStable(out, func(i, j int) bool {
+			return positionLess(currProtoMessages.FieldPosition(msgName, out[i].Name), currProtoMessages.FieldPosition(msgName, out[j].Name))
+		})
+	case OrderAlphabetical:
+		sort.SliceStable(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	case OrderNumber:
+		sort.SliceStable(out, func(i, j int) bool { return fieldNumber(out[i]) < fieldNumber(out[j]) })
+	}
+
+	for i := range out {
+		if len(out[i].Oneof) > 0 {
+			out[i].Oneof = orderFields(out[i].Oneof, msgName, ordering, currProtoMessages)
+		}
+	}
+	return out
+}
+
+// positionLess orders positions, with 0 for no position ordered last.
+func positionLess(a, b int) bool {
+	return a != 0 && (b == 0 || a < b)
+}
+
+// fieldNumber returns the number of f, the lowest number of its members for a
+// oneof.
+func fieldNumber(f field) int {
+	if len(f.Oneof) == 0 {
+		return f.Order
+	}
+	min := fieldNumber(f.Oneof[0])
+	for _, member := range f.Oneof[1:] {
+		if n := fieldNumber(member); n < min {
+			min = n
+		}
+	}
+	return min
+}
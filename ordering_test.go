@@ -0,0 +1,138 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOrderMessages(t *testing.T) {
+	t.Parallel()
+
+	givenMsgs := []message{
+		{
+			Name:     "User",
+			fullName: "User",
+			pos:      10,
+			Fields: []field{
+				{Name: "name", Order: 2},
+				{Name: "contact", Oneof: []field{{Name: "phone", Order: 4}, {Name: "email", Order: 3}}},
+				{Name: "age", Order: 1},
+			},
+			Nested: []message{
+				{Name: "Meta", fullName: "User.Meta", pos: 12},
+				{Name: "Address", fullName: "User.Address", pos: 11},
+			},
+		},
+		{Name: "StringList", fullName: "StringList"},
+		{Name: "Order", fullName: "Order", pos: 20},
+		{Name: "Event", fullName: "Event", pos: 5},
+	}
+	givenProtoMessageMap := ProtoMessageMap{
+		"Order":        {position: 1},
+		"User":         {position: 2, fieldPositions: map[string]int{"age": 1, "contact": 2, "email": 3, "phone": 4}},
+		"User.Address": {position: 3},
+		"User.Meta":    {position: 4},
+	}
+
+	type order struct {
+		Messages []string
+		Fields   []string
+		Members  []string
+		Nested   []string
+	}
+	var testCases = []struct {
+		testName string
+		given    Ordering
+		expected order
+	}{
+		{
+			testName: "default",
+			given:    OrderDefault,
+			expected: order{
+				Messages: []string{"Event", "Order", "StringList", "User"},
+				Fields:   []string{"name", "contact", "age"},
+				Members:  []string{"phone", "email"},
+				Nested:   []string{"Meta", "Address"},
+			},
+		},
+		{
+			testName: "existing",
+			given:    OrderExisting,
+			expected: order{
+				Messages: []string{"Order", "User", "Event", "StringList"},
+				Fields:   []string{"age", "contact", "name"},
+				Members:  []string{"email", "phone"},
+				Nested:   []string{"Address", "Meta"},
+			},
+		},
+		{
+			testName: "alphabetical",
+			given:    OrderAlphabetical,
+			expected: order{
+				Messages: []string{"Event", "Order", "StringList", "User"},
+				Fields:   []string{"age", "contact", "name"},
+				Members:  []string{"email", "phone"},
+				Nested:   []string{"Address", "Meta"},
+			},
+		},
+		{
+			testName: "source",
+			given:    OrderSource,
+			expected: order{
+				Messages: []string{"Event", "User", "Order", "StringList"},
+				Fields:   []string{"name", "contact", "age"},
+				Members:  []string{"phone", "email"},
+				Nested:   []string{"Address", "Meta"},
+			},
+		},
+		{
+			testName: "number",
+			given:    OrderNumber,
+			expected: order{
+				Messages: []string{"Event", "Order", "StringList", "User"},
+				Fields:   []string{"age", "name", "contact"},
+				Members:  []string{"email", "phone"},
+				Nested:   []string{"Meta", "Address"},
+			},
+		},
+	}
+
+	names := func(msgs []message) []string {
+		var out []string
+		for _, msg := range msgs {
+			out = append(out, msg.Name)
+		}
+		return out
+	}
+	fieldNames := func(fields []field) []string {
+		var out []string
+		for _, f := range fields {
+			out = append(out, f.Name)
+		}
+		return out
+	}
+
+	for _, testCase := range testCases {
+		result := orderMessages(givenMsgs, testCase.given, givenProtoMessageMap, false)
+
+		var user message
+		for _, msg := range result {
+			if msg.Name == "User" {
+				user = msg
+			}
+		}
+		var members []field
+		for _, f := range user.Fields {
+			members = append(members, f.Oneof...)
+		}
+		assert.Equal(t, testCase.expected, order{
+			Messages: names(result),
+			Fields:   fieldNames(user.Fields),
+			Members:  fieldNames(members),
+			Nested:   names(user.Nested),
+		}, testCase.testName)
+	}
+	assert.Equal(t, "User", givenMsgs[0].Name, "messages are copied")
+	assert.Equal(t, "name", givenMsgs[0].Fields[0].Name, "fields are copied")
+}
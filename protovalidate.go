@@ -0,0 +1,322 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+const (
+	protovalidateImport = "buf/validate/validate.proto"
+	protovalidateOption = "(buf.validate.field)"
+	validateTagKey      = "validate"
+)
+
+// protovalidateNumbers are the proto scalars with numeric rules, keyed by
+// scalar and telling whether the scalar is an integer, and if so whether it
+// is unsigned.
+var protovalidateNumbers = map[string]struct{ integer, unsigned bool }{
+	"int32": {true, false}, "int64": {true, false}, "sint32": {true, false}, "sint64": {true, false},
+	"sfixed32": {true, false}, "sfixed64": {true, false},
+	"uint32": {true, true}, "uint64": {true, true}, "fixed32": {true, true}, "fixed64": {true, true},
+	"float": {false, false}, "double": {false, false},
+}
+
+// protovalidatePatterns are the go-playground rules translated to string
+// patterns.
+var protovalidatePatterns = map[string]string{
+	"alpha":    "^[a-zA-Z]+$",
+	"alphanum": "^[a-zA-Z0-9]+$",
+	"numeric":  "^[-+]?[0-9]+(?:\\.[0-9]+)?$",
+}
+
+// protovalidateFlags are the go-playground string rules with a boolean
+// protovalidate string rule.
+var protovalidateFlags = map[string]string{
+	"email":    "email",
+	"url":      "uri",
+	"uri":      "uri",
+	"uuid":     "uuid",
+	"hostname": "hostname",
+	"ip":       "ip",
+	"ipv4":     "ipv4",
+	"ipv6":     "ipv6",
+}
+
+// withValidateRules sets the protovalidate options of f translated from the
+// go-playground validate tag of its struct tag. Rules that can't be
+// translated are kept in f.untranslatedRules for the report.
+func withValidateRules(f field, tag string) field {
+	validate, ok := reflect.StructTag(tag).Lookup(validateTagKey)
+	if !ok || validate == "" || validate == "-" {
+		return f
+	}
+	rules, untranslated := translateValidateTag(validate, f)
+	f.TagOptions = append(f.TagOptions, rules...)
+	f.untranslatedRules = untranslated
+	return f
+}
+
+// translateValidateTag translates the rules of a go-playground validate tag,
+// like required,min=1,max=64,email, into protovalidate options of field f.
+// After dive, the rules apply to the items of a repeated field or the values
+// of a map field, and between keys and endkeys to the keys of the map. A dive
+// into anything else, like bytes or the wrapper of a nested slice, leaves it
+// and the rules after it untranslated.
+func translateValidateTag(tag string, f field) ([]string, []string) {
+	var rules, untranslated []string
+	prefix := protovalidateOption
+	kind := f
+	dived := false
+	tagRules := splitValidateTag(tag)
+	for i, rule := range tagRules {
+		switch {
+		case rule == "dive" && !dived && f.IsRepeated:
+			prefix, kind, dived = protovalidateOption+".repeated.items", field{TypeName: f.TypeName}, true
+			continue
+		case rule == "dive" && !dived && f.MapKey != "":
+			prefix, kind, dived = protovalidateOption+".map.values", field{TypeName: f.TypeName}, true
+			continue
+		case rule == "keys" && dived && f.MapKey != "":
+			prefix, kind = protovalidateOption+".map.keys", field{TypeName: f.MapKey}
+			continue
+		case rule == "endkeys" && dived && f.MapKey != "":
+			prefix, kind = protovalidateOption+".map.values", field{TypeName: f.TypeName}
+			continue
+		case rule == "dive" || rule == "keys" || rule == "endkeys":
+			return rules, append(untranslated, tagRules[i:]...)
+		}
+		translated := translateValidateRule(rule, kind)
+		if len(translated) == 0 {
+			untranslated = append(untranslated, rule)
+			continue
+		}
+		for _, option := range translated {
+			rules = append(rules, prefix+option)
+		}
+	}
+	return rules, untranslated
+}
+
+// translateValidateRule returns the protovalidate options, relative to the
+// field option, of a single go-playground rule for a field of the kind of f,
+// or nothing if there are none.
+func translateValidateRule(rule string, f field) []string {
+	name, param, _ := strings.Cut(rule, "=")
+	switch name {
+	case "required":
+		return []string{".required = true"}
+	case "omitempty":
+		return []string{".ignore = IGNORE_IF_ZERO_VALUE"}
+	}
+
+	switch {
+	case f.IsRepeated:
+		return translateCountRule(name, param, ".repeated", "items", map[string]string{"unique": ".repeated.unique = true"})
+	case f.MapKey != "":
+		return translateCountRule(name, param, ".map", "pairs", nil)
+	case f.TypeName == "string":
+		return translateStringRule(name, param)
+	case f.TypeName == "bytes":
+		return translateLengthRule(name, param, ".bytes")
+	}
+	if number, ok := protovalidateNumbers[f.TypeName]; ok {
+		return translateNumberRule(name, param, "."+f.TypeName, number.integer, number.unsigned)
+	}
+	return nil
+}
+
+func translateStringRule(name, param string) []string {
+	if option, ok := protovalidateFlags[name]; ok && param == "" {
+		return []string{".string." + option + " = true"}
+	}
+	if pattern, ok := protovalidatePatterns[name]; ok && param == "" {
+		return []string{".string.pattern = " + strconv.Quote(pattern)}
+	}
+	switch name {
+	case "eq":
+		return []string{".string.const = " + strconv.Quote(param)}
+	case "ne":
+		return []string{".string.not_in = " + strconv.Quote(param)}
+	case "oneof":
+		var out []string
+		for _, value := range splitOneofParam(param) {
+			out = append(out, ".string.in = "+strconv.Quote(value))
+		}
+		return out
+	case "contains":
+		return []string{".string.contains = " + strconv.Quote(param)}
+	case "startswith":
+		return []string{".string.prefix = " + strconv.Quote(param)}
+	case "endswith":
+		return []string{".string.suffix = " + strconv.Quote(param)}
+	}
+	return translateLengthRule(name, param, ".string")
+}
+
+// translateLengthRule translates the length rules of strings and bytes.
+func translateLengthRule(name, param, rules string) []string {
+	n, err := strconv.ParseUint(param, 10, 64)
+	if err != nil {
+		return nil
+	}
+	switch name {
+	case "min", "gte":
+		return []string{fmt.Sprintf("%s.min_len = %d", rules, n)}
+	case "max", "lte":
+		return []string{fmt.Sprintf("%s.max_len = %d", rules, n)}
+	case "len":
+		return []string{fmt.Sprintf("%s.len = %d", rules, n)}
+	case "gt":
+		return []string{fmt.Sprintf("%s.min_len = %d", rules, n+1)}
+	case "lt":
+		if n > 0 {
+			return []string{fmt.Sprintf("%s.max_len = %d", rules, n-1)}
+		}
+	}
+	return nil
+}
+
+// translateCountRule translates the rules on the number of items of repeated
+// fields and of pairs of maps. extra are the rules specific to the kind.
+func translateCountRule(name, param, rules, unit string, extra map[string]string) []string {
+	if option, ok := extra[name]; ok && param == "" {
+		return []string{option}
+	}
+	n, err := strconv.ParseUint(param, 10, 64)
+	if err != nil {
+		return nil
+	}
+	minRule, maxRule := fmt.Sprintf("%s.min_%s", rules, unit), fmt.Sprintf("%s.max_%s", rules, unit)
+	switch name {
+	case "min", "gte":
+		return []string{fmt.Sprintf("%s = %d", minRule, n)}
+	case "max", "lte":
+		return []string{fmt.Sprintf("%s = %d", maxRule, n)}
+	case "len":
+		return []string{fmt.Sprintf("%s = %d", minRule, n), fmt.Sprintf("%s = %d", maxRule, n)}
+	case "gt":
+		return []string{fmt.Sprintf("%s = %d", minRule, n+1)}
+	case "lt":
+		if n > 0 {
+			return []string{fmt.Sprintf("%s = %d", maxRule, n-1)}
+		}
+	}
+	return nil
+}
+
+func translateNumberRule(name, param, rules string, integer, unsigned bool) []string {
+	var values []string
+	params := []string{param}
+	if name == "oneof" {
+		params = splitOneofParam(param)
+	}
+	for _, p := range params {
+		value, ok := numberLiteral(p, integer, unsigned)
+		if !ok {
+			return nil
+		}
+		values = append(values, value)
+	}
+	if len(values) == 0 {
+		return nil
+	}
+
+	var option string
+	switch name {
+	case "min", "gte":
+		option = "gte"
+	case "max", "lte":
+		option = "lte"
+	case "gt", "lt":
+		option = name
+	case "eq", "len":
+		option = "const"
+	case "ne":
+		option = "not_in"
+	case "oneof":
+		option = "in"
+	default:
+		return nil
+	}
+	var out []string
+	for _, value := range values {
+		out = append(out, fmt.Sprintf("%s.%s = %s", rules, option, value))
+	}
+	return out
+}
+
+// numberLiteral returns param as a literal of the number type, if it is one.
+func numberLiteral(param string, integer, unsigned bool) (string, bool) {
+	var err error
+	switch {
+	case integer && unsigned:
+		_, err = strconv.ParseUint(param, 10, 64)
+	case integer:
+		_, err = strconv.ParseInt(param, 10, 64)
+	default:
+		_, err = strconv.ParseFloat(param, 64)
+	}
+	return param, err == nil && param != ""
+}
+
+// splitValidateTag splits a validate tag into its rules. Rules with an or,
+// like rgb|rgba, are kept whole and can't be translated.
+func splitValidateTag(tag string) []string {
+	var rules []string
+	for _, rule := range strings.Split(tag, ",") {
+		if rule = strings.TrimSpace(rule); rule != "" {
+			rules = append(rules, rule)
+		}
+	}
+	return rules
+}
+
+// splitOneofParam splits the values of a oneof rule, separated by spaces and
+// optionally single quoted.
+func splitOneofParam(param string) []string {
+	var values []string
+	for param != "" {
+		param = strings.TrimLeft(param, " ")
+		if strings.HasPrefix(param, "'") {
+			end := strings.IndexByte(param[1:], '\'')
+			if end < 0 {
+				return append(values, param[1:])
+			}
+			values = append(values, param[1:end+1])
+			param = param[end+2:]
+			continue
+		}
+		value, rest, _ := strings.Cut(param, " ")
+		if value != "" {
+			values = append(values, value)
+		}
+		param = rest
+	}
+	return values
+}
+
+// untranslatedRulesReport lists the validate rules of msgs that couldn't be
+// translated, one per line with the field they are on.
+func untranslatedRulesReport(msgs []message) []string {
+	var report []string
+	var addFields func(msgName string, fields []field)
+	addFields = func(msgName string, fields []field) {
+		for _, f := range fields {
+			for _, rule := range f.untranslatedRules {
+				report = append(report, fmt.Sprintf("%s.%s: validate rule %q not translated", msgName, f.Name, rule))
+			}
+			addFields(msgName, f.Oneof)
+		}
+	}
+	var addMessages func(msgs []message)
+	addMessages = func(msgs []message) {
+		for _, msg := range msgs {
+			addFields(msg.fullName, msg.Fields)
+			addMessages(msg.Nested)
+		}
+	}
+	addMessages(msgs)
+	return report
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTranslateValidateTag(t *testing.T) {
+	t.Parallel()
+
+	var testCases = []struct {
+		testName             string
+		givenTag             string
+		givenField           field
+		expectedRules        []string
+		expectedUntranslated []string
+	}{
+		{
+			testName:   "string",
+			givenTag:   "required,min=1,max=64,email",
+			givenField: field{TypeName: "string"},
+			expectedRules: []string{
+				"(buf.validate.field).required = true",
+				"(buf.validate.field).string.min_len = 1",
+				"(buf.validate.field).string.max_len = 64",
+				"(buf.validate.field).string.email = true",
+			},
+		},
+		{
+			testName:   "string values",
+			givenTag:   "omitempty,oneof=a 'b c',ne=x,startswith=id-,uri,uuid",
+			givenField: field{TypeName: "string"},
+			expectedRules: []string{
+				"(buf.validate.field).ignore = IGNORE_IF_ZERO_VALUE",
+				`(buf.validate.field).string.in = "a"`,
+				`(buf.validate.field).string.in = "b c"`,
+				`(buf.validate.field).string.not_in = "x"`,
+				`(buf.validate.field).string.prefix = "id-"`,
+				"(buf.validate.field).string.uri = true",
+				"(buf.validate.field).string.uuid = true",
+			},
+		},
+		{
+			testName:   "numbers",
+			givenTag:   "gte=1,lt=100,ne=7",
+			givenField: field{TypeName: "sint64"},
+			expectedRules: []string{
+				"(buf.validate.field).sint64.gte = 1",
+				"(buf.validate.field).sint64.lt = 100",
+				"(buf.validate.field).sint64.not_in = 7",
+			},
+		},
+		{
+			testName:             "number out of type",
+			givenTag:             "min=-1,max=1.5",
+			givenField:           field{TypeName: "uint32"},
+			expectedUntranslated: []string{"min=-1", "max=1.5"},
+		},
+		{
+			testName:   "bytes",
+			givenTag:   "len=16",
+			givenField: field{TypeName: "bytes"},
+			expectedRules: []string{
+				"(buf.validate.field).bytes.len = 16",
+			},
+		},
+		{
+			testName:   "repeated with dive",
+			givenTag:   "min=1,max=5,unique,dive,gt=0",
+			givenField: field{TypeName: "double", IsRepeated: true},
+			expectedRules: []string{
+				"(buf.validate.field).repeated.min_items = 1",
+				"(buf.validate.field).repeated.max_items = 5",
+				"(buf.validate.field).repeated.unique = true",
+				"(buf.validate.field).repeated.items.double.gt = 0",
+			},
+		},
+		{
+			testName:   "map with keys",
+			givenTag:   "len=2,dive,keys,max=8,endkeys,required",
+			givenField: field{TypeName: "Line", MapKey: "string"},
+			expectedRules: []string{
+				"(buf.validate.field).map.min_pairs = 2",
+				"(buf.validate.field).map.max_pairs = 2",
+				"(buf.validate.field).map.keys.string.max_len = 8",
+				"(buf.validate.field).map.values.required = true",
+			},
+		},
+		{
+			testName:   "untranslated",
+			givenTag:   "required,iscolor,rgb|rgba,dive",
+			givenField: field{TypeName: "Color"},
+			expectedRules: []string{
+				"(buf.validate.field).required = true",
+			},
+			expectedUntranslated: []string{"iscolor", "rgb|rgba", "dive"},
+		},
+		{
+			testName:   "dive into bytes",
+			givenTag:   "max=16,dive,min=1",
+			givenField: field{TypeName: "bytes"},
+			expectedRules: []string{
+				"(buf.validate.field).bytes.max_len = 16",
+			},
+			expectedUntranslated: []string{"dive", "min=1"},
+		},
+		{
+			testName:             "second dive",
+			givenTag:             "dive,dive,max=3",
+			givenField:           field{TypeName: "StringList", IsRepeated: true},
+			expectedUntranslated: []string{"dive", "max=3"},
+		},
+	}
+
+	for _, testCase := range testCases {
+		rules, untranslated := translateValidateTag(testCase.givenTag, testCase.givenField)
+		assert.Equal(t, testCase.expectedRules, rules, testCase.testName)
+		assert.Equal(t, testCase.expectedUntranslated, untranslated, testCase.testName)
+	}
+}
+
+func TestFieldOptions(t *testing.T) {
+	t.Parallel()
+
+	var testCases = []struct {
+		testName string
+		given    field
+		expected string
+	}{
+		{
+			testName: "rules replace existing rules",
+			given: field{
+				Name:       "email",
+				TypeName:   "string",
+				Order:      1,
+				Tags:       `validate:"email"`,
+				Options:    []string{"(buf.validate.field).string.max_len = 8", "deprecated = true"},
+				TagOptions: []string{"(buf.validate.field).string.email = true"},
+			},
+			expected: `string email = 1 [(buf.validate.field).string.email = true, deprecated = true, (tagger.tags) = "validate:\"email\""]; `,
+		},
+		{
+			testName: "existing rules without validate tag",
+			given: field{
+				Name:     "email",
+				TypeName: "string",
+				Order:    1,
+				Options:  []string{"(buf.validate.field).string.max_len = 8"},
+			},
+			expected: `string email = 1 [(buf.validate.field).string.max_len = 8]; `,
+		},
+	}
+
+	for _, testCase := range testCases {
+		var buf strings.Builder
+		assert.NoError(t, outputTemplate().ExecuteTemplate(&buf, "field", testCase.given), testCase.testName)
+		assert.Equal(t, testCase.expected, buf.String(), testCase.testName)
+	}
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"go/token"
+	"io"
+	"os"
+	"sort"
+)
+
+const (
+	driftGoOnly    = "go_only"
+	driftProtoOnly = "proto_only"
+	driftMismatch  = "mismatch"
+)
+
+// drift is a difference between the Go structs and an existing proto: a
+// message or field only in one of them, or a field whose type or
+// repeatedness differ. Field is empty for messages.
+type drift struct {
+	Kind    string `json:"kind"`
+	Message string `json:"message"`
+	Field   string `json:"field,omitempty"`
+	Go      string `json:"go,omitempty"`
+	Proto   string `json:"proto,omitempty"`
+}
+
+// runReport runs the report subcommand, printing how the existing proto
+// drifted from the messages the Go structs would generate. Nothing is written.
+func runReport(args []string) int {
+	flags := newFlagSet("report", "-c <proto> -p <package> [flags]",
+		"Lists the messages and fields only in the Go structs or only in the proto, and the fields whose type differs, "+
+			"exiting with 1 if there are any.")
+	protoFileName := flags.String("c", "", "Full filepath of the existing proto to compare the Go structs to. Required.")
+	configFile := flags.String("config", "", "Full filepath for a YAML config file, if applicable.")
+	reportFilter := flags.String("filter", "", "Filter struct names.")
+	snakeFieldNames := flags.Bool("s", false, "Use if the proto field names are snake_case instead of camelCase.")
+	format := flags.String("format", "text", "Report format: text or json.")
+	var reportPkgs arrFlags
+	flags.Var(&reportPkgs, "p", "Go package to compare, repeatable. Required.")
+	if code, ok := parseFlags(flags, args); !ok {
+		return code
+	}
+
+	if len(reportPkgs) == 0 || *protoFileName == "" {
+		flags.Usage()
+		return exitError
+	}
+	if *format != "text" && *format != "json" {
+		return fail(fmt.Errorf("unknown format %q, expected text or json", *format))
+	}
+
+	drifts, err := reportDrift(*protoFileName, *configFile, *reportFilter, *snakeFieldNames, reportPkgs)
+	if err != nil {
+		return fail(err)
+	}
+	if err := printDrift(os.Stdout, drifts, *format, *protoFileName); err != nil {
+		return fail(err)
+	}
+	if len(drifts) > 0 {
+		return exitDiffers
+	}
+	return exitOK
+}
+
+// reportDrift loads pkgs and returns their drift from the proto protoFileName.
+func reportDrift(protoFileName, configFile, filter string, snakeFieldNames bool, pkgs []string) ([]drift, error) {
+	cfg, err := LoadConfig(configFile)
+	if err != nil {
+		return nil, err
+	}
+	if filter != "" {
+		cfg.Filter = filter
+	}
+	if snakeFieldNames {
+		cfg.UseSnakeFieldNames = true
+	}
+
+	pwd, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+	loaded, err := loadPackages(pwd, token.NewFileSet(), pkgs)
+	if err != nil {
+		return nil, err
+	}
+	directives, err := BuildDirectiveMap(loaded)
+	if err != nil {
+		return nil, err
+	}
+	// numbering the fields adds the new messages to the map, so the proto
+	// compared to is read separately
+	numbering, err := BuildCurrentProtoMap(protoFileName)
+	if err != nil {
+		return nil, err
+	}
+	msgs, _, err := getMessages(loaded, cfg, numbering, directives)
+	if err != nil {
+		return nil, err
+	}
+	current, err := BuildCurrentProtoMap(protoFileName)
+	if err != nil {
+		return nil, err
+	}
+	return driftReport(msgs, current), nil
+}
+
+// printDrift writes drifts to out in format, text or json.
+func printDrift(out io.Writer, drifts []drift, format, protoFileName string) error {
+	if format == "json" {
+		encoder := json.NewEncoder(out)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(drifts)
+	}
+	if len(drifts) == 0 {
+		fmt.Fprintf(out, "no drift from %s\n", protoFileName)
+	}
+	for _, d := range drifts {
+		fmt.Fprintln(out, d.String())
+	}
+	return nil
+}
+
+// driftReport compares the messages generated from the Go structs to the
+// messages of the existing proto, sorted by message then field. Enums of the
+// proto are left out, as the Go structs generate none.
+func driftReport(msgs []message, current ProtoMessageMap) []drift {
+	goFields := messageFields(msgs, fieldType)
+	protoFields := make(map[string]map[string]string, len(current))
+	for name, msg := range current {
+		if msg.fieldTypes != nil {
+			protoFields[name] = msg.fieldTypes
+		}
+	}
+
+	names := sortedKeys(goFields)
+	for _, name := range sortedKeys(protoFields) {
+		if _, ok := goFields[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	drifts := []drift{}
+	for _, name := range names {
+		fields, inGo := goFields[name]
+		protoTypes, inProto := protoFields[name]
+		switch {
+		case !inProto:
+			drifts = append(drifts, drift{Kind: driftGoOnly, Message: name})
+			continue
+		case !inGo:
+			drifts = append(drifts, drift{Kind: driftProtoOnly, Message: name})
+			continue
+		}
+		for _, fieldName := range sortedKeys(fields) {
+			protoType, ok := protoTypes[fieldName]
+			switch {
+			case !ok:
+				drifts = append(drifts, drift{Kind: driftGoOnly, Message: name, Field: fieldName, Go: fields[fieldName]})
+			case protoType != fields[fieldName]:
+				drifts = append(drifts, drift{Kind: driftMismatch, Message: name, Field: fieldName, Go: fields[fieldName], Proto: protoType})
+			}
+		}
+		for _, fieldName := range sortedKeys(protoTypes) {
+			if _, ok := fields[fieldName]; !ok {
+				drifts = append(drifts, drift{Kind: driftProtoOnly, Message: name, Field: fieldName, Proto: protoTypes[fieldName]})
+			}
+		}
+	}
+	return drifts
+}
+
+// String describes the drift on a line, like
+// "Order.ids: repeated string in Go, string in proto".
+func (d drift) String() string {
+	if d.Field == "" {
+		switch d.Kind {
+		case driftGoOnly:
+			return d.Message + ": message only in Go"
+		default:
+			return d.Message + ": message only in proto"
+		}
+	}
+	name := d.Message + "." + d.Field
+	switch d.Kind {
+	case driftGoOnly:
+		return fmt.Sprintf("%s: field only in Go (%s)", name, d.Go)
+	case driftProtoOnly:
+		return fmt.Sprintf("%s: field only in proto (%s)", name, d.Proto)
+	}
+	return fmt.Sprintf("%s: %s in Go, %s in proto", name, d.Go, d.Proto)
+}
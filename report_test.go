@@ -0,0 +1,102 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDriftReport(t *testing.T) {
+	t.Parallel()
+
+	givenProto := `syntax = "proto3";
+
+message Order {
+  string id = 1;
+  string ids = 2;
+  int32 count = 3;
+  map<string, int32> meta = 4;
+  string legacy = 5;
+  oneof payment {
+    string card = 6;
+  }
+  message Line {
+    string sku = 1;
+  }
+}
+
+message Old {
+  string a = 1;
+}
+
+enum Status {
+  UNKNOWN = 0;
+}
+`
+	filename := filepath.Join(t.TempDir(), "current.proto")
+	if err := os.WriteFile(filename, []byte(givenProto), 0644); err != nil {
+		t.Fatal(err)
+	}
+	current, err := BuildCurrentProtoMap(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var testCases = []struct {
+		testName  string
+		givenMsgs []message
+		expected  []string
+	}{
+		{
+			testName: "no drift",
+			givenMsgs: []message{
+				{fullName: "Order", Fields: []field{
+					{Name: "id", TypeName: "string"},
+					{Name: "ids", TypeName: "string"},
+					{Name: "count", TypeName: "int32"},
+					{Name: "meta", TypeName: "int32", MapKey: "string"},
+					{Name: "legacy", TypeName: "string", Label: "optional"},
+					{Name: "payment", Oneof: []field{{Name: "card", TypeName: "string"}}},
+				}, Nested: []message{
+					{fullName: "Order.Line", Fields: []field{{Name: "sku", TypeName: "string"}}},
+				}},
+				{fullName: "Old", Fields: []field{{Name: "a", TypeName: "string"}}},
+			},
+			expected: []string{},
+		},
+		{
+			testName: "messages and fields drifted",
+			givenMsgs: []message{
+				{fullName: "Order", Fields: []field{
+					{Name: "id", TypeName: "string"},
+					{Name: "ids", TypeName: "string", IsRepeated: true},
+					{Name: "count", TypeName: "int64"},
+					{Name: "meta", TypeName: "int64", MapKey: "string"},
+					{Name: "note", TypeName: "string"},
+				}},
+				{fullName: "New"},
+			},
+			expected: []string{
+				"New: message only in Go",
+				"Old: message only in proto",
+				"Order.count: int64 in Go, int32 in proto",
+				"Order.ids: repeated string in Go, string in proto",
+				"Order.meta: map<string, int64> in Go, map<string, int32> in proto",
+				"Order.note: field only in Go (string)",
+				"Order.card: field only in proto (string)",
+				"Order.legacy: field only in proto (string)",
+				"Order.Line: message only in proto",
+			},
+		},
+	}
+
+	for _, testCase := range testCases {
+		result := []string{}
+		for _, d := range driftReport(testCase.givenMsgs, current) {
+			result = append(result, d.String())
+		}
+		assert.Equal(t, testCase.expected, result, testCase.testName)
+	}
+}
@@ -0,0 +1,394 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/types"
+	"sort"
+	"strings"
+)
+
+// roundTripImports are the standard library packages used by the round-trip
+// tests, keyed by path.
+var roundTripImports = map[string]string{
+	"math/rand/v2": "rand",
+	"reflect":      "reflect",
+	"strings":      "strings",
+	"testing":      "testing",
+	"time":         "time",
+}
+
+// fieldLoss records why the Go field at Path doesn't survive a conversion to
+// proto and back. Messages are the messages the field is converted with,
+// whose losses are the field's too.
+type fieldLoss struct {
+	Path     string
+	Reasons  []string
+	Messages []string
+}
+
+// oneofTypes are the types the round-trip tests set interface Iface to.
+type oneofTypes struct {
+	Iface   types.Type
+	Members []types.Type
+}
+
+// lose records that the field being converted to proto loses information.
+func (g *converterGen) lose(reason string) {
+	if g.field == nil {
+		return
+	}
+	for _, r := range g.field.Reasons {
+		if r == reason {
+			return
+		}
+	}
+	g.field.Reasons = append(g.field.Reasons, reason)
+}
+
+// oneofType records member as a type of interface t. Converting from proto
+// gives a value when member implements the interface and a pointer otherwise,
+// so that is the type the tests use.
+func (g *converterGen) oneofType(t, member types.Type, iface *types.Interface) {
+	key := types.TypeString(t, nil)
+	if _, ok := g.oneofs[key]; !ok {
+		g.oneofs[key] = &oneofTypes{Iface: t}
+	}
+	if !types.Implements(member, iface) {
+		member = types.NewPointer(member)
+	}
+	for _, m := range g.oneofs[key].Members {
+		if types.Identical(m, member) {
+			return
+		}
+	}
+	g.oneofs[key].Members = append(g.oneofs[key].Members, member)
+}
+
+// generateRoundTripTests returns a Go test file converting random values of
+// every Go struct to proto and back with the generated converters. Fields that
+// don't survive the round trip are listed as lossy with the reason, and left
+// out of the comparison.
+func generateRoundTripTests(msgs []message, cfg ConverterConfig) ([]byte, error) {
+	g := newConverterGen(msgs, cfg)
+	// the converters are only written to find the lossy fields and oneofs
+	g.converters(msgs)
+	g.used = map[string]struct{}{}
+
+	var body bytes.Buffer
+	body.WriteString("\nfunc TestRoundTrip(t *testing.T) {\n")
+	body.WriteString("var testCases = []struct {\ntestName string\nroundTrip func(r *rand.Rand) (given, result any)\n")
+	body.WriteString("// lossy are the fields that don't survive the round trip, with the reason\nlossy map[string]string\n}{\n")
+	var write func(msgs []message)
+	write = func(msgs []message) {
+		for _, msg := range msgs {
+			// values of anonymous structs are tested with their parent
+			if _, ok := msg.goType.(*types.Named); ok {
+				g.writeRoundTripCase(&body, msg)
+			}
+			write(msg.Nested)
+		}
+	}
+	write(msgs)
+	body.WriteString("}\n")
+	body.WriteString(roundTripLoop)
+	body.WriteString("}\n")
+
+	body.WriteString("\n// oneofMembers are the types fillRandom sets interfaces to.\n")
+	body.WriteString("var oneofMembers = map[reflect.Type][]reflect.Type{\n")
+	keys := make([]string, 0, len(g.oneofs))
+	for key := range g.oneofs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		oneof := g.oneofs[key]
+		var members []string
+		for _, member := range oneof.Members {
+			members = append(members, fmt.Sprintf("reflect.TypeFor[%s]()", g.typeString(member)))
+		}
+		fmt.Fprintf(&body, "reflect.TypeFor[%s](): {%s},\n", g.typeString(oneof.Iface), strings.Join(members, ", "))
+	}
+	body.WriteString("}\n")
+	body.WriteString(roundTripHelpers)
+
+	for path := range roundTripImports {
+		g.used[path] = struct{}{}
+	}
+	return g.file(cfg, body.Bytes())
+}
+
+func (g *converterGen) writeRoundTripCase(w *bytes.Buffer, msg message) {
+	name := goMessageName(msg.fullName)
+	fmt.Fprintf(w, "{\ntestName: %q,\n", name)
+	fmt.Fprintf(w, "roundTrip: func(r *rand.Rand) (any, any) {\nvar given %s\n", g.typeString(msg.goType))
+	fmt.Fprintf(w, "fillRandom(r, reflect.ValueOf(&given).Elem(), 0)\nreturn &given, %sFromProto(%sToProto(&given))\n},\n", name, name)
+
+	lossy := g.lossyFields(msg)
+	if len(lossy) > 0 {
+		paths := make([]string, 0, len(lossy))
+		for path := range lossy {
+			paths = append(paths, path)
+		}
+		sort.Strings(paths)
+		w.WriteString("lossy: map[string]string{\n")
+		for _, path := range paths {
+			fmt.Fprintf(w, "%q: %q,\n", path, lossy[path])
+		}
+		w.WriteString("},\n")
+	}
+	w.WriteString("},\n")
+}
+
+// lossyFields returns the Go fields of msg that don't survive a round trip,
+// keyed by path, with the reason.
+func (g *converterGen) lossyFields(msg message) map[string]string {
+	lossy := make(map[string]string)
+	for _, loss := range g.losses[msg.fullName] {
+		reasons := loss.Reasons
+		for _, name := range loss.Messages {
+			if g.isLossy(name, map[string]struct{}{}) {
+				reasons = append(reasons, name+" is lossy")
+			}
+		}
+		if len(reasons) > 0 {
+			lossy[loss.Path] = strings.Join(reasons, ", ")
+		}
+	}
+	for _, path := range g.unmappedFields(msg.fullName) {
+		lossy[path] = "not in the proto"
+	}
+	return lossy
+}
+
+// isLossy reports whether some fields of message name, or of the messages it
+// converts its fields with, don't survive a round trip.
+func (g *converterGen) isLossy(name string, visiting map[string]struct{}) bool {
+	if _, ok := visiting[name]; ok {
+		return false
+	}
+	visiting[name] = struct{}{}
+	for _, loss := range g.losses[name] {
+		if len(loss.Reasons) > 0 {
+			return true
+		}
+		for _, dep := range loss.Messages {
+			if g.isLossy(dep, visiting) {
+				return true
+			}
+		}
+	}
+	return len(g.unmappedFields(name)) > 0
+}
+
+// unmappedFields returns the paths of the exported Go fields of message name
+// that no proto field is converted from.
+func (g *converterGen) unmappedFields(name string) []string {
+	goType := g.messages[name].goType
+	if goType == nil {
+		return nil
+	}
+	s, ok := goType.Underlying().(*types.Struct)
+	if !ok {
+		return nil
+	}
+	// a field also covers the embedded structs it is promoted from
+	covered := make(map[string]struct{})
+	for _, loss := range g.losses[name] {
+		parts := strings.Split(loss.Path, ".")
+		for i := range parts {
+			covered[strings.Join(parts[:i+1], ".")] = struct{}{}
+		}
+	}
+	return unmappedFields(s, "", covered)
+}
+
+// unmappedFields returns the paths of the exported fields of s, including
+// those of embedded structs, that no proto field is converted from.
+func unmappedFields(s *types.Struct, prefix string, covered map[string]struct{}) []string {
+	var out []string
+	for i := 0; i < s.NumFields(); i++ {
+		f := s.Field(i)
+		if !f.Exported() {
+			continue
+		}
+		path := prefix + f.Name()
+		if _, ok := covered[path]; !ok {
+			out = append(out, path)
+			continue
+		}
+		if !f.Embedded() {
+			continue
+		}
+		t := f.Type()
+		if p, ok := t.Underlying().(*types.Pointer); ok {
+			t = p.Elem()
+		}
+		if embedded, ok := t.Underlying().(*types.Struct); ok && !isTime(t) {
+			out = append(out, unmappedFields(embedded, path+".", covered)...)
+		}
+	}
+	return out
+}
+
+const roundTripLoop = `
+for _, testCase := range testCases {
+	t.Run(testCase.testName, func(t *testing.T) {
+		r := rand.New(rand.NewPCG(1, 2))
+		for i := 0; i < roundTrips; i++ {
+			given, result := testCase.roundTrip(r)
+			expected, actual := reflect.ValueOf(given).Elem(), reflect.ValueOf(result).Elem()
+			for path := range testCase.lossy {
+				clearField(expected, path)
+				clearField(actual, path)
+			}
+			normalize(expected)
+			normalize(actual)
+			if !reflect.DeepEqual(expected.Interface(), actual.Interface()) {
+				t.Fatalf("%+v\nround-trips to\n%+v", expected.Interface(), actual.Interface())
+			}
+		}
+	})
+}
+`
+
+const roundTripHelpers = `
+// roundTrips is the number of random values converted per message.
+const roundTrips = 100
+
+// maxDepth bounds the nesting of random values of recursive types.
+const maxDepth = 3
+
+var timeType = reflect.TypeFor[time.Time]()
+
+// fillRandom sets v to a random value. Below maxDepth, pointers, slices, maps
+// and interfaces are left empty.
+func fillRandom(r *rand.Rand, v reflect.Value, depth int) {
+	if !v.CanSet() {
+		return
+	}
+	if v.Type() == timeType {
+		v.Set(reflect.ValueOf(time.Unix(r.Int64N(1<<33), r.Int64N(1e9)).UTC()))
+		return
+	}
+	switch v.Kind() {
+	case reflect.Bool:
+		v.SetBool(r.IntN(2) == 1)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		v.SetInt(int64(r.Uint64()))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		v.SetUint(r.Uint64())
+	case reflect.Float32, reflect.Float64:
+		v.SetFloat(r.NormFloat64())
+	case reflect.String:
+		b := make([]byte, r.IntN(8))
+		for i := range b {
+			b[i] = byte('a' + r.IntN(26))
+		}
+		v.SetString(string(b))
+	case reflect.Pointer:
+		if depth < maxDepth {
+			v.Set(reflect.New(v.Type().Elem()))
+			fillRandom(r, v.Elem(), depth+1)
+		}
+	case reflect.Slice:
+		if depth < maxDepth {
+			n := 1 + r.IntN(3)
+			v.Set(reflect.MakeSlice(v.Type(), n, n))
+			for i := 0; i < n; i++ {
+				fillRandom(r, v.Index(i), depth+1)
+			}
+		}
+	case reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			fillRandom(r, v.Index(i), depth+1)
+		}
+	case reflect.Map:
+		if depth < maxDepth {
+			v.Set(reflect.MakeMap(v.Type()))
+			for i := r.IntN(3); i >= 0; i-- {
+				key, elem := reflect.New(v.Type().Key()).Elem(), reflect.New(v.Type().Elem()).Elem()
+				fillRandom(r, key, depth+1)
+				fillRandom(r, elem, depth+1)
+				v.SetMapIndex(key, elem)
+			}
+		}
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			fillRandom(r, v.Field(i), depth)
+		}
+	case reflect.Interface:
+		if members := oneofMembers[v.Type()]; depth < maxDepth && len(members) > 0 {
+			member := reflect.New(members[r.IntN(len(members))]).Elem()
+			fillRandom(r, member, depth+1)
+			v.Set(member)
+		}
+	}
+}
+
+// normalize undoes the differences a round trip is expected to make: times
+// are in UTC, and empty slices and maps are nil.
+func normalize(v reflect.Value) {
+	if !v.CanSet() {
+		return
+	}
+	if v.Type() == timeType {
+		v.Set(reflect.ValueOf(v.Interface().(time.Time).UTC()))
+		return
+	}
+	switch v.Kind() {
+	case reflect.Pointer:
+		if !v.IsNil() {
+			normalize(v.Elem())
+		}
+	case reflect.Interface:
+		if !v.IsNil() {
+			elem := reflect.New(v.Elem().Type()).Elem()
+			elem.Set(v.Elem())
+			normalize(elem)
+			v.Set(elem)
+		}
+	case reflect.Slice, reflect.Map:
+		if v.Len() == 0 {
+			v.Set(reflect.Zero(v.Type()))
+			return
+		}
+		if v.Kind() == reflect.Slice {
+			for i := 0; i < v.Len(); i++ {
+				normalize(v.Index(i))
+			}
+			return
+		}
+		iter := v.MapRange()
+		for iter.Next() {
+			elem := reflect.New(v.Type().Elem()).Elem()
+			elem.Set(iter.Value())
+			normalize(elem)
+			v.SetMapIndex(iter.Key(), elem)
+		}
+	case reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			normalize(v.Index(i))
+		}
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			normalize(v.Field(i))
+		}
+	}
+}
+
+// clearField zeroes the field at the dotted path of struct v, which may go
+// through embedded structs.
+func clearField(v reflect.Value, path string) {
+	for _, name := range strings.Split(path, ".") {
+		for v.Kind() == reflect.Pointer {
+			if v.IsNil() {
+				return
+			}
+			v = v.Elem()
+		}
+		v = v.FieldByName(name)
+	}
+	v.Set(reflect.Zero(v.Type()))
+}
+`
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"fmt"
+	"go/types"
+	"reflect"
+	"strings"
+)
+
+// Encoding selects between the proto scalar types available for an integer.
+type Encoding string
+
+const (
+	// EncodingVarint is the default: int32/int64/uint32/uint64.
+	EncodingVarint Encoding = "varint"
+	// EncodingSint uses zigzag encoding, for signed values that are often
+	// negative: sint32/sint64.
+	EncodingSint Encoding = "sint"
+	// EncodingFixed uses fixed-width encoding, for hashes and large IDs:
+	// fixed32/fixed64/sfixed32/sfixed64.
+	EncodingFixed Encoding = "fixed"
+)
+
+const go2protoTagKey = "go2proto"
+
+func (e Encoding) validate() error {
+	switch e {
+	case "", EncodingVarint, EncodingSint, EncodingFixed:
+		return nil
+	}
+	return fmt.Errorf("unknown encoding %q, expected one of %q, %q, %q", string(e), EncodingVarint, EncodingSint, EncodingFixed)
+}
+
+// scalarType returns the proto scalar for basic type b. Encodings that don't
+// apply to b, like sint for an unsigned integer, are ignored. Complex numbers
+// and unsafe pointers have no proto scalar.
+func scalarType(b *types.Basic, enc Encoding) (string, error) {
+	info := b.Info()
+	if info&types.IsInteger == 0 {
+		switch b.Kind() {
+		case types.Float32, types.UntypedFloat:
+			return "float", nil
+		case types.Float64:
+			return "double", nil
+		case types.Bool, types.UntypedBool:
+			return "bool", nil
+		case types.String, types.UntypedString:
+			return "string", nil
+		}
+		return "", fmt.Errorf("unsupported type %s, proto has no scalar for it", b.Name())
+	}
+
+	bits := "32"
+	switch b.Kind() {
+	case types.Int, types.Int64, types.Uint, types.Uint64, types.Uintptr, types.UntypedInt:
+		bits = "64"
+	}
+
+	unsigned := info&types.IsUnsigned != 0
+	switch {
+	case enc == EncodingFixed && unsigned:
+		return "fixed" + bits, nil
+	case enc == EncodingFixed:
+		return "sfixed" + bits, nil
+	case enc == EncodingSint && !unsigned:
+		return "sint" + bits, nil
+	case unsigned:
+		return "uint" + bits, nil
+	default:
+		return "int" + bits, nil
+	}
+}
+
+// fieldEncoding picks the encoding of field f of message msgName. In order of
+// precedence it comes from the field's directive, its go2proto struct tag, the
+// config entry for the field and the config entry for its type.
+func fieldEncoding(msgName string, f *types.Var, tag string, directive Directive, cfg EncodingConfig) (Encoding, error) {
+	if directive.Encoding != "" {
+		return directive.Encoding, nil
+	}
+
+	enc, err := tagEncoding(tag)
+	if err != nil {
+		return "", fmt.Errorf("%s.%s: %v", msgName, f.Name(), err)
+	}
+	if enc != "" {
+		return enc, nil
+	}
+
+	if enc, ok := cfg.Fields[msgName+"."+f.Name()]; ok {
+		return enc, nil
+	}
+
+	t := f.Type()
+	for {
+		if named, ok := t.(*types.Named); ok {
+			if enc, ok := cfg.Types[named.Obj().Name()]; ok {
+				return enc, nil
+			}
+		}
+		switch u := t.Underlying().(type) {
+		case *types.Pointer:
+			t = u.Elem()
+		case *types.Slice:
+			t = u.Elem()
+		case *types.Array:
+			t = u.Elem()
+		case *types.Map:
+			t = u.Elem()
+		case *types.Basic:
+			return cfg.Types[u.Name()], nil
+		default:
+			return "", nil
+		}
+	}
+}
+
+// tagEncoding reads the encoding from a `go2proto:"encoding=sint"` struct tag.
+func tagEncoding(tag string) (Encoding, error) {
+	value, ok := reflect.StructTag(tag).Lookup(go2protoTagKey)
+	if !ok {
+		return "", nil
+	}
+	for _, option := range strings.Split(value, ",") {
+		kv := strings.SplitN(option, "=", 2)
+		if len(kv) != 2 || kv[0] != "encoding" {
+			continue
+		}
+		enc := Encoding(kv[1])
+		return enc, enc.validate()
+	}
+	return "", nil
+}
+
+// removeTagKey removes key and its value from a struct tag string.
+func removeTagKey(tag, key string) string {
+	var kept []string
+	for _, part := range splitTag(tag) {
+		if strings.HasPrefix(part, key+":") {
+			continue
+		}
+		kept = append(kept, part)
+	}
+	return strings.Join(kept, " ")
+}
+
+// splitTag splits a struct tag into its key:"value" pairs.
+func splitTag(tag string) []string {
+	var parts []string
+	tag = strings.TrimSpace(tag)
+	for tag != "" {
+		// key ends at the colon, value is a quoted string that may contain
+		// spaces and escaped quotes
+		i := strings.Index(tag, `:"`)
+		if i < 0 {
+			parts = append(parts, tag)
+			break
+		}
+		j := i + 2
+		for j < len(tag) && tag[j] != '"' {
+			if tag[j] == '\\' {
+				j++
+			}
+			j++
+		}
+		if j >= len(tag) {
+			parts = append(parts, tag)
+			break
+		}
+		parts = append(parts, tag[:j+1])
+		tag = strings.TrimSpace(tag[j+1:])
+	}
+	return parts
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"go/types"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScalarType(t *testing.T) {
+	t.Parallel()
+
+	var testCases = []struct {
+		testName      string
+		givenKind     types.BasicKind
+		givenEncoding Encoding
+		expected      string
+		expectedErr   bool
+	}{
+		{testName: "int", givenKind: types.Int, expected: "int64"},
+		{testName: "int8", givenKind: types.Int8, expected: "int32"},
+		{testName: "int16", givenKind: types.Int16, expected: "int32"},
+		{testName: "int32", givenKind: types.Int32, expected: "int32"},
+		{testName: "int64", givenKind: types.Int64, expected: "int64"},
+		{testName: "uint", givenKind: types.Uint, expected: "uint64"},
+		{testName: "uint8", givenKind: types.Uint8, expected: "uint32"},
+		{testName: "uint16", givenKind: types.Uint16, expected: "uint32"},
+		{testName: "uint32", givenKind: types.Uint32, expected: "uint32"},
+		{testName: "uint64", givenKind: types.Uint64, expected: "uint64"},
+		{testName: "uintptr", givenKind: types.Uintptr, expected: "uint64"},
+		{testName: "float32", givenKind: types.Float32, expected: "float"},
+		{testName: "float64", givenKind: types.Float64, expected: "double"},
+		{testName: "bool", givenKind: types.Bool, expected: "bool"},
+		{testName: "string", givenKind: types.String, expected: "string"},
+		{testName: "int32 sint", givenKind: types.Int32, givenEncoding: EncodingSint, expected: "sint32"},
+		{testName: "int sint", givenKind: types.Int, givenEncoding: EncodingSint, expected: "sint64"},
+		{testName: "uint32 sint is ignored", givenKind: types.Uint32, givenEncoding: EncodingSint, expected: "uint32"},
+		{testName: "uint32 fixed", givenKind: types.Uint32, givenEncoding: EncodingFixed, expected: "fixed32"},
+		{testName: "uint64 fixed", givenKind: types.Uint64, givenEncoding: EncodingFixed, expected: "fixed64"},
+		{testName: "int16 fixed", givenKind: types.Int16, givenEncoding: EncodingFixed, expected: "sfixed32"},
+		{testName: "int64 fixed", givenKind: types.Int64, givenEncoding: EncodingFixed, expected: "sfixed64"},
+		{testName: "string fixed is ignored", givenKind: types.String, givenEncoding: EncodingFixed, expected: "string"},
+		{testName: "complex64", givenKind: types.Complex64, expectedErr: true},
+		{testName: "complex128", givenKind: types.Complex128, expectedErr: true},
+		{testName: "unsafe pointer", givenKind: types.UnsafePointer, expectedErr: true},
+	}
+
+	for _, testCase := range testCases {
+		result, err := scalarType(types.Typ[testCase.givenKind], testCase.givenEncoding)
+		if testCase.expectedErr {
+			assert.Error(t, err, testCase.testName)
+			continue
+		}
+		assert.NoError(t, err, testCase.testName)
+		assert.Equal(t, testCase.expected, result, testCase.testName)
+	}
+}
+
+func TestTagEncoding(t *testing.T) {
+	t.Parallel()
+
+	var testCases = []struct {
+		testName    string
+		given       string
+		expected    Encoding
+		expectedErr bool
+	}{
+		{testName: "empty tag", given: ""},
+		{testName: "no go2proto key", given: `json:"id"`},
+		{testName: "encoding set", given: `json:"id" go2proto:"encoding=fixed"`, expected: EncodingFixed},
+		{testName: "other options", given: `go2proto:"other,encoding=sint"`, expected: EncodingSint},
+		{testName: "unknown encoding", given: `go2proto:"encoding=zigzag"`, expectedErr: true},
+	}
+
+	for _, testCase := range testCases {
+		result, err := tagEncoding(testCase.given)
+		if testCase.expectedErr {
+			assert.Error(t, err, testCase.testName)
+			continue
+		}
+		assert.NoError(t, err, testCase.testName)
+		assert.Equal(t, testCase.expected, result, testCase.testName)
+	}
+}
+
+func TestRemoveTagKey(t *testing.T) {
+	t.Parallel()
+
+	var testCases = []struct {
+		testName string
+		givenTag string
+		givenKey string
+		expected string
+	}{
+		{testName: "empty tag", givenTag: "", givenKey: "go2proto", expected: ""},
+		{testName: "key missing", givenTag: `json:"id"`, givenKey: "go2proto", expected: `json:"id"`},
+		{testName: "only key", givenTag: `go2proto:"encoding=sint"`, givenKey: "go2proto", expected: ""},
+		{testName: "key among others", givenTag: `json:"id" go2proto:"encoding=sint" db:"id"`, givenKey: "go2proto", expected: `json:"id" db:"id"`},
+		{testName: "value with spaces and quotes", givenTag: `go2proto:"a" doc:"a \"b\" c" json:"id"`, givenKey: "go2proto", expected: `doc:"a \"b\" c" json:"id"`},
+	}
+
+	for _, testCase := range testCases {
+		result := removeTagKey(testCase.givenTag, testCase.givenKey)
+		assert.Equal(t, testCase.expected, result, testCase.testName)
+	}
+}
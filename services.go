@@ -0,0 +1,245 @@
+package main
+
+import (
+	"fmt"
+	"go/token"
+	"go/types"
+	"sort"
+)
+
+// emptyTypeName is the request or response of rpcs without parameters or
+// results.
+const emptyTypeName = "google.protobuf.Empty"
+
+// service is a gRPC service generated from a Go interface.
+type service struct {
+	Name    string
+	Methods []rpc
+}
+
+// rpc is a method of a service. Request and Response are message names,
+// streamed if the method takes or returns a channel or an iterator.
+type rpc struct {
+	Name            string
+	Request         string
+	Response        string
+	ClientStreaming bool
+	ServerStreaming bool
+
+	pos token.Pos
+}
+
+// getServices returns the services generated from the interface types
+// ifaceTypes: those marked with a service directive, and those whose methods
+// all have the shape Method(ctx context.Context, req *Request) (*Response,
+// error), where the request and response may also be streams of messages.
+func getServices(ifaceTypes []types.Object, resolver *typeResolver) ([]service, error) {
+	var out []service
+	for _, t := range ifaceTypes {
+		directive := resolver.directives.Get(t)
+		if directive.Ignore {
+			continue
+		}
+		iface := t.Type().Underlying().(*types.Interface)
+		if !directive.Service && !isRPCInterface(iface) {
+			continue
+		}
+
+		svc := service{Name: resolver.directives.MessageName(t)}
+		for i := 0; i < iface.NumMethods(); i++ {
+			m := iface.Method(i)
+			if !m.Exported() {
+				continue
+			}
+			method, err := resolver.rpc(m)
+			if err != nil {
+				return nil, fmt.Errorf("%s.%s: %v", svc.Name, m.Name(), err)
+			}
+			svc.Methods = append(svc.Methods, method)
+		}
+		// methods in the order of the interface declaration
+		sort.SliceStable(svc.Methods, func(i, j int) bool { return svc.Methods[i].pos < svc.Methods[j].pos })
+		out = append(out, svc)
+	}
+	return out, nil
+}
+
+// isRPCInterface reports whether every method of iface takes a context and a
+// single message or stream of messages, and returns a single message or
+// stream of messages and an error.
+func isRPCInterface(iface *types.Interface) bool {
+	if iface.NumMethods() == 0 {
+		return false
+	}
+	for i := 0; i < iface.NumMethods(); i++ {
+		sig := iface.Method(i).Type().(*types.Signature)
+		params, results := sig.Params(), sig.Results()
+		if params.Len() != 2 || !isContext(params.At(0).Type()) {
+			return false
+		}
+		if results.Len() != 2 || !isError(results.At(1).Type()) {
+			return false
+		}
+		if !isRPCMessage(params.At(1).Type()) || !isRPCMessage(results.At(0).Type()) {
+			return false
+		}
+	}
+	return true
+}
+
+// rpc returns the rpc for interface method m. The context parameter and the
+// error result are dropped; methods taking or returning anything but a single
+// message or stream of messages get a generated request or response message
+// named after the method.
+func (r *typeResolver) rpc(m *types.Func) (rpc, error) {
+	sig := m.Type().(*types.Signature)
+	params := tupleVars(sig.Params())
+	if len(params) > 0 && isContext(params[0].Type()) {
+		params = params[1:]
+	}
+	results := tupleVars(sig.Results())
+	if len(results) > 0 && isError(results[len(results)-1].Type()) {
+		results = results[:len(results)-1]
+	}
+
+	out := rpc{Name: m.Name(), pos: m.Pos()}
+	var err error
+	out.Request, out.ClientStreaming, err = r.rpcType(m.Name()+"Request", params, "arg", m.Pos())
+	if err != nil {
+		return rpc{}, err
+	}
+	out.Response, out.ServerStreaming, err = r.rpcType(m.Name()+"Response", results, "result", m.Pos())
+	if err != nil {
+		return rpc{}, err
+	}
+	return out, nil
+}
+
+// rpcType returns the message name for the parameters or results vars of an
+// rpc, and whether it is streamed.
+func (r *typeResolver) rpcType(baseName string, vars []*types.Var, prefix string, pos token.Pos) (string, bool, error) {
+	switch len(vars) {
+	case 0:
+		return emptyTypeName, false, nil
+	case 1:
+		t := vars[0].Type()
+		if elem, ok := streamElem(t); ok {
+			if isMessage(elem) {
+				pt, err := r.resolve(elem, "", nestedRef{})
+				return pt.Name, true, err
+			}
+			name, err := r.rpcMessage(baseName, []*types.Var{types.NewVar(pos, nil, "value", elem)}, prefix, pos)
+			return name, true, err
+		}
+		if isMessage(t) {
+			pt, err := r.resolve(t, "", nestedRef{})
+			return pt.Name, false, err
+		}
+	}
+
+	for _, v := range vars {
+		if _, ok := streamElem(v.Type()); ok {
+			return "", false, fmt.Errorf("stream %s can't be combined with other parameters or results", v.Name())
+		}
+	}
+	name, err := r.rpcMessage(baseName, vars, prefix, pos)
+	return name, false, err
+}
+
+// rpcMessage generates a message with a field per var, named after the var or
+// numbered with prefix if it is unnamed, and returns its name.
+func (r *typeResolver) rpcMessage(baseName string, vars []*types.Var, prefix string, pos token.Pos) (string, error) {
+	name := uniqueName(baseName, r.generatedNames())
+	msg := message{Name: name, fullName: name, pos: pos}
+	// registered before the fields are resolved, which may generate messages
+	r.messages[name] = msg
+
+	for i, v := range vars {
+		goName := v.Name()
+		if goName == "" || goName == "_" {
+			goName = fmt.Sprintf("%s%d", prefix, i+1)
+		}
+		if _, ok := anonymousStruct(v.Type()); ok {
+			return "", fmt.Errorf("%s: anonymous struct types are not supported", goName)
+		}
+		pt, err := r.resolve(v.Type(), "", nestedRef{})
+		if err != nil {
+			return "", fmt.Errorf("%s: %v", goName, err)
+		}
+		f := field{
+			Name:       toProtoFieldName(goName, r.cfg.UseSnakeFieldNames),
+			TypeName:   pt.Name,
+			IsRepeated: pt.Repeated,
+			MapKey:     pt.MapKey,
+		}
+		if len(pt.Oneof) > 0 {
+			f.Oneof = r.oneofMembers(goName, pt.Oneof)
+		}
+		msg.Fields = append(msg.Fields, numberField(f, name, r.currProtoMessages))
+	}
+	r.messages[name] = msg
+	return name, nil
+}
+
+func tupleVars(t *types.Tuple) []*types.Var {
+	vars := make([]*types.Var, t.Len())
+	for i := range vars {
+		vars[i] = t.At(i)
+	}
+	return vars
+}
+
+func isContext(t types.Type) bool {
+	return types.TypeString(t, nil) == "context.Context"
+}
+
+func isError(t types.Type) bool {
+	return types.Identical(t, types.Universe.Lookup("error").Type())
+}
+
+// isMessage reports whether t is a struct type or a pointer to one, which is
+// generated as a message.
+func isMessage(t types.Type) bool {
+	if p, ok := t.(*types.Pointer); ok {
+		t = p.Elem()
+	}
+	if _, ok := t.(*types.Named); !ok {
+		return false
+	}
+	_, ok := t.Underlying().(*types.Struct)
+	return ok
+}
+
+// isRPCMessage reports whether t is a message or a stream of messages.
+func isRPCMessage(t types.Type) bool {
+	if elem, ok := streamElem(t); ok {
+		return isMessage(elem)
+	}
+	return isMessage(t)
+}
+
+// streamElem returns the element type of channel t, or of iterator t:
+// iter.Seq[T], iter.Seq2[T, error] or a function of the same shape.
+func streamElem(t types.Type) (types.Type, bool) {
+	switch u := t.Underlying().(type) {
+	case *types.Chan:
+		return u.Elem(), true
+	case *types.Signature:
+		if u.Params().Len() != 1 || u.Results().Len() != 0 {
+			return nil, false
+		}
+		yield, ok := u.Params().At(0).Type().Underlying().(*types.Signature)
+		if !ok || yield.Results().Len() != 1 || !types.Identical(yield.Results().At(0).Type(), types.Typ[types.Bool]) {
+			return nil, false
+		}
+		switch yield.Params().Len() {
+		case 1:
+			return yield.Params().At(0).Type(), true
+		case 2:
+			if isError(yield.Params().At(1).Type()) {
+				return yield.Params().At(0).Type(), true
+			}
+		}
+	}
+	return nil, false
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"go/types"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const servicesSource = `package p
+
+import (
+	"context"
+	"iter"
+)
+
+type GetUserRequest struct{ ID string }
+
+type User struct{ Name string }
+
+type UserService interface {
+	GetUser(ctx context.Context, req *GetUserRequest) (*User, error)
+	Watch(ctx context.Context, req *GetUserRequest) (<-chan *User, error)
+	Upload(ctx context.Context, users iter.Seq[*User]) (*User, error)
+}
+
+type Admin interface {
+	Ban(ctx context.Context, userID string, days int32) error
+	Count(ctx context.Context) (int64, error)
+	Names(ctx context.Context, ids chan string) (iter.Seq2[string, error], error)
+}
+
+type Store interface {
+	Get(ctx context.Context, id string) (*User, error)
+}
+
+type Invalid interface {
+	Send(ctx context.Context, users chan User, force bool) error
+}
+`
+
+func TestGetServices(t *testing.T) {
+	t.Parallel()
+
+	pkg, structTypes := checkSource(t, servicesSource)
+	lookup := func(names ...string) []types.Object {
+		var objs []types.Object
+		for _, name := range names {
+			objs = append(objs, pkg.Scope().Lookup(name))
+		}
+		return objs
+	}
+
+	var testCases = []struct {
+		testName         string
+		givenIfaces      []types.Object
+		givenDirectives  DirectiveMap
+		expected         []service
+		expectedMessages []message
+	}{
+		{
+			testName:    "discovered",
+			givenIfaces: lookup("Store", "UserService"),
+			expected: []service{
+				{
+					Name: "UserService",
+					Methods: []rpc{
+						{Name: "GetUser", Request: "GetUserRequest", Response: "User"},
+						{Name: "Watch", Request: "GetUserRequest", Response: "User", ServerStreaming: true},
+						{Name: "Upload", Request: "User", Response: "User", ClientStreaming: true},
+					},
+				},
+			},
+		},
+		{
+			testName:        "directive",
+			givenIfaces:     lookup("Admin"),
+			givenDirectives: DirectiveMap{pkg.Scope().Lookup("Admin"): {Service: true}},
+			expected: []service{
+				{
+					Name: "Admin",
+					Methods: []rpc{
+						{Name: "Ban", Request: "BanRequest", Response: "google.protobuf.Empty"},
+						{Name: "Count", Request: "google.protobuf.Empty", Response: "CountResponse"},
+						{Name: "Names", Request: "NamesRequest", Response: "NamesResponse", ClientStreaming: true, ServerStreaming: true},
+					},
+				},
+			},
+			expectedMessages: []message{
+				{Name: "BanRequest", Fields: []field{
+					{Name: "userID", TypeName: "string", Order: 1},
+					{Name: "days", TypeName: "int32", Order: 2},
+				}},
+				{Name: "CountResponse", Fields: []field{{Name: "result1", TypeName: "int64", Order: 1}}},
+				{Name: "NamesRequest", Fields: []field{{Name: "value", TypeName: "string", Order: 1}}},
+				{Name: "NamesResponse", Fields: []field{{Name: "value", TypeName: "string", Order: 1}}},
+			},
+		},
+	}
+
+	for _, testCase := range testCases {
+		directives := testCase.givenDirectives
+		if directives == nil {
+			directives = DirectiveMap{}
+		}
+		resolver := newTypeResolver(Config{}, directives, structTypes, ProtoMessageMap{})
+		result, err := getServices(testCase.givenIfaces, resolver)
+		assert.NoError(t, err, testCase.testName)
+
+		// positions are only used for ordering
+		for i := range result {
+			for j := range result[i].Methods {
+				result[i].Methods[j].pos = 0
+			}
+		}
+		assert.Equal(t, testCase.expected, result, testCase.testName)
+
+		var messages []message
+		for _, msg := range resolver.Messages() {
+			messages = append(messages, message{Name: msg.Name, Fields: msg.Fields})
+		}
+		assert.Equal(t, testCase.expectedMessages, messages, testCase.testName)
+	}
+}
+
+func TestGetServicesInvalid(t *testing.T) {
+	t.Parallel()
+
+	pkg, structTypes := checkSource(t, servicesSource)
+	invalid := pkg.Scope().Lookup("Invalid")
+
+	resolver := newTypeResolver(Config{}, DirectiveMap{invalid: {Service: true}}, structTypes, ProtoMessageMap{})
+	_, err := getServices([]types.Object{invalid}, resolver)
+	assert.EqualError(t, err, "Invalid.Send: stream users can't be combined with other parameters or results")
+}
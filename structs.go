@@ -0,0 +1,547 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/emicklei/proto"
+
+	gostringconverters "github.com/emarcey/go-string-converters"
+)
+
+// goWellKnownTypes maps the well-known types to Go types. Timestamp and Any
+// generate back to themselves, the others get a type directive; those missing
+// here are any with a type directive.
+var goWellKnownTypes = map[string]string{
+	timestampTypeName:             "time.Time",
+	anyTypeName:                   "any",
+	"google.protobuf.Duration":    "time.Duration",
+	"google.protobuf.Struct":      "map[string]any",
+	"google.protobuf.Value":       "any",
+	"google.protobuf.DoubleValue": "*float64",
+	"google.protobuf.FloatValue":  "*float32",
+	"google.protobuf.Int64Value":  "*int64",
+	"google.protobuf.UInt64Value": "*uint64",
+	"google.protobuf.Int32Value":  "*int32",
+	"google.protobuf.UInt32Value": "*uint32",
+	"google.protobuf.BoolValue":   "*bool",
+	"google.protobuf.StringValue": "*string",
+	"google.protobuf.BytesValue":  "[]byte",
+}
+
+// protoEncodings are the encodings of the proto scalars other than varints.
+var protoEncodings = map[string]Encoding{
+	"sint32":   EncodingSint,
+	"sint64":   EncodingSint,
+	"fixed32":  EncodingFixed,
+	"fixed64":  EncodingFixed,
+	"sfixed32": EncodingFixed,
+	"sfixed64": EncodingFixed,
+}
+
+// structGen writes plain Go structs from the messages and enums of a proto
+// file, with the directives generating the same proto back. What Go structs
+// can't express, like enums and nested messages, is recorded as losses.
+type structGen struct {
+	pkg    string
+	syntax Syntax
+	// tagged is set when the proto imports tagger, whose (tagger.tags)
+	// options then hold all the struct tags
+	tagged bool
+	// explicitPresence is the field presence of editions without a field
+	// option
+	explicitPresence bool
+	snakeFieldNames  bool
+
+	// goNames are the Go type names of the messages and enums, keyed by full
+	// name; taken are the Go identifiers of the file
+	goNames map[string]string
+	enums   map[string]struct{}
+	taken   map[string]struct{}
+	used    map[string]struct{}
+	losses  []string
+}
+
+// generateStructs returns a Go file of package pkg with a struct for every
+// message of the proto src and a named int32 for every enum, and the losses
+// of generating the proto from it again. Field names are compared to the
+// snake_case names of -s when snakeFieldNames is set.
+func generateStructs(src []byte, pkg string, snakeFieldNames bool) ([]byte, []string, error) {
+	definition, err := proto.NewParser(bytes.NewReader(src)).Parse()
+	if err != nil {
+		return nil, nil, err
+	}
+	g := &structGen{
+		syntax:           SyntaxProto2,
+		explicitPresence: true,
+		snakeFieldNames:  snakeFieldNames,
+		goNames:          make(map[string]string),
+		enums:            make(map[string]struct{}),
+		taken:            make(map[string]struct{}),
+		used:             make(map[string]struct{}),
+	}
+	for _, element := range definition.Elements {
+		switch e := element.(type) {
+		case *proto.Syntax:
+			g.syntax = Syntax(e.Value)
+		case *proto.Edition:
+			g.syntax = Syntax(e.Value)
+		case *proto.Package:
+			g.pkg = e.Name
+		case *proto.Import:
+			g.tagged = g.tagged || e.Filename == taggerImport
+		case *proto.Option:
+			if e.Name == fieldPresenceFeature {
+				g.explicitPresence = e.Constant.Source != "IMPLICIT"
+			}
+		case *proto.Service:
+			g.lose(e.Name, "service left out")
+		}
+	}
+	g.name("", definition.Elements, true)
+	g.name("", definition.Elements, false)
+
+	var body bytes.Buffer
+	if err := g.write(&body, "", definition.Elements); err != nil {
+		return nil, nil, err
+	}
+
+	var out bytes.Buffer
+	fmt.Fprintf(&out, "// Code generated by go2proto. DO NOT EDIT.\n\npackage %s\n", pkg)
+	if _, ok := g.used["time"]; ok {
+		out.WriteString("\nimport \"time\"\n")
+	}
+	out.Write(body.Bytes())
+	formatted, err := format.Source(out.Bytes())
+	if err != nil {
+		return nil, nil, fmt.Errorf("formatting %s: %v", pkg, err)
+	}
+	return formatted, g.losses, nil
+}
+
+// runStructs runs the structs subcommand, writing the Go structs of a proto.
+func runStructs(args []string) int {
+	flags := newFlagSet("structs", "-c <proto> -o <go file> [flags]",
+		"Writes a Go struct per message of the proto, logging what the structs can't express.")
+	protoFileName := flags.String("c", "", "Full filepath of the proto to generate Go structs from. Required.")
+	goFileName := flags.String("o", "", "Full filepath of the Go file to write. Required.")
+	pkg := flags.String("package", "", "Go package name. Default is the name of the directory of the Go file.")
+	snakeFieldNames := flags.Bool("s", false, "Use if the proto field names are snake_case, as generated with -s.")
+	if code, ok := parseFlags(flags, args); !ok {
+		return code
+	}
+
+	if *protoFileName == "" || *goFileName == "" {
+		flags.Usage()
+		return exitError
+	}
+	if *pkg == "" {
+		dir, err := filepath.Abs(filepath.Dir(*goFileName))
+		if err != nil {
+			return fail(err)
+		}
+		*pkg = filepath.Base(dir)
+	}
+
+	losses, err := writeStructs(*protoFileName, *goFileName, *pkg, *snakeFieldNames)
+	if err != nil {
+		return fail(err)
+	}
+	for _, loss := range losses {
+		log.Print(loss)
+	}
+	return exitOK
+}
+
+// writeStructs writes the Go structs of the proto protoFileName to
+// goFileName and returns the losses.
+func writeStructs(protoFileName, goFileName, pkg string, snakeFieldNames bool) ([]string, error) {
+	src, err := ioutil.ReadFile(protoFileName)
+	if err != nil {
+		return nil, err
+	}
+	out, losses, err := generateStructs(src, pkg, snakeFieldNames)
+	if err != nil {
+		return nil, err
+	}
+	return losses, ioutil.WriteFile(goFileName, out, 0644)
+}
+
+func (g *structGen) lose(path, reason string) {
+	g.losses = append(g.losses, path+": "+reason)
+}
+
+// name names the Go types of the top-level messages and enums of elements,
+// or of the nested ones, which are named after their full name like
+// OrderLine, so that top-level types keep their name.
+func (g *structGen) name(scope string, elements []proto.Visitee, topLevel bool) {
+	add := func(name string) {
+		fullName := qualify(scope, name)
+		if (scope == "") == topLevel {
+			g.goNames[fullName] = uniqueName(wrapperBaseName(fullName), g.taken)
+			g.taken[g.goNames[fullName]] = struct{}{}
+		}
+	}
+	for _, element := range elements {
+		switch e := element.(type) {
+		case *proto.Message:
+			if e.IsExtend {
+				continue
+			}
+			add(e.Name)
+			if !topLevel {
+				g.name(qualify(scope, e.Name), e.Elements, false)
+			}
+		case *proto.Enum:
+			add(e.Name)
+			g.enums[qualify(scope, e.Name)] = struct{}{}
+		}
+	}
+}
+
+// write writes the types of the messages and enums of elements, declared in
+// scope, each message followed by its nested types.
+func (g *structGen) write(out *bytes.Buffer, scope string, elements []proto.Visitee) error {
+	for _, element := range elements {
+		switch e := element.(type) {
+		case *proto.Message:
+			if e.IsExtend {
+				continue
+			}
+			if err := g.message(out, qualify(scope, e.Name), e); err != nil {
+				return err
+			}
+			if err := g.write(out, qualify(scope, e.Name), e.Elements); err != nil {
+				return err
+			}
+		case *proto.Enum:
+			g.enum(out, qualify(scope, e.Name), e)
+		}
+	}
+	return nil
+}
+
+func (g *structGen) message(out *bytes.Buffer, fullName string, msg *proto.Message) error {
+	goName := g.goNames[fullName]
+	if strings.Contains(fullName, ".") {
+		g.lose(fullName, "nested message becomes "+goName)
+	}
+	fieldNames := make(map[string]struct{})
+	var fields, after bytes.Buffer
+	for _, element := range msg.Elements {
+		var err error
+		switch e := element.(type) {
+		case *proto.NormalField:
+			err = g.normalField(&fields, fullName, fieldNames, e)
+		case *proto.MapField:
+			err = g.mapField(&fields, fullName, fieldNames, e)
+		case *proto.Oneof:
+			err = g.oneof(&fields, &after, fullName, fieldNames, e)
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %v", fullName, err)
+		}
+	}
+
+	out.WriteString("\n")
+	writeComment(out, msg.Comment)
+	fmt.Fprintf(out, "type %s struct {\n", goName)
+	out.Write(fields.Bytes())
+	out.WriteString("}\n")
+	out.Write(after.Bytes())
+	return nil
+}
+
+// goField is a field of a generated struct.
+type goField struct {
+	protoName  string
+	typeName   string
+	directives []string
+	tag        string
+	comment    *proto.Comment
+}
+
+// writeField writes f as a field of the struct, named after its proto name and
+// made unique against the other fields of the struct, taken.
+func (g *structGen) writeField(out *bytes.Buffer, f goField, taken map[string]struct{}) {
+	goName := uniqueName(goFieldName(f.protoName), taken)
+	taken[goName] = struct{}{}
+	directives := f.directives
+	if toProtoFieldName(goName, g.snakeFieldNames) != f.protoName {
+		directives = append([]string{"name=" + f.protoName}, directives...)
+	}
+
+	writeComment(out, f.comment)
+	if len(directives) > 0 {
+		fmt.Fprintf(out, "%s%s\n", directivePrefix, strings.Join(directives, " "))
+	}
+	fmt.Fprintf(out, "%s %s", goName, f.typeName)
+	if f.tag != "" {
+		if strings.Contains(f.tag, "`") {
+			fmt.Fprintf(out, " %s", strconv.Quote(f.tag))
+		} else {
+			fmt.Fprintf(out, " `%s`", f.tag)
+		}
+	}
+	out.WriteString("\n")
+}
+
+func (g *structGen) normalField(out *bytes.Buffer, scope string, taken map[string]struct{}, f *proto.NormalField) error {
+	path := scope + "." + f.Name
+	typeName, directives, err := g.goType(path, f.Type, scope)
+	if err != nil {
+		return err
+	}
+	_, isEnum := g.enums[g.resolve(scope, f.Type)]
+
+	presence := g.explicitPresence
+	required := f.Required
+	for _, option := range f.Options {
+		switch option.Name {
+		case fieldPresenceFeature:
+			presence = option.Constant.Source != "IMPLICIT"
+			required = option.Constant.Source == "LEGACY_REQUIRED"
+		case defaultOption:
+			switch {
+			case isEnum:
+				g.lose(path, "default of an enum field left out")
+			case strings.ContainsAny(option.Constant.Source, " \t"):
+				g.lose(path, "default with spaces left out")
+			default:
+				directives = append(directives, "default="+option.Constant.Source)
+			}
+		}
+	}
+	if required {
+		directives = append(directives, "required")
+	}
+
+	switch {
+	case f.Repeated:
+		typeName = "[]" + typeName
+		if len(directives) > 0 && strings.HasPrefix(directives[0], "type=") {
+			directives[0] = "type=repeated " + strings.TrimPrefix(directives[0], "type=")
+		}
+	case f.Optional && g.syntax.isProto3() && isScalarType(f.Type):
+		typeName = "*" + typeName
+		g.lose(path, "proto3 optional becomes implicit presence")
+	case g.syntax.isEdition() && presence && !required && isScalarType(f.Type):
+		typeName = "*" + typeName
+	}
+
+	g.writeField(out, goField{
+		protoName:  f.Name,
+		typeName:   typeName,
+		directives: directives,
+		tag:        g.tag(f.Field),
+		comment:    f.Comment,
+	}, taken)
+	return nil
+}
+
+func (g *structGen) mapField(out *bytes.Buffer, scope string, taken map[string]struct{}, f *proto.MapField) error {
+	path := scope + "." + f.Name
+	keyType, keyDirectives, err := g.goType(path, f.KeyType, scope)
+	if err != nil {
+		return err
+	}
+	valueType, directives, err := g.goType(path, f.Type, scope)
+	if err != nil {
+		return err
+	}
+	// a type directive would replace the whole map
+	if len(directives) > 0 && strings.HasPrefix(directives[0], "type=") {
+		g.lose(path, "map values of "+f.Type+" become "+valueType)
+		directives = nil
+	}
+	if len(directives) == 0 {
+		directives = keyDirectives
+	}
+	g.writeField(out, goField{
+		protoName:  f.Name,
+		typeName:   fmt.Sprintf("map[%s]%s", keyType, valueType),
+		directives: directives,
+		tag:        g.tag(f.Field),
+		comment:    f.Comment,
+	}, taken)
+	return nil
+}
+
+// oneof writes a oneof whose members are top-level messages named after the
+// oneof and their type, as go2proto generates them, as an interface field
+// implemented by the members. Other oneofs become a field per member.
+func (g *structGen) oneof(out, after *bytes.Buffer, scope string, taken map[string]struct{}, o *proto.Oneof) error {
+	goName := goFieldName(o.Name)
+	var members []*proto.OneOfField
+	asInterface := true
+	for _, element := range o.Elements {
+		member, ok := element.(*proto.OneOfField)
+		if !ok {
+			continue
+		}
+		members = append(members, member)
+		typeName := g.resolve(scope, member.Type)
+		_, isEnum := g.enums[typeName]
+		memberGoName, defined := g.goNames[typeName]
+		if !defined || isEnum || strings.Contains(typeName, ".") || toProtoFieldName(goName+memberGoName, g.snakeFieldNames) != member.Name {
+			asInterface = false
+		}
+	}
+
+	if !asInterface || len(members) == 0 {
+		g.lose(scope+"."+o.Name, "oneof becomes a field per member")
+		for _, member := range members {
+			typeName, directives, err := g.goType(scope+"."+member.Name, member.Type, scope)
+			if err != nil {
+				return err
+			}
+			if !strings.HasPrefix(typeName, "*") && isScalarType(member.Type) {
+				typeName = "*" + typeName
+			}
+			g.writeField(out, goField{
+				protoName:  member.Name,
+				typeName:   typeName,
+				directives: directives,
+				tag:        g.tag(member.Field),
+				comment:    member.Comment,
+			}, taken)
+		}
+		return nil
+	}
+
+	ifaceName := uniqueName(g.goNames[scope]+goName, g.taken)
+	g.taken[ifaceName] = struct{}{}
+	var tag string
+	if !g.tagged {
+		tag = fmt.Sprintf(`json:"%s,omitempty"`, jsonCamelCase(o.Name))
+	}
+	g.writeField(out, goField{protoName: o.Name, typeName: ifaceName, tag: tag, comment: o.Comment}, taken)
+
+	fmt.Fprintf(after, "\n// %s is the %s oneof of %s.\ntype %s interface {\nis%s()\n}\n", ifaceName, o.Name, g.goNames[scope], ifaceName, ifaceName)
+	for _, member := range members {
+		fmt.Fprintf(after, "\nfunc (*%s) is%s() {}\n", g.goNames[g.resolve(scope, member.Type)], ifaceName)
+	}
+	return nil
+}
+
+// goType returns the Go type of the singular proto type typeName referred to
+// from message scope, and the directives generating it back. Messages are
+// pointers, enums named int32s which generate int32.
+func (g *structGen) goType(path, typeName, scope string) (string, []string, error) {
+	if goType, ok := protoGoScalars[typeName]; ok {
+		if enc, ok := protoEncodings[typeName]; ok {
+			return goType, []string{"encoding=" + string(enc)}, nil
+		}
+		return goType, nil, nil
+	}
+
+	wellKnown := strings.TrimPrefix(typeName, ".")
+	if strings.HasPrefix(wellKnown, "google.protobuf.") {
+		goType, ok := goWellKnownTypes[wellKnown]
+		if !ok {
+			goType = "any"
+		}
+		if strings.HasPrefix(goType, "time.") {
+			g.used["time"] = struct{}{}
+		}
+		if wellKnown == timestampTypeName || wellKnown == anyTypeName {
+			return goType, nil, nil
+		}
+		return goType, []string{"type=" + wellKnown}, nil
+	}
+
+	fullName := g.resolve(scope, typeName)
+	goName, ok := g.goNames[fullName]
+	if !ok {
+		return "", nil, fmt.Errorf("unknown type %s", typeName)
+	}
+	if _, ok := g.enums[fullName]; ok {
+		g.lose(path, "enum "+typeName+" becomes int32")
+		return goName, nil, nil
+	}
+	return "*" + goName, nil, nil
+}
+
+// resolve returns the full name of the message or enum typeName referred to
+// from message scope, see resolveName.
+func (g *structGen) resolve(scope, typeName string) string {
+	typeName = strings.TrimPrefix(typeName, ".")
+	if g.pkg != "" {
+		typeName = strings.TrimPrefix(typeName, g.pkg+".")
+	}
+	name, _ := resolveName(scope, typeName, func(name string) bool {
+		_, ok := g.goNames[name]
+		return ok
+	})
+	return name
+}
+
+// tag returns the struct tag of f: its (tagger.tags) when the proto uses
+// tagger, a json tag with its JSON name otherwise.
+func (g *structGen) tag(f *proto.Field) string {
+	jsonName := jsonCamelCase(f.Name)
+	for _, option := range f.Options {
+		switch option.Name {
+		case taggerOption:
+			if tag, err := strconv.Unquote(`"` + option.Constant.Source + `"`); err == nil && g.tagged {
+				return tag
+			}
+		case "json_name":
+			jsonName = option.Constant.Source
+		}
+	}
+	if g.tagged {
+		return ""
+	}
+	return fmt.Sprintf(`json:"%s,omitempty"`, jsonName)
+}
+
+func (g *structGen) enum(out *bytes.Buffer, fullName string, enum *proto.Enum) {
+	goName := g.goNames[fullName]
+	prefix := strings.ToUpper(gostringconverters.SnakeCase(enum.Name)) + "_"
+
+	out.WriteString("\n")
+	writeComment(out, enum.Comment)
+	fmt.Fprintf(out, "type %s int32\n\nconst (\n", goName)
+	for _, element := range enum.Elements {
+		value, ok := element.(*proto.EnumField)
+		if !ok {
+			continue
+		}
+		name := uniqueName(goName+goFieldName(strings.ToLower(strings.TrimPrefix(value.Name, prefix))), g.taken)
+		g.taken[name] = struct{}{}
+		fmt.Fprintf(out, "%s %s = %d\n", name, goName, value.Integer)
+	}
+	out.WriteString(")\n")
+}
+
+// goFieldName returns the exported Go name of a proto name, capitalizing its
+// underscore-separated parts, like UserId for user_id and EventID for
+// eventID.
+func goFieldName(name string) string {
+	var b strings.Builder
+	for _, part := range strings.Split(name, "_") {
+		if part != "" {
+			b.WriteString(wrapperBaseName(part))
+		}
+	}
+	if b.Len() == 0 {
+		return "X"
+	}
+	return b.String()
+}
+
+func writeComment(out *bytes.Buffer, comment *proto.Comment) {
+	if comment == nil {
+		return
+	}
+	for _, line := range comment.Lines {
+		fmt.Fprintf(out, "//%s\n", line)
+	}
+}
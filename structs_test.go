@@ -0,0 +1,244 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/tools/go/packages"
+)
+
+func TestGenerateStructs(t *testing.T) {
+	t.Parallel()
+
+	var testCases = []struct {
+		testName       string
+		given          string
+		expected       string
+		expectedLosses []string
+	}{
+		{
+			testName: "json tags and well-known types",
+			given: `syntax = "proto3";
+package shop;
+
+import "google/protobuf/timestamp.proto";
+import "google/protobuf/duration.proto";
+
+// Order is an order.
+message Order {
+  string id = 1;
+  repeated string line_ids = 2 [json_name = "lines"];
+  sint64 total = 3;
+  map<string, Line> lines = 4;
+  google.protobuf.Timestamp created_at = 5;
+  google.protobuf.Duration ttl = 6;
+  optional int32 rank = 7;
+}
+
+message Line {
+  bytes sku = 1;
+}
+`,
+			expected: `// Code generated by go2proto. DO NOT EDIT.
+
+package shop
+
+import "time"
+
+// Order is an order.
+type Order struct {
+	Id string ` + "`json:\"id,omitempty\"`" + `
+	//go2proto:name=line_ids
+	LineIds []string ` + "`json:\"lines,omitempty\"`" + `
+	//go2proto:encoding=sint
+	Total int64            ` + "`json:\"total,omitempty\"`" + `
+	Lines map[string]*Line ` + "`json:\"lines,omitempty\"`" + `
+	//go2proto:name=created_at
+	CreatedAt time.Time ` + "`json:\"createdAt,omitempty\"`" + `
+	//go2proto:type=google.protobuf.Duration
+	Ttl  time.Duration ` + "`json:\"ttl,omitempty\"`" + `
+	Rank *int32        ` + "`json:\"rank,omitempty\"`" + `
+}
+
+type Line struct {
+	Sku []byte ` + "`json:\"sku,omitempty\"`" + `
+}
+`,
+			expectedLosses: []string{"Order.rank: proto3 optional becomes implicit presence"},
+		},
+		{
+			testName: "tagger tags, enums and nested messages",
+			given: `syntax = "proto2";
+
+import "tagger/tagger.proto";
+
+message Order {
+  required string id = 1 [(tagger.tags) = "json:\"id\" db:\"order_id\""];
+  optional int32 qty = 2 [default = 1];
+  optional Status status = 3;
+  repeated Line lines = 4;
+  message Line {
+    optional string sku = 1;
+  }
+}
+
+enum Status {
+  STATUS_UNKNOWN = 0;
+  STATUS_IN_PROGRESS = 1;
+}
+`,
+			expected: `// Code generated by go2proto. DO NOT EDIT.
+
+package shop
+
+type Order struct {
+	//go2proto:required
+	Id string ` + "`json:\"id\" db:\"order_id\"`" + `
+	//go2proto:default=1
+	Qty    int32
+	Status Status
+	Lines  []*OrderLine
+}
+
+type OrderLine struct {
+	Sku string
+}
+
+type Status int32
+
+const (
+	StatusUnknown    Status = 0
+	StatusInProgress Status = 1
+)
+`,
+			expectedLosses: []string{"Order.status: enum Status becomes int32", "Order.Line: nested message becomes OrderLine"},
+		},
+		{
+			testName: "oneofs",
+			given: `edition = "2023";
+
+option features.field_presence = IMPLICIT;
+
+message Drawing {
+  oneof shape {
+    Circle shapeCircle = 1;
+    Square shapeSquare = 2;
+  }
+  oneof label {
+    string text = 3;
+    Circle icon = 4;
+  }
+  int32 width = 5 [features.field_presence = EXPLICIT];
+}
+
+message Circle {}
+
+message Square {}
+`,
+			expected: `// Code generated by go2proto. DO NOT EDIT.
+
+package shop
+
+type Drawing struct {
+	Shape DrawingShape ` + "`json:\"shape,omitempty\"`" + `
+	Text  *string      ` + "`json:\"text,omitempty\"`" + `
+	Icon  *Circle      ` + "`json:\"icon,omitempty\"`" + `
+	Width *int32       ` + "`json:\"width,omitempty\"`" + `
+}
+
+// DrawingShape is the shape oneof of Drawing.
+type DrawingShape interface {
+	isDrawingShape()
+}
+
+func (*Circle) isDrawingShape() {}
+
+func (*Square) isDrawingShape() {}
+
+type Circle struct {
+}
+
+type Square struct {
+}
+`,
+			expectedLosses: []string{"Drawing.label: oneof becomes a field per member"},
+		},
+	}
+
+	for _, testCase := range testCases {
+		result, losses, err := generateStructs([]byte(testCase.given), "shop", false)
+		assert.NoError(t, err, testCase.testName)
+		assert.Equal(t, testCase.expected, string(result), testCase.testName)
+		assert.Equal(t, testCase.expectedLosses, losses, testCase.testName)
+	}
+}
+
+func TestGenerateStructs_RoundTrip(t *testing.T) {
+	t.Parallel()
+
+	given := `syntax = "proto3";
+package proto;
+
+import "google/protobuf/duration.proto";
+import "google/protobuf/timestamp.proto";
+import "google/protobuf/wrappers.proto";
+import "tagger/tagger.proto";
+
+
+//easyjson:json
+message Circle {
+  double radius = 1;
+}
+
+//easyjson:json
+message Order {
+  string id = 1 [(tagger.tags) = "json:\"id\""]; 
+  repeated string line_ids = 2;
+  fixed64 hash = 3;
+  map<string, int64> counts = 4;
+  google.protobuf.Timestamp created_at = 5;
+  google.protobuf.Duration ttl = 6;
+  google.protobuf.StringValue note = 7;
+  oneof shape {
+    Circle shapeCircle = 8;
+    Square shapeSquare = 9;
+  }
+  repeated google.protobuf.Duration delays = 10;
+}
+
+//easyjson:json
+message Square {
+  double side = 1;
+}
+
+`
+	src, _, err := generateStructs([]byte(given), "p", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pkgs := []*packages.Package{checkPackage(t, string(src))}
+
+	dir := t.TempDir()
+	currProtoFileName := filepath.Join(dir, "current.proto")
+	if err := os.WriteFile(currProtoFileName, []byte(given), 0644); err != nil {
+		t.Fatal(err)
+	}
+	currProtoMessages, err := BuildCurrentProtoMap(currProtoFileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	directives, err := BuildDirectiveMap(pkgs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msgs, services, err := getMessages(pkgs, Config{}, currProtoMessages, directives)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := renderOutput(msgs, services, "")
+	assert.NoError(t, err)
+	assert.Equal(t, given, string(result))
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"go/types"
+	"strconv"
+	"strings"
+)
+
+// Syntax is the syntax of the output proto: proto3, the default, proto2 or
+// the edition 2023.
+type Syntax string
+
+const (
+	SyntaxProto3      Syntax = "proto3"
+	SyntaxProto2      Syntax = "proto2"
+	SyntaxEdition2023 Syntax = "2023"
+)
+
+const (
+	fieldPresenceFeature = "features.field_presence"
+	defaultOption        = "default"
+)
+
+func (s Syntax) validate() error {
+	switch s {
+	case "", SyntaxProto3, SyntaxProto2, SyntaxEdition2023:
+		return nil
+	}
+	return fmt.Errorf("unknown syntax %q, expected %s, %s or %s", s, SyntaxProto3, SyntaxProto2, SyntaxEdition2023)
+}
+
+func (s Syntax) isProto3() bool {
+	return s == "" || s == SyntaxProto3
+}
+
+func (s Syntax) isEdition() bool {
+	return s == SyntaxEdition2023
+}
+
+// Statement returns the syntax or edition statement starting the file.
+func (s Syntax) Statement() string {
+	switch {
+	case s.isEdition():
+		return fmt.Sprintf("edition = %q;", string(s))
+	case s.isProto3():
+		return fmt.Sprintf("syntax = %q;", string(SyntaxProto3))
+	}
+	return fmt.Sprintf("syntax = %q;", string(s))
+}
+
+// FileOptions returns the file options the syntax needs. Editions default to
+// explicit presence, which proto3 only has for messages; the file defaults to
+// implicit presence so that only pointer fields have it, see withPresence.
+func (s Syntax) FileOptions() []string {
+	if s.isEdition() {
+		return []string{fieldPresenceFeature + " = IMPLICIT"}
+	}
+	return nil
+}
+
+// applySyntax sets the labels and presence options of the fields of msgs for
+// the syntax.
+func applySyntax(msgs []message, syntax Syntax) ([]message, error) {
+	out := make([]message, len(msgs))
+	for i, msg := range msgs {
+		fields := make([]field, len(msg.Fields))
+		for j, f := range msg.Fields {
+			var err error
+			fields[j], err = withPresence(f, syntax)
+			if err != nil {
+				return nil, fmt.Errorf("%s.%s: %v", msg.fullName, f.Name, err)
+			}
+		}
+		nested, err := applySyntax(msg.Nested, syntax)
+		if err != nil {
+			return nil, err
+		}
+		msg.Fields, msg.Nested = fields, nested
+		out[i] = msg
+	}
+	return out, nil
+}
+
+// withPresence sets the label and presence options of f. In proto2 singular
+// fields are optional, or required with the required directive. In editions
+// they have implicit presence like in proto3, except for pointers, required
+// fields and fields with a default, which have explicit presence. Messages
+// always have explicit presence, and repeated fields, maps and oneofs none.
+func withPresence(f field, syntax Syntax) (field, error) {
+	if len(f.Oneof) > 0 {
+		return f, nil
+	}
+	singular := !f.IsRepeated && f.MapKey == ""
+	switch {
+	case syntax.isProto3() && f.required:
+		return f, fmt.Errorf("required fields need the proto2 syntax or an edition")
+	case syntax.isProto3() && f.defaultValue != "":
+		return f, fmt.Errorf("default values need the proto2 syntax or an edition")
+	case f.required && !singular:
+		return f, fmt.Errorf("repeated fields and maps can't be required")
+	case f.defaultValue != "" && (!singular || !isScalarType(f.TypeName)):
+		return f, fmt.Errorf("only singular scalar fields can have a default value")
+	}
+
+	f.Label, f.presenceOptions = "", nil
+	if !singular {
+		return f, nil
+	}
+	switch {
+	case syntax == SyntaxProto2 && f.required:
+		f.Label = "required"
+	case syntax == SyntaxProto2:
+		f.Label = "optional"
+	case syntax.isEdition() && f.required:
+		f.presenceOptions = append(f.presenceOptions, fieldPresenceFeature+" = LEGACY_REQUIRED")
+	case syntax.isEdition() && isScalarType(f.TypeName) && (isPointer(f.goType) || f.defaultValue != ""):
+		f.presenceOptions = append(f.presenceOptions, fieldPresenceFeature+" = EXPLICIT")
+	}
+	if f.defaultValue != "" {
+		f.presenceOptions = append(f.presenceOptions, fmt.Sprintf("%s = %s", defaultOption, defaultLiteral(f.TypeName, f.defaultValue)))
+	}
+	return f, nil
+}
+
+// defaultLiteral returns the default option value of a field of the scalar
+// type, quoting strings and bytes unless they already are.
+func defaultLiteral(typeName, value string) string {
+	if typeName != "string" && typeName != "bytes" || strings.HasPrefix(value, `"`) {
+		return value
+	}
+	return strconv.Quote(value)
+}
+
+func isScalarType(typeName string) bool {
+	_, ok := protoScalarTypes[typeName]
+	return ok
+}
+
+func isPointer(t types.Type) bool {
+	if t == nil {
+		return false
+	}
+	_, ok := t.Underlying().(*types.Pointer)
+	return ok
+}
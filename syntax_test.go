@@ -0,0 +1,110 @@
+package main
+
+import (
+	"go/types"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWithPresence(t *testing.T) {
+	t.Parallel()
+
+	pointer := types.NewPointer(types.Typ[types.Int32])
+
+	var testCases = []struct {
+		testName        string
+		givenSyntax     Syntax
+		givenField      field
+		expectedLabel   string
+		expectedOptions []string
+		expectedError   string
+	}{
+		{
+			testName:    "proto3 keeps fields as they are",
+			givenSyntax: "",
+			givenField:  field{Name: "count", TypeName: "int32", goType: pointer},
+		},
+		{
+			testName:      "proto3 required",
+			givenSyntax:   SyntaxProto3,
+			givenField:    field{Name: "count", TypeName: "int32", required: true},
+			expectedError: "required fields need the proto2 syntax or an edition",
+		},
+		{
+			testName:      "proto3 default",
+			givenSyntax:   SyntaxProto3,
+			givenField:    field{Name: "count", TypeName: "int32", defaultValue: "1"},
+			expectedError: "default values need the proto2 syntax or an edition",
+		},
+		{
+			testName:      "proto2 optional",
+			givenSyntax:   SyntaxProto2,
+			givenField:    field{Name: "meta", TypeName: "Meta"},
+			expectedLabel: "optional",
+		},
+		{
+			testName:        "proto2 required with default",
+			givenSyntax:     SyntaxProto2,
+			givenField:      field{Name: "status", TypeName: "string", required: true, defaultValue: "new"},
+			expectedLabel:   "required",
+			expectedOptions: []string{`default = "new"`},
+		},
+		{
+			testName:    "proto2 repeated",
+			givenSyntax: SyntaxProto2,
+			givenField:  field{Name: "ids", TypeName: "string", IsRepeated: true},
+		},
+		{
+			testName:    "edition value",
+			givenSyntax: SyntaxEdition2023,
+			givenField:  field{Name: "count", TypeName: "int32"},
+		},
+		{
+			testName:        "edition pointer",
+			givenSyntax:     SyntaxEdition2023,
+			givenField:      field{Name: "count", TypeName: "int32", goType: pointer},
+			expectedOptions: []string{"features.field_presence = EXPLICIT"},
+		},
+		{
+			testName:        "edition default",
+			givenSyntax:     SyntaxEdition2023,
+			givenField:      field{Name: "count", TypeName: "int32", defaultValue: "3"},
+			expectedOptions: []string{"features.field_presence = EXPLICIT", "default = 3"},
+		},
+		{
+			testName:        "edition required message",
+			givenSyntax:     SyntaxEdition2023,
+			givenField:      field{Name: "meta", TypeName: "Meta", required: true},
+			expectedOptions: []string{"features.field_presence = LEGACY_REQUIRED"},
+		},
+		{
+			testName:    "edition pointer to message",
+			givenSyntax: SyntaxEdition2023,
+			givenField:  field{Name: "meta", TypeName: "Meta", goType: types.NewPointer(types.NewStruct(nil, nil))},
+		},
+		{
+			testName:      "default on message",
+			givenSyntax:   SyntaxProto2,
+			givenField:    field{Name: "meta", TypeName: "Meta", defaultValue: "x"},
+			expectedError: "only singular scalar fields can have a default value",
+		},
+		{
+			testName:      "required map",
+			givenSyntax:   SyntaxEdition2023,
+			givenField:    field{Name: "byID", TypeName: "Meta", MapKey: "string", required: true},
+			expectedError: "repeated fields and maps can't be required",
+		},
+	}
+
+	for _, testCase := range testCases {
+		result, err := withPresence(testCase.givenField, testCase.givenSyntax)
+		if testCase.expectedError != "" {
+			assert.EqualError(t, err, testCase.expectedError, testCase.testName)
+			continue
+		}
+		assert.NoError(t, err, testCase.testName)
+		assert.Equal(t, testCase.expectedLabel, result.Label, testCase.testName)
+		assert.Equal(t, testCase.expectedOptions, result.presenceOptions, testCase.testName)
+	}
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// forwardAllTags is the forward rule key keeping every tag.
+const forwardAllTags = "*"
+
+const (
+	// taggerOption is the field option holding the forwarded tags, defined
+	// by taggerImport, which is only imported by files using it
+	taggerOption = "(tagger.tags)"
+	taggerImport = "tagger/tagger.proto"
+)
+
+// TagConfig declares how the struct tags of the fields are processed. Presets
+// name built-in rule sets, applied before Rules; without presets in the config
+// the elasticsearch and tagger presets are used.
+type TagConfig struct {
+	Presets []string  `yaml:"presets"`
+	Rules   []TagRule `yaml:"rules"`
+}
+
+// TagRule is a step of the tag pipeline, doing one of:
+//   - Skip: leaves out the fields whose Key tag has the Skip option, like
+//     elasticsearch:"x,no_source"
+//   - Forward: keeps only the listed tag keys in (tagger.tags), * keeping all
+//     of them; without forward rules no tags are kept
+//   - Option: sets the field option Option to the value of the Key tag
+//   - Rename: names the field after the first option of the Key tag, like
+//     json:"id,omitempty"
+type TagRule struct {
+	Key     string   `yaml:"key"`
+	Skip    string   `yaml:"skip"`
+	Forward []string `yaml:"forward"`
+	Option  string   `yaml:"option"`
+	Rename  bool     `yaml:"rename"`
+}
+
+// tagPresets are the built-in rule sets: elasticsearch leaves out the fields
+// not stored in the _source, tagger forwards every tag to (tagger.tags).
+var tagPresets = map[string][]TagRule{
+	"elasticsearch": {{Key: "elasticsearch", Skip: "no_source"}},
+	"tagger":        {{Forward: []string{forwardAllTags}}},
+}
+
+var defaultTagPresets = []string{"elasticsearch", "tagger"}
+
+func (c TagConfig) validate() error {
+	for _, preset := range c.Presets {
+		if _, ok := tagPresets[preset]; !ok {
+			return fmt.Errorf("presets: unknown preset %q, expected one of %s", preset, strings.Join(tagPresetNames(), ", "))
+		}
+	}
+	for i, rule := range c.Rules {
+		if err := rule.validate(); err != nil {
+			return fmt.Errorf("rules[%d]: %v", i, err)
+		}
+	}
+	return nil
+}
+
+func (r TagRule) validate() error {
+	actions := 0
+	for _, set := range []bool{r.Skip != "", len(r.Forward) > 0, r.Option != "", r.Rename} {
+		if set {
+			actions++
+		}
+	}
+	switch {
+	case actions != 1:
+		return fmt.Errorf("expected exactly one of skip, forward, option or rename")
+	case len(r.Forward) > 0 && r.Key != "":
+		return fmt.Errorf("forward takes no key")
+	case len(r.Forward) == 0 && r.Key == "":
+		return fmt.Errorf("missing key")
+	}
+	return nil
+}
+
+func tagPresetNames() []string {
+	names := make([]string, 0, len(tagPresets))
+	for name := range tagPresets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// rules returns the rules of the presets followed by the configured rules.
+func (c TagConfig) rules() []TagRule {
+	presets := c.Presets
+	if presets == nil {
+		presets = defaultTagPresets
+	}
+	var rules []TagRule
+	for _, preset := range presets {
+		rules = append(rules, tagPresets[preset]...)
+	}
+	return append(rules, c.Rules...)
+}
+
+// skip tells whether a skip rule leaves out the field with the struct tag.
+func (c TagConfig) skip(tag string) bool {
+	for _, rule := range c.rules() {
+		if rule.Skip == "" {
+			continue
+		}
+		value, ok := reflect.StructTag(tag).Lookup(rule.Key)
+		if !ok {
+			continue
+		}
+		for _, option := range strings.Split(value, ",") {
+			if option == rule.Skip {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// name returns the field name given by the first rename rule whose tag is
+// set, or "" if there is none. Tags naming no field, like json:"-", are
+// passed over.
+func (c TagConfig) name(tag string) string {
+	for _, rule := range c.rules() {
+		if !rule.Rename {
+			continue
+		}
+		value, _ := reflect.StructTag(tag).Lookup(rule.Key)
+		name := strings.Split(value, ",")[0]
+		if name != "" && name != "-" {
+			return name
+		}
+	}
+	return ""
+}
+
+// options returns the field options set by the option rules from the struct
+// tag. Booleans and numbers are kept as they are, other values quoted.
+func (c TagConfig) options(tag string) []string {
+	var options []string
+	for _, rule := range c.rules() {
+		if rule.Option == "" {
+			continue
+		}
+		value, ok := reflect.StructTag(tag).Lookup(rule.Key)
+		if !ok {
+			continue
+		}
+		options = append(options, fmt.Sprintf("%s = %s", rule.Option, optionLiteral(value)))
+	}
+	return options
+}
+
+func optionLiteral(value string) string {
+	if value == "true" || value == "false" {
+		return value
+	}
+	if _, err := strconv.ParseFloat(value, 64); err == nil {
+		return value
+	}
+	return strconv.Quote(value)
+}
+
+// forward returns the struct tag with only the keys kept by the forward
+// rules, for (tagger.tags). The go2proto tag is never forwarded.
+func (c TagConfig) forward(tag string) string {
+	keys := make(map[string]struct{})
+	for _, rule := range c.rules() {
+		for _, key := range rule.Forward {
+			keys[key] = struct{}{}
+		}
+	}
+	_, all := keys[forwardAllTags]
+
+	var kept []string
+	for _, part := range splitTag(removeTagKey(tag, go2protoTagKey)) {
+		key, _, _ := strings.Cut(part, ":")
+		if _, ok := keys[key]; ok || all {
+			kept = append(kept, part)
+		}
+	}
+	return strings.Join(kept, " ")
+}
+
+// jsonNameOption returns the json_name option naming the field as its json
+// tag does, if that differs from the name protojson derives from the proto
+// field name.
+func jsonNameOption(fieldName, tag string) (string, bool) {
+	value, ok := reflect.StructTag(tag).Lookup("json")
+	name := strings.Split(value, ",")[0]
+	if !ok || name == "" || name == "-" || name == jsonCamelCase(fieldName) {
+		return "", false
+	}
+	return "json_name = " + strconv.Quote(name), true
+}
+
+// hasOption tells whether options has an option of the given name.
+func hasOption(options []string, name string) bool {
+	for _, option := range options {
+		if optionName(option) == name {
+			return true
+		}
+	}
+	return false
+}
+
+// optionName returns the name of a field option, like deprecated in
+// deprecated = true.
+func optionName(option string) string {
+	name, _, _ := strings.Cut(option, "=")
+	return strings.TrimSpace(name)
+}
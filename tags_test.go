@@ -0,0 +1,183 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTagConfig(t *testing.T) {
+	t.Parallel()
+
+	custom := TagConfig{
+		Presets: []string{},
+		Rules: []TagRule{
+			{Key: "internal", Skip: "yes"},
+			{Forward: []string{"json", "db"}},
+			{Key: "deprecated", Option: "deprecated"},
+			{Key: "jsonname", Option: "json_name"},
+			{Key: "proto", Rename: true},
+			{Key: "json", Rename: true},
+		},
+	}
+
+	var testCases = []struct {
+		testName        string
+		givenConfig     TagConfig
+		givenTag        string
+		expectedSkip    bool
+		expectedName    string
+		expectedOptions []string
+		expectedForward string
+	}{
+		{
+			testName:        "default presets forward all tags",
+			givenTag:        `json:"id" go2proto:"encoding=sint" db:"id"`,
+			expectedForward: `json:"id" db:"id"`,
+		},
+		{
+			testName:        "default presets skip elasticsearch no_source",
+			givenTag:        `json:"id" elasticsearch:"x,no_source"`,
+			expectedSkip:    true,
+			expectedForward: `json:"id" elasticsearch:"x,no_source"`,
+		},
+		{
+			testName:        "no presets",
+			givenConfig:     TagConfig{Presets: []string{}},
+			givenTag:        `json:"id" elasticsearch:"no_source"`,
+			expectedForward: "",
+		},
+		{
+			testName:        "skip rule",
+			givenConfig:     custom,
+			givenTag:        `internal:"yes"`,
+			expectedSkip:    true,
+			expectedForward: "",
+		},
+		{
+			testName:        "forward only keys",
+			givenConfig:     custom,
+			givenTag:        `json:"id,omitempty" db:"order_id" xml:"id"`,
+			expectedName:    "id",
+			expectedForward: `json:"id,omitempty" db:"order_id"`,
+		},
+		{
+			testName:        "options",
+			givenConfig:     custom,
+			givenTag:        `deprecated:"true" jsonname:"ID"`,
+			expectedOptions: []string{"deprecated = true", `json_name = "ID"`},
+		},
+		{
+			testName:        "first rename rule wins",
+			givenConfig:     custom,
+			givenTag:        `json:"old" proto:"legacy"`,
+			expectedName:    "legacy",
+			expectedForward: `json:"old"`,
+		},
+		{
+			testName:        "rename passes over tags naming no field",
+			givenConfig:     custom,
+			givenTag:        `json:"-" proto:",x"`,
+			expectedForward: `json:"-"`,
+		},
+	}
+
+	for _, testCase := range testCases {
+		assert.Equal(t, testCase.expectedSkip, testCase.givenConfig.skip(testCase.givenTag), testCase.testName)
+		assert.Equal(t, testCase.expectedName, testCase.givenConfig.name(testCase.givenTag), testCase.testName)
+		assert.Equal(t, testCase.expectedOptions, testCase.givenConfig.options(testCase.givenTag), testCase.testName)
+		assert.Equal(t, testCase.expectedForward, testCase.givenConfig.forward(testCase.givenTag), testCase.testName)
+	}
+}
+
+func TestTagConfig_Validate(t *testing.T) {
+	t.Parallel()
+
+	var testCases = []struct {
+		testName string
+		given    TagConfig
+		expected string
+	}{
+		{
+			testName: "valid",
+			given: TagConfig{
+				Presets: []string{"tagger"},
+				Rules:   []TagRule{{Key: "json", Rename: true}, {Forward: []string{"json"}}},
+			},
+		},
+		{
+			testName: "unknown preset",
+			given:    TagConfig{Presets: []string{"gorm"}},
+			expected: `presets: unknown preset "gorm", expected one of elasticsearch, tagger`,
+		},
+		{
+			testName: "two actions",
+			given:    TagConfig{Rules: []TagRule{{Key: "json", Rename: true, Option: "json_name"}}},
+			expected: "rules[0]: expected exactly one of skip, forward, option or rename",
+		},
+		{
+			testName: "missing key",
+			given:    TagConfig{Rules: []TagRule{{Forward: []string{"json"}}, {Skip: "-"}}},
+			expected: "rules[1]: missing key",
+		},
+		{
+			testName: "forward with key",
+			given:    TagConfig{Rules: []TagRule{{Key: "json", Forward: []string{"json"}}}},
+			expected: "rules[0]: forward takes no key",
+		},
+	}
+
+	for _, testCase := range testCases {
+		err := testCase.given.validate()
+		if testCase.expected == "" {
+			assert.NoError(t, err, testCase.testName)
+			continue
+		}
+		if assert.Error(t, err, testCase.testName) {
+			assert.Equal(t, testCase.expected, err.Error(), testCase.testName)
+		}
+	}
+}
+
+func TestJSONNameOption(t *testing.T) {
+	t.Parallel()
+
+	var testCases = []struct {
+		testName       string
+		givenFieldName string
+		givenTag       string
+		expected       string
+	}{
+		{
+			testName:       "same as protojson name",
+			givenFieldName: "user_id",
+			givenTag:       `json:"userId,omitempty"`,
+		},
+		{
+			testName:       "differs from protojson name",
+			givenFieldName: "userId",
+			givenTag:       `json:"user_id,omitempty"`,
+			expected:       `json_name = "user_id"`,
+		},
+		{
+			testName:       "no name in tag",
+			givenFieldName: "userId",
+			givenTag:       `json:",omitempty"`,
+		},
+		{
+			testName:       "ignored field",
+			givenFieldName: "userId",
+			givenTag:       `json:"-"`,
+		},
+		{
+			testName:       "no json tag",
+			givenFieldName: "userId",
+			givenTag:       `db:"user_id"`,
+		},
+	}
+
+	for _, testCase := range testCases {
+		result, _ := jsonNameOption(testCase.givenFieldName, testCase.givenTag)
+		assert.Equal(t, testCase.expected, result, testCase.testName)
+	}
+}
@@ -0,0 +1,420 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"text/scanner"
+
+	"github.com/emicklei/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+)
+
+const (
+	maxFieldNumber           = 536870911
+	firstReservedFieldNumber = 19000
+	lastReservedFieldNumber  = 19999
+)
+
+var protoIdentifier = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// protoMapKeyTypes are the scalars allowed as map keys.
+var protoMapKeyTypes = map[string]struct{}{
+	"int32": {}, "int64": {}, "uint32": {}, "uint64": {}, "sint32": {}, "sint64": {},
+	"fixed32": {}, "fixed64": {}, "sfixed32": {}, "sfixed64": {}, "bool": {}, "string": {},
+}
+
+// diagnostic is a problem found in a proto file, at a line and column
+// counting from 1.
+type diagnostic struct {
+	Line    int
+	Column  int
+	Message string
+}
+
+// validationError lists the problems found in a proto file.
+type validationError struct {
+	Filename    string
+	Diagnostics []diagnostic
+}
+
+func (e *validationError) Error() string {
+	lines := make([]string, 0, len(e.Diagnostics))
+	for _, d := range e.Diagnostics {
+		lines = append(lines, fmt.Sprintf("%s:%d:%d: %s", e.Filename, d.Line, d.Column, d.Message))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// validateProto re-parses the proto source src and checks what protoc would
+// reject: type references that don't resolve against the messages and enums
+// of the file and of the well-known types it imports, field numbers that are
+// duplicated, reserved or out of range, duplicate or invalid names, and map
+// keys of types that can't be keys.
+func validateProto(src []byte, filename string) error {
+	definition, err := proto.NewParser(bytes.NewReader(src)).Parse()
+	if err != nil {
+		return fmt.Errorf("%s: %v", filename, err)
+	}
+
+	v := &protoValidator{syntax: SyntaxProto2, types: make(map[string]protoKind)}
+	v.collect(definition)
+	v.check(definition)
+	if len(v.diagnostics) == 0 {
+		return nil
+	}
+	sort.SliceStable(v.diagnostics, func(i, j int) bool {
+		if v.diagnostics[i].Line != v.diagnostics[j].Line {
+			return v.diagnostics[i].Line < v.diagnostics[j].Line
+		}
+		return v.diagnostics[i].Column < v.diagnostics[j].Column
+	})
+	return &validationError{Filename: filename, Diagnostics: v.diagnostics}
+}
+
+type protoKind int
+
+const (
+	protoMessageKind protoKind = iota + 1
+	protoEnumKind
+)
+
+// protoValidator holds the types defined in and imported by the file being
+// validated, keyed by full name, and the problems found so far.
+type protoValidator struct {
+	pkg string
+	// syntax is proto2 unless the file declares another
+	syntax Syntax
+	types  map[string]protoKind
+	// opaqueImports is set when the file imports files whose types aren't
+	// known, so that qualified names can't be checked
+	opaqueImports bool
+	diagnostics   []diagnostic
+}
+
+func (v *protoValidator) report(pos scanner.Position, format string, args ...interface{}) {
+	v.diagnostics = append(v.diagnostics, diagnostic{Line: pos.Line, Column: pos.Column, Message: fmt.Sprintf(format, args...)})
+}
+
+// collect records the package, the types defined in the file and those of
+// the imported files that are known.
+func (v *protoValidator) collect(definition *proto.Proto) {
+	for _, element := range definition.Elements {
+		switch e := element.(type) {
+		case *proto.Syntax:
+			v.syntax = Syntax(e.Value)
+		case *proto.Edition:
+			v.syntax = Syntax(e.Value)
+		case *proto.Package:
+			v.pkg = e.Name
+		case *proto.Import:
+			file, err := protoregistry.GlobalFiles.FindFileByPath(e.Filename)
+			if err != nil {
+				v.opaqueImports = true
+				continue
+			}
+			v.collectDescriptors(file.Messages(), file.Enums())
+		}
+	}
+
+	var collect func(scope string, elements []proto.Visitee)
+	collect = func(scope string, elements []proto.Visitee) {
+		for _, element := range elements {
+			switch e := element.(type) {
+			case *proto.Message:
+				if e.IsExtend {
+					continue
+				}
+				name := qualify(scope, e.Name)
+				v.types[name] = protoMessageKind
+				collect(name, e.Elements)
+			case *proto.Enum:
+				v.types[qualify(scope, e.Name)] = protoEnumKind
+			}
+		}
+	}
+	collect(v.pkg, definition.Elements)
+}
+
+func (v *protoValidator) collectDescriptors(msgs protoreflect.MessageDescriptors, enums protoreflect.EnumDescriptors) {
+	for i := 0; i < msgs.Len(); i++ {
+		v.types[string(msgs.Get(i).FullName())] = protoMessageKind
+		v.collectDescriptors(msgs.Get(i).Messages(), msgs.Get(i).Enums())
+	}
+	for i := 0; i < enums.Len(); i++ {
+		v.types[string(enums.Get(i).FullName())] = protoEnumKind
+	}
+}
+
+func (v *protoValidator) check(definition *proto.Proto) {
+	v.checkNames(definition.Elements, "")
+	for _, element := range definition.Elements {
+		switch e := element.(type) {
+		case *proto.Message:
+			v.checkMessage(v.pkg, e)
+		case *proto.Enum:
+			v.checkEnum(e)
+		case *proto.Service:
+			v.checkService(e)
+		}
+	}
+}
+
+// checkNames reports invalid names and names defined twice in the elements of
+// the file or of message msgName, whose fields, oneofs, nested messages and
+// enums share a scope.
+func (v *protoValidator) checkNames(elements []proto.Visitee, msgName string) {
+	seen := make(map[string]string)
+	add := func(pos scanner.Position, kind, name string) {
+		if !protoIdentifier.MatchString(name) {
+			v.report(pos, "invalid %s name %q", kind, name)
+		}
+		if other, ok := seen[name]; ok {
+			if msgName != "" {
+				v.report(pos, "%s %q of message %s is already defined as a %s", kind, name, msgName, other)
+			} else {
+				v.report(pos, "%s %q is already defined as a %s", kind, name, other)
+			}
+			return
+		}
+		seen[name] = kind
+	}
+	for _, element := range elements {
+		switch e := element.(type) {
+		case *proto.Message:
+			if !e.IsExtend {
+				add(e.Position, "message", e.Name)
+			}
+		case *proto.Enum:
+			add(e.Position, "enum", e.Name)
+		case *proto.Service:
+			add(e.Position, "service", e.Name)
+		case *proto.NormalField:
+			add(e.Position, "field", e.Name)
+		case *proto.MapField:
+			add(e.Position, "field", e.Name)
+		case *proto.Oneof:
+			add(e.Position, "oneof", e.Name)
+			for _, oneofElement := range e.Elements {
+				if member, ok := oneofElement.(*proto.OneOfField); ok {
+					add(member.Position, "field", member.Name)
+				}
+			}
+		}
+	}
+}
+
+func (v *protoValidator) checkMessage(scope string, msg *proto.Message) {
+	if msg.IsExtend {
+		return
+	}
+	name := qualify(scope, msg.Name)
+	displayName := strings.TrimPrefix(name, v.pkg+".")
+	v.checkNames(msg.Elements, displayName)
+
+	reservedNames := make(map[string]struct{})
+	var reservedRanges []proto.Range
+	for _, element := range msg.Elements {
+		if r, ok := element.(*proto.Reserved); ok {
+			reservedRanges = append(reservedRanges, r.Ranges...)
+			for _, fieldName := range r.FieldNames {
+				reservedNames[fieldName] = struct{}{}
+			}
+		}
+	}
+
+	numbers := make(map[int]string)
+	jsonNames := make(map[string]string)
+	checkField := func(f *proto.Field) {
+		pos := f.Position
+		switch number := f.Sequence; {
+		case number < 1 || number > maxFieldNumber:
+			v.report(pos, "field %s.%s has number %d, out of the range 1 to %d", displayName, f.Name, number, maxFieldNumber)
+		case number >= firstReservedFieldNumber && number <= lastReservedFieldNumber:
+			v.report(pos, "field %s.%s has number %d, reserved for the protobuf implementation", displayName, f.Name, number)
+		default:
+			if other, ok := numbers[number]; ok {
+				v.report(pos, "field %s.%s has number %d, already used by %s", displayName, f.Name, number, other)
+			}
+			numbers[number] = f.Name
+			for _, r := range reservedRanges {
+				if number >= r.From && (r.Max || number <= r.To) {
+					v.report(pos, "field %s.%s has number %d, which is reserved", displayName, f.Name, number)
+				}
+			}
+		}
+		if _, ok := reservedNames[f.Name]; ok {
+			v.report(pos, "field %s.%s has a reserved name", displayName, f.Name)
+		}
+		jsonName := jsonCamelCase(f.Name)
+		for _, option := range f.Options {
+			if option.Name == "json_name" {
+				jsonName = option.Constant.Source
+			}
+		}
+		// fields of the same name are reported by checkNames
+		if other, ok := jsonNames[jsonName]; ok && other != f.Name {
+			v.report(pos, "field %s.%s has JSON name %q, already used by %s", displayName, f.Name, jsonName, other)
+		}
+		jsonNames[jsonName] = f.Name
+	}
+
+	for _, element := range msg.Elements {
+		switch e := element.(type) {
+		case *proto.NormalField:
+			checkField(e.Field)
+			v.checkLabel(e, displayName)
+			v.checkType(e.Position, name, e.Type, "field "+displayName+"."+e.Name, false)
+		case *proto.MapField:
+			checkField(e.Field)
+			if _, ok := protoMapKeyTypes[e.KeyType]; !ok {
+				v.report(e.Position, "field %s.%s has map key type %s, expected an integer, bool or string", displayName, e.Name, e.KeyType)
+			}
+			v.checkType(e.Position, name, e.Type, "field "+displayName+"."+e.Name, false)
+		case *proto.Oneof:
+			for _, oneofElement := range e.Elements {
+				if member, ok := oneofElement.(*proto.OneOfField); ok {
+					checkField(member.Field)
+					v.checkType(member.Position, name, member.Type, "field "+displayName+"."+member.Name, false)
+				}
+			}
+		case *proto.Message:
+			v.checkMessage(name, e)
+		case *proto.Enum:
+			v.checkEnum(e)
+		}
+	}
+}
+
+// checkLabel reports the labels the syntax of the file doesn't allow, and
+// missing labels in proto2.
+func (v *protoValidator) checkLabel(f *proto.NormalField, msgName string) {
+	hasDefault := false
+	for _, option := range f.Options {
+		hasDefault = hasDefault || option.Name == defaultOption
+	}
+	switch {
+	case v.syntax == SyntaxProto2 && !f.Optional && !f.Required && !f.Repeated:
+		v.report(f.Position, "field %s.%s has no label, expected optional, required or repeated in proto2", msgName, f.Name)
+	case v.syntax.isEdition() && (f.Optional || f.Required):
+		v.report(f.Position, "field %s.%s has a label, which editions replace with %s", msgName, f.Name, fieldPresenceFeature)
+	case v.syntax.isProto3() && f.Required:
+		v.report(f.Position, "field %s.%s is required, which proto3 doesn't support", msgName, f.Name)
+	case v.syntax.isProto3() && hasDefault:
+		v.report(f.Position, "field %s.%s has a default value, which proto3 doesn't support", msgName, f.Name)
+	}
+}
+
+// checkEnum reports invalid and duplicate values. Open enums, those of proto3
+// and of editions unless closed, must start at 0.
+func (v *protoValidator) checkEnum(enum *proto.Enum) {
+	open := v.syntax != SyntaxProto2
+	for _, element := range enum.Elements {
+		if option, ok := element.(*proto.Option); ok && option.Name == "features.enum_type" {
+			open = option.Constant.Source != "CLOSED"
+		}
+	}
+	first := true
+	seen := make(map[string]struct{})
+	for _, element := range enum.Elements {
+		value, ok := element.(*proto.EnumField)
+		if !ok {
+			continue
+		}
+		pos := value.Position
+		if first && open && value.Integer != 0 {
+			v.report(pos, "enum %s starts with %s = %d, the first value must be 0", enum.Name, value.Name, value.Integer)
+		}
+		first = false
+		if !protoIdentifier.MatchString(value.Name) {
+			v.report(pos, "invalid enum value name %q", value.Name)
+		}
+		if _, ok := seen[value.Name]; ok {
+			v.report(pos, "enum value %q of %s is already defined", value.Name, enum.Name)
+		}
+		seen[value.Name] = struct{}{}
+	}
+}
+
+func (v *protoValidator) checkService(svc *proto.Service) {
+	seen := make(map[string]struct{})
+	for _, element := range svc.Elements {
+		r, ok := element.(*proto.RPC)
+		if !ok {
+			continue
+		}
+		pos := r.Position
+		if !protoIdentifier.MatchString(r.Name) {
+			v.report(pos, "invalid rpc name %q", r.Name)
+		}
+		if _, ok := seen[r.Name]; ok {
+			v.report(pos, "rpc %q of service %s is already defined", r.Name, svc.Name)
+		}
+		seen[r.Name] = struct{}{}
+		v.checkType(pos, v.pkg, r.RequestType, "rpc "+svc.Name+"."+r.Name+" request", true)
+		v.checkType(pos, v.pkg, r.ReturnsType, "rpc "+svc.Name+"."+r.Name+" response", true)
+	}
+}
+
+// checkType reports typeName, referred to by what from within scope, if it
+// doesn't resolve to a message or enum, or to a message when messageOnly.
+func (v *protoValidator) checkType(pos scanner.Position, scope, typeName, what string, messageOnly bool) {
+	if _, ok := protoScalarTypes[typeName]; ok {
+		if messageOnly {
+			v.report(pos, "%s has type %s, expected a message", what, typeName)
+		}
+		return
+	}
+	kind, ok := v.resolve(scope, typeName)
+	switch {
+	case !ok && v.opaqueImports && strings.Contains(typeName, "."):
+		// it may be defined by an import whose types aren't known
+	case !ok:
+		v.report(pos, "%s has undefined type %s", what, typeName)
+	case messageOnly && kind != protoMessageKind:
+		v.report(pos, "%s has type %s, expected a message", what, typeName)
+	}
+}
+
+// resolve looks typeName up from within scope, see resolveName.
+func (v *protoValidator) resolve(scope, typeName string) (protoKind, bool) {
+	name, ok := resolveName(scope, typeName, func(name string) bool {
+		_, ok := v.types[name]
+		return ok
+	})
+	return v.types[name], ok
+}
+
+// resolveName returns the full name typeName refers to from within message
+// scope, looking in the scope and then in its parents like protoc, and whether
+// defined knows it. A leading dot makes typeName a full name already. Names
+// that aren't defined are returned as written.
+func resolveName(scope, typeName string, defined func(fullName string) bool) (string, bool) {
+	if strings.HasPrefix(typeName, ".") {
+		return typeName[1:], defined(typeName[1:])
+	}
+	for {
+		name := qualify(scope, typeName)
+		if defined(name) {
+			return name, true
+		}
+		if scope == "" {
+			return typeName, false
+		}
+		if i := strings.LastIndexByte(scope, '.'); i >= 0 {
+			scope = scope[:i]
+		} else {
+			scope = ""
+		}
+	}
+}
+
+func qualify(scope, name string) string {
+	if scope == "" {
+		return name
+	}
+	return scope + "." + name
+}
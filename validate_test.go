@@ -0,0 +1,221 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateProto(t *testing.T) {
+	t.Parallel()
+
+	var testCases = []struct {
+		testName string
+		given    string
+		expected string
+	}{
+		{
+			testName: "valid",
+			given: `syntax = "proto3";
+package proto;
+
+import "google/protobuf/timestamp.proto";
+import "tagger/tagger.proto";
+
+message Order {
+  google.protobuf.Timestamp created = 1 [(tagger.tags) = "json:\"created\""];
+  repeated Line lines = 2;
+  map<string, Line> byID = 3;
+  oneof main {
+    Meta meta = 4;
+    Status status = 5;
+  }
+  reserved 6, 8 to 10;
+  reserved "old";
+  message Meta {
+    Line line = 1;
+  }
+}
+
+message Line {
+  uint32 qty = 1;
+  Order.Meta meta = 2;
+  other.Type external = 3;
+}
+
+enum Status {
+  UNKNOWN = 0;
+  DONE = 1;
+}
+
+service Orders {
+  rpc Get(Line) returns (Order);
+}
+`,
+		},
+		{
+			testName: "undefined types",
+			given: `syntax = "proto3";
+package proto;
+
+message Order {
+  Line line = 1;
+  map<string, Meta> meta = 2;
+  google.protobuf.Timestamp created = 3;
+}
+
+service Orders {
+  rpc Get(Order) returns (Missing);
+}
+`,
+			expected: `output.proto:5:3: field Order.line has undefined type Line
+output.proto:6:3: field Order.meta has undefined type Meta
+output.proto:7:3: field Order.created has undefined type google.protobuf.Timestamp
+output.proto:11:3: rpc Orders.Get response has undefined type Missing`,
+		},
+		{
+			testName: "numbers",
+			given: `syntax = "proto3";
+package proto;
+
+message Order {
+  string a = 1;
+  string b = 1;
+  oneof c {
+    string d = 2;
+    string e = 19000;
+  }
+  string f = 0;
+  string g = 5;
+  string old = 7;
+  reserved 5;
+  reserved "old";
+}
+`,
+			expected: `output.proto:6:3: field Order.b has number 1, already used by a
+output.proto:9:5: field Order.e has number 19000, reserved for the protobuf implementation
+output.proto:11:3: field Order.f has number 0, out of the range 1 to 536870911
+output.proto:12:3: field Order.g has number 5, which is reserved
+output.proto:13:3: field Order.old has a reserved name`,
+		},
+		{
+			testName: "json names",
+			given: `syntax = "proto3";
+package proto;
+
+message Order {
+  string user_id = 1;
+  string userId = 2;
+  string id = 3 [json_name = "orderId"];
+  string order_id = 4;
+}
+`,
+			expected: `output.proto:6:3: field Order.userId has JSON name "userId", already used by user_id
+output.proto:8:3: field Order.order_id has JSON name "orderId", already used by id`,
+		},
+		{
+			testName: "proto2",
+			given: `syntax = "proto2";
+package proto;
+
+message Order {
+  optional string a = 1;
+  string b = 2;
+  required int32 c = 3 [default = 1];
+  repeated string d = 4;
+  map<string, string> e = 5;
+}
+
+enum Status {
+  DONE = 1;
+}
+`,
+			expected: `output.proto:6:3: field Order.b has no label, expected optional, required or repeated in proto2`,
+		},
+		{
+			testName: "proto3 labels",
+			given: `syntax = "proto3";
+package proto;
+
+message Order {
+  optional string a = 1;
+  required string b = 2;
+  int32 c = 3 [default = 1];
+}
+`,
+			expected: `output.proto:6:12: field Order.b is required, which proto3 doesn't support
+output.proto:7:3: field Order.c has a default value, which proto3 doesn't support`,
+		},
+		{
+			testName: "edition",
+			given: `edition = "2023";
+package proto;
+
+option features.field_presence = IMPLICIT;
+
+message Order {
+  string a = 1 [features.field_presence = EXPLICIT, default = "x"];
+  optional string b = 2;
+}
+
+enum Open {
+  A = 1;
+}
+
+enum Closed {
+  option features.enum_type = CLOSED;
+  B = 1;
+}
+`,
+			expected: `output.proto:8:12: field Order.b has a label, which editions replace with features.field_presence
+output.proto:12:3: enum Open starts with A = 1, the first value must be 0`,
+		},
+		{
+			testName: "names",
+			given: `syntax = "proto3";
+package proto;
+
+message Order {
+  string a = 1;
+  int32 a = 2;
+  map<double, string> m = 3;
+  message a {
+  }
+}
+
+message Order {
+}
+
+enum Status {
+  DONE = 1;
+  DONE = 2;
+}
+
+service Orders {
+  rpc Get(Order) returns (string);
+  rpc Get(Order) returns (Status);
+}
+`,
+			expected: `output.proto:6:3: field "a" of message Order is already defined as a field
+output.proto:7:3: field Order.m has map key type double, expected an integer, bool or string
+output.proto:8:3: message "a" of message Order is already defined as a field
+output.proto:12:1: message "Order" is already defined as a message
+output.proto:16:3: enum Status starts with DONE = 1, the first value must be 0
+output.proto:17:3: enum value "DONE" of Status is already defined
+output.proto:21:3: rpc Orders.Get response has type string, expected a message
+output.proto:22:3: rpc "Get" of service Orders is already defined
+output.proto:22:3: rpc Orders.Get response has type Status, expected a message`,
+		},
+	}
+
+	for _, testCase := range testCases {
+		err := validateProto([]byte(testCase.given), "output.proto")
+		if testCase.expected == "" {
+			assert.NoError(t, err, testCase.testName)
+			continue
+		}
+		if assert.Error(t, err, testCase.testName) {
+			assert.Equal(t, testCase.expected, err.Error(), testCase.testName)
+		}
+	}
+}
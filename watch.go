@@ -0,0 +1,348 @@
+package main
+
+import (
+	"fmt"
+	"go/token"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/tools/go/packages"
+)
+
+const (
+	// watchInterval is how often the Go files are checked for changes
+	watchInterval = 500 * time.Millisecond
+	// watchDebounce is how long the files must stay unchanged before the
+	// output is regenerated, so that a save touching several files
+	// regenerates once
+	watchDebounce = 300 * time.Millisecond
+)
+
+// fileStamp identifies the version of a file.
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+// watchPackages regenerates the output whenever the Go files of pkgs change,
+// reloading only the packages affected. It runs until the process is
+// stopped; failed generations, including output that doesn't validate, are
+// logged and the previous output is kept.
+func watchPackages(g *generator, pwd string, fset *token.FileSet, pkgs []*packages.Package, msgs []message) {
+	// the output numbers the fields of the next generations, so that their
+	// numbers don't change between them
+	g.currProtoFileName = g.outFileName()
+
+	stamps := statFiles(watchedFiles(pkgs))
+	log.Printf("watching %d files of %d packages", len(stamps), len(pkgs))
+	for {
+		changed := waitForChanges(pkgs, stamps)
+		reloaded, affected, regenerated, err := g.regenerate(pwd, fset, pkgs, changed)
+		pkgs = reloaded
+		// the reloaded packages may have new files
+		stamps = statFiles(watchedFiles(pkgs))
+		if err != nil {
+			log.Print(err)
+			continue
+		}
+
+		summary := summarizeChanges(msgs, regenerated)
+		msgs = regenerated
+		log.Printf("regenerated %s after changes to %s", g.outFileName(), strings.Join(packagePaths(affected), ", "))
+		if len(summary) == 0 {
+			log.Print("  no message changes")
+		}
+		for _, line := range summary {
+			log.Print("  " + line)
+		}
+	}
+}
+
+// regenerate reloads the packages of pkgs affected by the changed files and
+// generates the output again. The packages are returned reloaded even if the
+// generation fails, in which case the output isn't written.
+func (g *generator) regenerate(pwd string, fset *token.FileSet, pkgs []*packages.Package, changed []string) (reloaded, affected []*packages.Package, msgs []message, err error) {
+	affected = affectedPackages(pkgs, changed)
+	reloaded, err = reloadPackages(pwd, fset, pkgs, affected)
+	if err != nil {
+		return pkgs, affected, nil, err
+	}
+	msgs, err = g.generate(reloaded)
+	return reloaded, affected, msgs, err
+}
+
+// waitForChanges returns the files that changed from stamps once they stay
+// unchanged for watchDebounce.
+func waitForChanges(pkgs []*packages.Package, stamps map[string]fileStamp) []string {
+	var changed []string
+	for {
+		time.Sleep(watchInterval)
+		current := statFiles(watchedFiles(pkgs))
+		changed = changedFiles(stamps, current)
+		if len(changed) == 0 {
+			continue
+		}
+		for {
+			time.Sleep(watchDebounce)
+			settled := statFiles(watchedFiles(pkgs))
+			if len(changedFiles(current, settled)) == 0 {
+				return changedFiles(stamps, settled)
+			}
+			current = settled
+		}
+	}
+}
+
+// watchedFiles returns the Go files of pkgs and the other non-test Go files
+// of their directories, which are new files of the packages.
+func watchedFiles(pkgs []*packages.Package) []string {
+	seen := make(map[string]struct{})
+	var files []string
+	add := func(file string) {
+		if _, ok := seen[file]; !ok {
+			seen[file] = struct{}{}
+			files = append(files, file)
+		}
+	}
+	for _, p := range pkgs {
+		for _, file := range p.GoFiles {
+			add(file)
+		}
+		for _, dir := range packageDirs(p) {
+			matches, _ := filepath.Glob(filepath.Join(dir, "*.go"))
+			for _, file := range matches {
+				if !strings.HasSuffix(file, "_test.go") {
+					add(file)
+				}
+			}
+		}
+	}
+	sort.Strings(files)
+	return files
+}
+
+func packageDirs(p *packages.Package) []string {
+	seen := make(map[string]struct{})
+	var dirs []string
+	for _, file := range p.GoFiles {
+		dir := filepath.Dir(file)
+		if _, ok := seen[dir]; !ok {
+			seen[dir] = struct{}{}
+			dirs = append(dirs, dir)
+		}
+	}
+	return dirs
+}
+
+// statFiles returns the stamps of files, leaving out those that don't exist.
+func statFiles(files []string) map[string]fileStamp {
+	stamps := make(map[string]fileStamp, len(files))
+	for _, file := range files {
+		info, err := os.Stat(file)
+		if err != nil {
+			continue
+		}
+		stamps[file] = fileStamp{modTime: info.ModTime(), size: info.Size()}
+	}
+	return stamps
+}
+
+// changedFiles returns the files added, removed or modified from before to
+// after, sorted.
+func changedFiles(before, after map[string]fileStamp) []string {
+	var changed []string
+	for file, stamp := range after {
+		if previous, ok := before[file]; !ok || previous != stamp {
+			changed = append(changed, file)
+		}
+	}
+	for file := range before {
+		if _, ok := after[file]; !ok {
+			changed = append(changed, file)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
+
+// affectedPackages returns the packages of pkgs in the directories of the
+// changed files, and the packages of pkgs importing them, directly or not,
+// since their types refer to those of the changed packages.
+func affectedPackages(pkgs []*packages.Package, changed []string) []*packages.Package {
+	affected := make(map[string]struct{})
+	changedDirs := make(map[string]struct{}, len(changed))
+	for _, file := range changed {
+		changedDirs[filepath.Dir(file)] = struct{}{}
+	}
+	for _, p := range pkgs {
+		for _, dir := range packageDirs(p) {
+			if _, ok := changedDirs[dir]; ok {
+				affected[p.PkgPath] = struct{}{}
+			}
+		}
+	}
+
+	for added := true; added; {
+		added = false
+		for _, p := range pkgs {
+			if _, ok := affected[p.PkgPath]; ok || p.Types == nil {
+				continue
+			}
+			for _, imported := range p.Types.Imports() {
+				if _, ok := affected[imported.Path()]; ok {
+					affected[p.PkgPath] = struct{}{}
+					added = true
+					break
+				}
+			}
+		}
+	}
+
+	var out []*packages.Package
+	for _, p := range pkgs {
+		if _, ok := affected[p.PkgPath]; ok {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+// reloadPackages loads the affected packages again and returns pkgs with
+// them replaced, in the same order.
+func reloadPackages(pwd string, fset *token.FileSet, pkgs, affected []*packages.Package) ([]*packages.Package, error) {
+	if len(affected) == 0 {
+		return pkgs, nil
+	}
+	loaded, err := loadPackages(pwd, fset, packagePaths(affected))
+	if err != nil {
+		return nil, err
+	}
+	byPath := make(map[string]*packages.Package, len(loaded))
+	for _, p := range loaded {
+		if len(p.Errors) > 0 {
+			return nil, fmt.Errorf("%s: %v", p.PkgPath, p.Errors[0])
+		}
+		byPath[p.PkgPath] = p
+	}
+	out := make([]*packages.Package, len(pkgs))
+	for i, p := range pkgs {
+		out[i] = p
+		if reloaded, ok := byPath[p.PkgPath]; ok {
+			out[i] = reloaded
+		}
+	}
+	return out, nil
+}
+
+func packagePaths(pkgs []*packages.Package) []string {
+	paths := make([]string, len(pkgs))
+	for i, p := range pkgs {
+		paths[i] = p.PkgPath
+	}
+	return paths
+}
+
+// summarizeChanges lists the messages and fields added, removed or changed
+// from before to after, one per line like "+ Order.total int64 = 5".
+func summarizeChanges(before, after []message) []string {
+	beforeFields, afterFields := messageFields(before, fieldSummary), messageFields(after, fieldSummary)
+	var lines []string
+	for _, name := range sortedKeys(afterFields) {
+		if _, ok := beforeFields[name]; !ok {
+			lines = append(lines, "+ message "+name)
+		}
+	}
+	for _, name := range sortedKeys(beforeFields) {
+		if _, ok := afterFields[name]; !ok {
+			lines = append(lines, "- message "+name)
+		}
+	}
+	for _, name := range sortedKeys(afterFields) {
+		previous, ok := beforeFields[name]
+		if !ok {
+			continue
+		}
+		fields := afterFields[name]
+		for _, fieldName := range sortedKeys(fields) {
+			was, ok := previous[fieldName]
+			switch {
+			case !ok:
+				lines = append(lines, fmt.Sprintf("+ %s.%s %s", name, fieldName, fields[fieldName]))
+			case was != fields[fieldName]:
+				lines = append(lines, fmt.Sprintf("~ %s.%s %s, was %s", name, fieldName, fields[fieldName], was))
+			}
+		}
+		for _, fieldName := range sortedKeys(previous) {
+			if _, ok := fields[fieldName]; !ok {
+				lines = append(lines, fmt.Sprintf("- %s.%s", name, fieldName))
+			}
+		}
+	}
+	return lines
+}
+
+// messageFields describes the fields of msgs and their nested messages with
+// describe, keyed by message full name and field name. Oneof members are
+// fields of the message.
+func messageFields(msgs []message, describe func(field) string) map[string]map[string]string {
+	out := make(map[string]map[string]string)
+	var add func(msgs []message)
+	add = func(msgs []message) {
+		for _, msg := range msgs {
+			fields := make(map[string]string)
+			var addFields func(fs []field)
+			addFields = func(fs []field) {
+				for _, f := range fs {
+					if len(f.Oneof) > 0 {
+						addFields(f.Oneof)
+						continue
+					}
+					fields[f.Name] = describe(f)
+				}
+			}
+			addFields(msg.Fields)
+			out[msg.fullName] = fields
+			add(msg.Nested)
+		}
+	}
+	add(msgs)
+	return out
+}
+
+// fieldSummary describes the type and number of f, like repeated string = 2.
+func fieldSummary(f field) string {
+	typeName := fieldType(f)
+	if f.Label != "" {
+		typeName = f.Label + " " + typeName
+	}
+	return fmt.Sprintf("%s = %d", typeName, f.Order)
+}
+
+// fieldType describes the type of f, like repeated string or
+// map<string, int64>, leaving out its label.
+func fieldType(f field) string {
+	return describeType(f.TypeName, f.IsRepeated, f.MapKey)
+}
+
+func describeType(typeName string, repeated bool, mapKey string) string {
+	switch {
+	case mapKey != "":
+		return fmt.Sprintf("map<%s, %s>", mapKey, typeName)
+	case repeated:
+		return "repeated " + typeName
+	}
+	return typeName
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"go/token"
+	"go/types"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/tools/go/packages"
+)
+
+func TestChangedFiles(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	before := map[string]fileStamp{
+		"a.go": {modTime: now, size: 10},
+		"b.go": {modTime: now, size: 10},
+		"c.go": {modTime: now, size: 10},
+	}
+	after := map[string]fileStamp{
+		"a.go": {modTime: now, size: 10},
+		"b.go": {modTime: now.Add(time.Second), size: 10},
+		"d.go": {modTime: now, size: 1},
+	}
+	assert.Equal(t, []string{"b.go", "c.go", "d.go"}, changedFiles(before, after))
+	assert.Empty(t, changedFiles(before, before))
+}
+
+func TestAffectedPackages(t *testing.T) {
+	t.Parallel()
+
+	newPackage := func(path, dir string, imports ...*packages.Package) *packages.Package {
+		p := &packages.Package{PkgPath: path, GoFiles: []string{dir + "/x.go"}, Types: types.NewPackage(path, "x")}
+		var imported []*types.Package
+		for _, i := range imports {
+			imported = append(imported, i.Types)
+		}
+		p.Types.SetImports(imported)
+		return p
+	}
+	a := newPackage("m/a", "/m/a")
+	b := newPackage("m/b", "/m/b", a)
+	c := newPackage("m/c", "/m/c", b)
+	d := newPackage("m/d", "/m/d")
+	pkgs := []*packages.Package{a, b, c, d}
+
+	var testCases = []struct {
+		testName string
+		given    []string
+		expected []string
+	}{
+		{testName: "imported by others", given: []string{"/m/a/x.go"}, expected: []string{"m/a", "m/b", "m/c"}},
+		{testName: "imported by none", given: []string{"/m/c/y.go"}, expected: []string{"m/c"}},
+		{testName: "not a package", given: []string{"/m/e/x.go"}, expected: []string{}},
+	}
+
+	for _, testCase := range testCases {
+		assert.Equal(t, testCase.expected, packagePaths(affectedPackages(pkgs, testCase.given)), testCase.testName)
+	}
+}
+
+func TestSummarizeChanges(t *testing.T) {
+	t.Parallel()
+
+	before := []message{
+		{Name: "Order", fullName: "Order", Fields: []field{
+			{Name: "id", TypeName: "string", Order: 1},
+			{Name: "total", TypeName: "int64", Order: 2},
+			{Name: "shape", Oneof: []field{{Name: "circle", TypeName: "Circle", Order: 3}}},
+		}},
+		{Name: "Old", fullName: "Old"},
+	}
+	after := []message{
+		{Name: "Order", fullName: "Order", Fields: []field{
+			{Name: "id", TypeName: "string", Order: 1},
+			{Name: "total", TypeName: "uint64", IsRepeated: true, Order: 2},
+			{Name: "tags", TypeName: "string", MapKey: "string", Order: 4},
+		}, Nested: []message{{Name: "Meta", fullName: "Order.Meta"}}},
+	}
+
+	assert.Equal(t, []string{
+		"+ message Order.Meta",
+		"- message Old",
+		"+ Order.tags map<string, string> = 4",
+		"~ Order.total repeated uint64 = 2, was int64 = 2",
+		"- Order.circle",
+	}, summarizeChanges(before, after))
+	assert.Empty(t, summarizeChanges(after, after))
+}
+
+func TestGenerator_Regenerate(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	goFileName := filepath.Join(dir, "m.go")
+	write := func(name, src string) {
+		t.Helper()
+		if err := os.WriteFile(name, []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(filepath.Join(dir, "go.mod"), "module example.com/m\n\ngo 1.26\n")
+	write(goFileName, "package m\n\ntype Order struct {\n\tID string\n}\n")
+
+	fset := token.NewFileSet()
+	pkgs, err := loadPackages(dir, fset, []string{"."})
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := &generator{protoFolder: t.TempDir()}
+	if _, err := g.generate(pkgs); err != nil {
+		t.Fatal(err)
+	}
+	g.currProtoFileName = g.outFileName()
+	previous, err := os.ReadFile(g.outFileName())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// an edit generating an invalid proto keeps the previous output
+	write(goFileName, "package m\n\ntype Order struct {\n\tID string\n\t//go2proto:type=Missing\n\tRef string\n}\n")
+	pkgs, _, _, err = g.regenerate(dir, fset, pkgs, []string{goFileName})
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "undefined type Missing")
+	}
+	result, err := os.ReadFile(g.outFileName())
+	assert.NoError(t, err)
+	assert.Equal(t, string(previous), string(result))
+
+	// fixing it regenerates the output
+	write(goFileName, "package m\n\ntype Order struct {\n\tID string\n\tRef string\n}\n")
+	_, affected, msgs, err := g.regenerate(dir, fset, pkgs, []string{goFileName})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"example.com/m"}, packagePaths(affected))
+	assert.Len(t, msgs, 1)
+	result, err = os.ReadFile(g.outFileName())
+	assert.NoError(t, err)
+	assert.Contains(t, string(result), "string ref = 2;")
+}